@@ -0,0 +1,268 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/oltur/mvp-match/model"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StorageMemory = "memory"
+	StorageSqlite = "sqlite"
+)
+
+// Config holds the application settings read from the environment
+type Config struct {
+	// Storage is the storage backend, "memory" or "sqlite"
+	Storage string
+	// SqlitePath is the database file used by the "sqlite" backend
+	SqlitePath string
+	// Seed loads the demo users, products and coins into an empty store
+	Seed bool
+	// ChangeStrategy is the name of the change-making strategy of the machine
+	ChangeStrategy string
+	// RefundPolicy decides if a reset pays change or gives back the inserted coins
+	RefundPolicy string
+	// CurrencyCode is reported in deposit and buy responses
+	CurrencyCode string
+	// CoinValues are the accepted coins, in the smallest currency unit
+	CoinValues []int
+	// SmallestUnit is the step of product costs
+	SmallestUnit int
+	// IdempotencyRetention is how long the responses of requests with an Idempotency-Key are replayed
+	IdempotencyRetention time.Duration
+	// EventLogPath is the file of the event log, the events are kept in memory if empty
+	EventLogPath string
+	// JwtKeys are the token signing keys as kid:secret pairs separated by commas, the first one signs new tokens
+	// and the others are only accepted, so keys can be rotated without logging everyone out
+	JwtKeys string
+	// JwtKeysFile is a file with one kid:secret pair per line, used instead of JwtKeys if set
+	JwtKeysFile string
+	// JwtPemKeys are RSA or EC P-256 keys in PEM files as kid:path pairs separated by commas. The first private key
+	// signs new tokens with RS256 or ES256 instead of the secrets, public keys only verify tokens.
+	JwtPemKeys string
+	// JwtIssuer and JwtAudience are put into the tokens and required from them
+	JwtIssuer   string
+	JwtAudience string
+	// AccessTokenTtl is how long an access token is valid, a refresh token gets a new one
+	AccessTokenTtl time.Duration
+	// SessionTtl is how long a session can be refreshed after the login
+	SessionTtl time.Duration
+	// PasswordHash is the password hashing algorithm, bcrypt or argon2id, and PasswordHashCost its cost: the bcrypt
+	// cost or the argon2id passes, zero for the default of the algorithm
+	PasswordHash     string
+	PasswordHashCost int
+	// PasswordMinLength and PasswordMinClasses are the least characters and character classes of new passwords,
+	// of lower case and upper case letters, digits and the others, PasswordRejectCommon rejects common passwords
+	PasswordMinLength    int
+	PasswordMinClasses   int
+	PasswordRejectCommon bool
+	// LoginMaxFailures and LoginMaxIpFailures are the failed logins allowed for an account and a client IP before
+	// they are locked out for LoginLockout, every further failure doubles the lockout up to LoginMaxLockout.
+	// The failures are forgotten after LoginFailureWindow without any.
+	LoginMaxFailures   int
+	LoginMaxIpFailures int
+	LoginLockout       time.Duration
+	LoginMaxLockout    time.Duration
+	LoginFailureWindow time.Duration
+}
+
+// Default returns the default configuration
+func Default() (res *Config) {
+	res = &Config{
+		Storage:        StorageMemory,
+		SqlitePath:     "mvp-match.db",
+		Seed:           true,
+		ChangeStrategy: model.ChangeStrategyMinCoins,
+		RefundPolicy:   model.RefundPolicyChange,
+		CurrencyCode:   "EUR",
+		CoinValues:     []int{5, 10, 20, 50, 100},
+		SmallestUnit:   5,
+
+		IdempotencyRetention: 24 * time.Hour,
+
+		JwtIssuer:   "mvp-match",
+		JwtAudience: "mvp-match",
+
+		AccessTokenTtl: 15 * time.Minute,
+		SessionTtl:     30 * 24 * time.Hour,
+
+		PasswordHash: model.PasswordHashBcrypt,
+
+		PasswordMinLength:    8,
+		PasswordMinClasses:   2,
+		PasswordRejectCommon: true,
+
+		LoginMaxFailures:   5,
+		LoginMaxIpFailures: 20,
+		LoginLockout:       time.Minute,
+		LoginMaxLockout:    time.Hour,
+		LoginFailureWindow: time.Hour,
+	}
+	return
+}
+
+// FromEnv reads the configuration from environment variables, using defaults for missing ones.
+// The error lists every variable whose value cannot be parsed.
+func FromEnv() (res *Config, err error) {
+	env := &envReader{}
+	res = Default()
+	res.Storage = env.string("MVP_STORAGE", res.Storage)
+	res.SqlitePath = env.string("MVP_SQLITE_PATH", res.SqlitePath)
+	res.Seed = env.bool("MVP_SEED", res.Seed)
+	res.ChangeStrategy = env.string("MVP_CHANGE_STRATEGY", res.ChangeStrategy)
+	res.RefundPolicy = env.string("MVP_REFUND_POLICY", res.RefundPolicy)
+	res.CurrencyCode = env.string("MVP_CURRENCY", res.CurrencyCode)
+	res.CoinValues = env.ints("MVP_COIN_VALUES", res.CoinValues)
+	res.SmallestUnit = env.int("MVP_SMALLEST_UNIT", res.SmallestUnit)
+	res.IdempotencyRetention = env.duration("MVP_IDEMPOTENCY_RETENTION", res.IdempotencyRetention)
+	res.EventLogPath = env.string("MVP_EVENT_LOG_PATH", res.EventLogPath)
+	res.JwtKeys = env.string("MVP_JWT_KEYS", res.JwtKeys)
+	res.JwtKeysFile = env.string("MVP_JWT_KEYS_FILE", res.JwtKeysFile)
+	res.JwtPemKeys = env.string("MVP_JWT_PEM_KEYS", res.JwtPemKeys)
+	res.JwtIssuer = env.string("MVP_JWT_ISSUER", res.JwtIssuer)
+	res.JwtAudience = env.string("MVP_JWT_AUDIENCE", res.JwtAudience)
+	res.AccessTokenTtl = env.duration("MVP_ACCESS_TOKEN_TTL", res.AccessTokenTtl)
+	res.SessionTtl = env.duration("MVP_SESSION_TTL", res.SessionTtl)
+	res.PasswordHash = env.string("MVP_PASSWORD_HASH", res.PasswordHash)
+	res.PasswordHashCost = env.int("MVP_PASSWORD_HASH_COST", res.PasswordHashCost)
+	res.PasswordMinLength = env.int("MVP_PASSWORD_MIN_LENGTH", res.PasswordMinLength)
+	res.PasswordMinClasses = env.int("MVP_PASSWORD_MIN_CLASSES", res.PasswordMinClasses)
+	res.PasswordRejectCommon = env.bool("MVP_PASSWORD_REJECT_COMMON", res.PasswordRejectCommon)
+	res.LoginMaxFailures = env.int("MVP_LOGIN_MAX_FAILURES", res.LoginMaxFailures)
+	res.LoginMaxIpFailures = env.int("MVP_LOGIN_MAX_IP_FAILURES", res.LoginMaxIpFailures)
+	res.LoginLockout = env.duration("MVP_LOGIN_LOCKOUT", res.LoginLockout)
+	res.LoginMaxLockout = env.duration("MVP_LOGIN_MAX_LOCKOUT", res.LoginMaxLockout)
+	res.LoginFailureWindow = env.duration("MVP_LOGIN_FAILURE_WINDOW", res.LoginFailureWindow)
+	err = errors.Join(env.errs...)
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// EventStore opens the configured event log
+func (a *Config) EventStore() (model.EventStore, error) {
+	if a.EventLogPath == "" {
+		return model.NewMemoryEventStore(), nil
+	}
+	return model.NewFileEventStore(a.EventLogPath)
+}
+
+// PasswordPolicy returns the policy of the new passwords
+func (a *Config) PasswordPolicy() (res *model.PasswordPolicy, err error) {
+	res = &model.PasswordPolicy{
+		MinLength:    a.PasswordMinLength,
+		MinClasses:   a.PasswordMinClasses,
+		RejectCommon: a.PasswordRejectCommon,
+	}
+	err = res.Validation()
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// LockoutPolicies returns the lockout policies of the accounts and of the client IPs
+func (a *Config) LockoutPolicies() (user *model.LockoutPolicy, ip *model.LockoutPolicy, err error) {
+	if a.LoginMaxFailures < 1 || a.LoginMaxIpFailures < 1 || a.LoginLockout <= 0 || a.LoginMaxLockout < a.LoginLockout ||
+		a.LoginFailureWindow <= 0 {
+		err = model.ErrInvalidLockoutPolicy
+		return
+	}
+	user = &model.LockoutPolicy{
+		MaxFailures:   a.LoginMaxFailures,
+		BaseLockout:   a.LoginLockout,
+		MaxLockout:    a.LoginMaxLockout,
+		FailureWindow: a.LoginFailureWindow,
+	}
+	ip = &model.LockoutPolicy{}
+	*ip = *user
+	ip.MaxFailures = a.LoginMaxIpFailures
+	return
+}
+
+// Currency returns the configured currency
+func (a *Config) Currency() (*model.Currency, error) {
+	return model.NewCurrency(a.CurrencyCode, a.CoinValues, a.SmallestUnit)
+}
+
+// envReader reads environment variables, a missing or empty one gives the default. The errors of the values
+// which cannot be parsed are collected, so they can be reported together.
+type envReader struct {
+	errs []error
+}
+
+func (a *envReader) string(key string, def string) string {
+	if s, ok := os.LookupEnv(key); ok && s != "" {
+		return s
+	}
+	return def
+}
+
+func (a *envReader) bool(key string, def bool) bool {
+	s := a.string(key, "")
+	if s == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		a.fail(key, s)
+		return def
+	}
+	return b
+}
+
+func (a *envReader) int(key string, def int) int {
+	s := a.string(key, "")
+	if s == "" {
+		return def
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		a.fail(key, s)
+		return def
+	}
+	return i
+}
+
+func (a *envReader) duration(key string, def time.Duration) time.Duration {
+	s := a.string(key, "")
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		a.fail(key, s)
+		return def
+	}
+	return d
+}
+
+// ints reads a comma separated list of integers
+func (a *envReader) ints(key string, def []int) []int {
+	s := a.string(key, "")
+	if s == "" {
+		return def
+	}
+	parts := strings.Split(s, ",")
+	res := make([]int, 0, len(parts))
+	for _, part := range parts {
+		i, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			a.fail(key, s)
+			return def
+		}
+		res = append(res, i)
+	}
+	return res
+}
+
+// fail records the value of the variable as invalid
+func (a *envReader) fail(key string, value string) {
+	a.errs = append(a.errs, fmt.Errorf("%w: %s=%q", model.ErrInvalidEnvValue, key, value))
+}
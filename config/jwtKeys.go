@@ -0,0 +1,185 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/oltur/mvp-match/model"
+	"os"
+	"strings"
+)
+
+const (
+	JwtAlgorithmHS256 = "HS256"
+	JwtAlgorithmRS256 = "RS256"
+	JwtAlgorithmES256 = "ES256"
+)
+
+const (
+	// JwtSecretMinLength is the minimal length of a token signing secret, 256 bits for HS256
+	JwtSecretMinLength = 32
+	// JwtRsaKeyMinBits is the minimal size of an RSA key
+	JwtRsaKeyMinBits = 2048
+)
+
+// JwtKey is a token signing key identified by the kid header of the tokens
+type JwtKey struct {
+	Id        string
+	Algorithm string
+	// Secret is the HS256 secret
+	Secret []byte
+	// PrivateKey signs RS256 and ES256 tokens, it is nil for public keys, which only verify them
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// CanSign tells if the key can sign tokens
+func (a *JwtKey) CanSign() bool {
+	return a.Secret != nil || a.PrivateKey != nil
+}
+
+// SigningKeys returns the configured token keys, the signing one first, none if not configured
+func (a *Config) SigningKeys() (res []*JwtKey, err error) {
+	pemKeys, err := a.pemKeys()
+	if err != nil {
+		return
+	}
+	secretKeys, err := a.secretKeys()
+	if err != nil {
+		return
+	}
+
+	ids := make(map[string]bool, len(pemKeys)+len(secretKeys))
+	signing := -1
+	for i, key := range append(pemKeys, secretKeys...) {
+		if ids[key.Id] {
+			return nil, model.ErrInvalidJwtKeys
+		}
+		ids[key.Id] = true
+		if signing < 0 && key.CanSign() {
+			signing = i
+		}
+		res = append(res, key)
+	}
+	if len(res) == 0 {
+		return
+	}
+	if signing < 0 {
+		return nil, model.ErrNoJwtSigningKey
+	}
+	res[0], res[signing] = res[signing], res[0]
+	return
+}
+
+// secretKeys reads the HS256 secrets of JwtKeysFile or JwtKeys
+func (a *Config) secretKeys() (res []*JwtKey, err error) {
+	var pairs []string
+	if a.JwtKeysFile != "" {
+		var b []byte
+		b, err = os.ReadFile(a.JwtKeysFile)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				pairs = append(pairs, line)
+			}
+		}
+	} else if a.JwtKeys != "" {
+		pairs = strings.Split(a.JwtKeys, ",")
+	}
+
+	res = make([]*JwtKey, 0, len(pairs))
+	for _, pair := range pairs {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, model.ErrInvalidJwtKeys
+		}
+		if len(secret) < JwtSecretMinLength {
+			return nil, model.ErrJwtSecretTooShort
+		}
+		res = append(res, &JwtKey{Id: id, Algorithm: JwtAlgorithmHS256, Secret: []byte(secret)})
+	}
+	return
+}
+
+// pemKeys reads the PEM files of JwtPemKeys
+func (a *Config) pemKeys() (res []*JwtKey, err error) {
+	if a.JwtPemKeys == "" {
+		return
+	}
+	for _, pair := range strings.Split(a.JwtPemKeys, ",") {
+		id, path, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || path == "" {
+			return nil, model.ErrInvalidJwtKeys
+		}
+		var key *JwtKey
+		key, err = readPemKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %s: %w", id, err)
+		}
+		key.Id = id
+		res = append(res, key)
+	}
+	return
+}
+
+// readPemKey reads the first key of a PEM file, a PKCS #1, PKCS #8 or SEC 1 private key or a PKIX public key
+func readPemKey(path string) (res *JwtKey, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		err = model.ErrUnsupportedJwtKey
+		return
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = model.ErrUnsupportedJwtKey
+	}
+	if err != nil {
+		return
+	}
+
+	res = &JwtKey{}
+	if signer, ok := key.(crypto.Signer); ok {
+		res.PrivateKey = signer
+		key = signer.Public()
+	}
+	res.PublicKey = key
+	switch public := key.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < JwtRsaKeyMinBits {
+			err = model.ErrUnsupportedJwtKey
+			return
+		}
+		res.Algorithm = JwtAlgorithmRS256
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			err = model.ErrUnsupportedJwtKey
+			return
+		}
+		res.Algorithm = JwtAlgorithmES256
+	default:
+		err = model.ErrUnsupportedJwtKey
+		return
+	}
+	return
+}
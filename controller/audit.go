@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+)
+
+// ListAudit godoc
+// @Summary      List audit log
+// @Description  List the audit log entries, the oldest first, filtered by actor, entity and time range, needs the audit:read permission. The log cannot be changed through the API.
+// @Tags         Audit
+// @Accept       json
+// @Produce      json
+// @Param        actorId     query     string     false  "Actor user ID"
+// @Param        entityType     query     string     false  "Entity type, e.g. user, product, order, coins, payout"
+// @Param        entityId     query     string     false  "Entity ID"
+// @Param        from     query     string     false  "Start of the time range, inclusive, RFC 3339"
+// @Param        to     query     string     false  "End of the time range, exclusive, RFC 3339"
+// @Success      200  {array}   model.AuditEntry
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /audit [get]
+func (c *Controller) ListAudit(ctx *gin.Context) {
+	q := &model.AuditQuery{
+		ActorId:    types.Id(ctx.Query("actorId")),
+		EntityType: ctx.Query("entityType"),
+		EntityId:   types.Id(ctx.Query("entityId")),
+	}
+	var ok bool
+	q.From, q.To, ok = getTimeRange(ctx)
+	if !ok {
+		return
+	}
+
+	res, err := c.audits.AuditQuery(q)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// --------------- implementation details -------------
+
+// getTimeRange reads the optional from and to query parameters, otherwise it writes an error response and returns false
+func getTimeRange(ctx *gin.Context) (from time.Time, to time.Time, ok bool) {
+	var err error
+	if s := ctx.Query("from"); s != "" {
+		from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidTimeRange)
+			return
+		}
+	}
+	if s := ctx.Query("to"); s != "" {
+		to, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidTimeRange)
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidTimeRange)
+		return
+	}
+	ok = true
+	return
+}
+
+// audit records a successful state change made by the actor, before and after are snapshots of the entity,
+// nil when it did not exist. The change is committed already, so one which cannot be recorded is logged and the
+// request still answered with its result: a failure response would have it retried and made twice.
+func (c *Controller) audit(actor *model.User, action string, entityType string, entityId types.Id, before interface{}, after interface{}) {
+	entry := &model.AuditEntry{
+		ID:         types.Id(xid.New().String()),
+		Action:     action,
+		EntityType: entityType,
+		EntityId:   entityId,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		CreatedAt:  time.Now(),
+	}
+	if actor != nil {
+		entry.ActorId = actor.ID
+		entry.ActorRole = strings.Join(actor.Roles, ",")
+	}
+	err := c.audits.AuditAppend(entry)
+	if err != nil {
+		log.Printf("%v, %s of %s %q: %v", model.ErrAuditNotRecorded, action, entityType, entityId, err)
+	}
+}
+
+// auditSnapshot serializes the entity, the secrets are not serialized
+func auditSnapshot(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	if user, ok := value.(*model.User); ok && user == nil {
+		return nil
+	}
+	res, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return res
+}
@@ -1,24 +1,147 @@
 package controller
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/oltur/mvp-match/config"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 )
 
 // Controller example
 type Controller struct {
+	users    model.UserRepository
+	sessions model.SessionRepository
+	lockouts model.LockoutRepository
+	totps    model.TotpRepository
+	roles    model.RoleRepository
+	products model.ProductRepository
+	coins    model.CoinRepository
+	vending  model.VendingRepository
+	orders   model.OrderRepository
+	ledger   model.LedgerRepository
+	audits   model.AuditRepository
+	events   model.EventRepository
+
+	idempotency          model.IdempotencyRepository
+	idempotencyRetention time.Duration
+
+	currency            *model.Currency
+	changeStrategy      model.ChangeStrategy
+	returnInsertedCoins bool
+	passwordHasher      model.PasswordHasher
+	passwordPolicy      *model.PasswordPolicy
+
+	// jwtKeys sign and verify the tokens, the first one signs new tokens
+	jwtKeys []*config.JwtKey
+	// jwtIssuer and jwtAudience are put into the tokens and required from them
+	jwtIssuer   string
+	jwtAudience string
+	// accessTokenTtl is the lifetime of the access tokens, sessionTtl the one of the sessions and their refresh tokens
+	accessTokenTtl time.Duration
+	sessionTtl     time.Duration
+	// userLockoutPolicy and ipLockoutPolicy lock out accounts and client IPs after failed logins
+	userLockoutPolicy *model.LockoutPolicy
+	ipLockoutPolicy   *model.LockoutPolicy
 }
 
 // NewController example
-func NewController() *Controller {
-	return &Controller{}
+func NewController(store model.Store, cfg *config.Config) (res *Controller, err error) {
+	currency, err := cfg.Currency()
+	if err != nil {
+		return
+	}
+	changeStrategy, err := model.ChangeStrategyByName(cfg.ChangeStrategy)
+	if err != nil {
+		return
+	}
+	returnInsertedCoins, err := model.ReturnsInsertedCoins(cfg.RefundPolicy)
+	if err != nil {
+		return
+	}
+	passwordHasher, err := model.PasswordHasherByName(cfg.PasswordHash, cfg.PasswordHashCost)
+	if err != nil {
+		return
+	}
+	passwordPolicy, err := cfg.PasswordPolicy()
+	if err != nil {
+		return
+	}
+	userLockoutPolicy, ipLockoutPolicy, err := cfg.LockoutPolicies()
+	if err != nil {
+		return
+	}
+	jwtKeys, err := cfg.SigningKeys()
+	if err != nil {
+		return
+	}
+	if len(jwtKeys) == 0 {
+		jwtKeys, err = randomJwtKeys()
+		if err != nil {
+			return
+		}
+	}
+	eventStore, err := cfg.EventStore()
+	if err != nil {
+		return
+	}
+	// the memory storage starts over on every run, so it gets the state replayed from the event log
+	sourced, err := model.NewEventSourcedStore(store, eventStore, cfg.Storage == config.StorageMemory)
+	if err != nil {
+		return
+	}
+	res = &Controller{
+		users:    sourced,
+		sessions: sourced,
+		lockouts: sourced,
+		totps:    sourced,
+		roles:    sourced,
+		products: sourced,
+		coins:    sourced,
+		vending:  sourced,
+		orders:   sourced,
+		ledger:   sourced,
+		audits:   sourced,
+		events:   sourced,
+
+		idempotency:          sourced,
+		idempotencyRetention: cfg.IdempotencyRetention,
+
+		currency:            currency,
+		changeStrategy:      changeStrategy,
+		returnInsertedCoins: returnInsertedCoins,
+		passwordHasher:      passwordHasher,
+		passwordPolicy:      passwordPolicy,
+
+		jwtKeys:     jwtKeys,
+		jwtIssuer:   cfg.JwtIssuer,
+		jwtAudience: cfg.JwtAudience,
+
+		accessTokenTtl: cfg.AccessTokenTtl,
+		sessionTtl:     cfg.SessionTtl,
+
+		userLockoutPolicy: userLockoutPolicy,
+		ipLockoutPolicy:   ipLockoutPolicy,
+	}
+	return
+}
+
+// Currency returns the currency accepted by the machine
+func (c *Controller) Currency() *model.Currency {
+	return c.currency
+}
+
+// PasswordHasher returns the hasher of the new passwords
+func (c *Controller) PasswordHasher() model.PasswordHasher {
+	return c.passwordHasher
 }
 
 // Message example
@@ -27,6 +150,51 @@ type Message struct {
 }
 
 func (c *Controller) Auth() gin.HandlerFunc {
+	return c.auth(true)
+}
+
+// AuthWithoutTotp is Auth letting in the users who still have to enroll the second factor required for their role,
+// for the enrollment itself
+func (c *Controller) AuthWithoutTotp() gin.HandlerFunc {
+	return c.auth(false)
+}
+
+// permissionStatuses are the statuses of a missing permission other than 403, the ones the routes answered a user
+// of the wrong role with before the permissions
+var permissionStatuses = map[string]int{
+	model.PermissionMachineDeposit:  http.StatusBadRequest,
+	model.PermissionMachineBuy:      http.StatusBadRequest,
+	model.PermissionEarningsReadOwn: http.StatusBadRequest,
+}
+
+// Permit lets in the users having any of the permissions, it follows Auth
+func (c *Controller) Permit(permissions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		granted, err := c.getPermissions(ctx)
+		if errors.Is(err, model.ErrUserNotFoundInContext) {
+			httputil.NewError(ctx, http.StatusForbidden, err)
+			ctx.Abort()
+			return
+		}
+		if err != nil {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+			ctx.Abort()
+			return
+		}
+		if !granted.HasAny(permissions...) {
+			status, ok := permissionStatuses[permissions[0]]
+			if !ok {
+				status = http.StatusForbidden
+			}
+			httputil.NewError(ctx, status, model.PermissionError(permissions[0]))
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (c *Controller) auth(requireTotp bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		gwtToken := ctx.GetHeader("Authorization")
 		if len(gwtToken) == 0 {
@@ -37,87 +205,161 @@ func (c *Controller) Auth() gin.HandlerFunc {
 
 		gwtToken = strings.Replace(gwtToken, "Bearer ", "", -1)
 
-		userId, token, expires, err := c.validateGwt(gwtToken)
+		userId, sessionId, err := c.validateGwt(gwtToken)
 		if err != nil {
-			err = model.ErrCannotValidateUserToken
-			httputil.NewError(ctx, http.StatusInternalServerError, err)
+			httputil.NewError(ctx, http.StatusUnauthorized, err)
 			ctx.Abort()
 			return
 		}
 
-		ok, err := model.VerifyToken(userId, token, expires)
-		if err != nil {
+		session, err := c.sessions.SessionTouch(types.Id(sessionId), time.Now())
+		if err != nil && !errors.Is(err, model.ErrNotFound) {
 			err = model.ErrCannotValidateUserToken
 			httputil.NewError(ctx, http.StatusInternalServerError, err)
 			ctx.Abort()
 			return
 		}
 
-		if !ok {
-			err = model.ErrAccessDenied
-			httputil.NewError(ctx, http.StatusForbidden, err)
+		// the session was revoked or logged out, or the user was deleted
+		if session == nil || session.UserId != types.Id(userId) {
+			err = model.ErrSessionEnded
+			httputil.NewError(ctx, http.StatusUnauthorized, err)
 			ctx.Abort()
 			return
 		}
 
+		if requireTotp {
+			err = c.checkTotpEnrollment(types.Id(userId))
+			if errors.Is(err, model.ErrTotpEnrollmentRequired) {
+				httputil.NewError(ctx, http.StatusForbidden, err)
+				ctx.Abort()
+				return
+			}
+			if err != nil {
+				httputil.NewError(ctx, http.StatusInternalServerError, err)
+				ctx.Abort()
+				return
+			}
+		}
+
 		ctx.Set("userId", userId)
+		ctx.Set("sessionId", sessionId)
 		ctx.Next()
 	}
 }
 
-func (c *Controller) getKey() (key string, err error) {
-	// TODO: Read from env
-	key = "xxxxxxxxxxx"
-	return key, nil
-}
-
-func (c *Controller) createGwt(userId string, token string, expires int64) (res string, err error) {
-	key, err := c.getKey()
-	binKey := []byte(key)
-	// Create a new token object, specifying signing method and the claims
-	// you would like it to contain.
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId":  userId,
-		"token":   token,
-		"expires": strconv.FormatInt(expires, 10),
+// createGwt signs a token of the session of the user, expires should be whole seconds
+func (c *Controller) createGwt(userId string, sessionId string, expires time.Time) (res string, err error) {
+	return c.signClaims(jwt.StandardClaims{
+		Subject:   userId,
+		Id:        sessionId,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expires.Unix(),
+		Issuer:    c.jwtIssuer,
+		Audience:  c.jwtAudience,
 	})
+}
 
-	// Sign and get the complete encoded token as a string using the secret
-	res, err = jwtToken.SignedString(binKey)
+// validateGwt checks the token and returns its user and session
+func (c *Controller) validateGwt(gwtToken string) (userId string, sessionId string, err error) {
+	claims := &jwt.StandardClaims{}
+	err = c.parseClaims(gwtToken, claims)
 	if err != nil {
-		return "", err
+		return
 	}
 
-	return res, nil
+	if claims.Subject == "" || claims.Id == "" || claims.ExpiresAt == 0 ||
+		!claims.VerifyIssuer(c.jwtIssuer, true) || !claims.VerifyAudience(c.jwtAudience, true) {
+		err = model.ErrTokenClaimsInvalid
+		return
+	}
+	return claims.Subject, claims.Id, nil
 }
 
-func (c *Controller) validateGwt(gwtToken string) (userId string, token string, expires int64, err error) {
-	// sample token string taken from the New example
-	key, err := c.getKey()
-	binKey := []byte(key)
+// signClaims signs the claims with the signing key
+func (c *Controller) signClaims(claims jwt.Claims) (res string, err error) {
+	key := c.jwtKeys[0]
+	jwtToken := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	// the key id lets the key be found while the keys are rotated
+	jwtToken.Header["kid"] = key.Id
+
+	// Sign and get the complete encoded token as a string using the secret or the private key
+	return jwtToken.SignedString(signingKey(key))
+}
 
-	t, err := jwt.Parse(gwtToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// parseClaims checks the signature and the times of the token and reads its claims
+func (c *Controller) parseClaims(gwtToken string, claims jwt.Claims) (err error) {
+	_, err = jwt.ParseWithClaims(gwtToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := c.jwtKey(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key id: %q", kid)
+		}
+		// the algorithm is the one of the key, never the one claimed by the token
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-
-		return binKey, nil
+		return verifyingKey(key), nil
 	})
+	if err != nil {
+		err = tokenError(err)
+	}
+	return
+}
 
-	if claims, ok := t.Claims.(jwt.MapClaims); ok && t.Valid {
-		i, err := strconv.ParseInt(claims["expires"].(string), 10, 64)
-		if err != nil {
-			return "", "", 0, nil
+// tokenError converts an error of the token parser to the matching model error
+func tokenError(err error) error {
+	var validationErr *jwt.ValidationError
+	if !errors.As(err, &validationErr) {
+		return model.ErrTokenMalformed
+	}
+	switch {
+	case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+		return model.ErrTokenMalformed
+	case validationErr.Errors&(jwt.ValidationErrorUnverifiable|jwt.ValidationErrorSignatureInvalid) != 0:
+		return model.ErrTokenSignatureInvalid
+	case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+		return model.ErrTokenExpired
+	default:
+		return model.ErrTokenClaimsInvalid
+	}
+}
+
+// jwtKey returns the key of the given id, nil if there is none
+func (c *Controller) jwtKey(kid string) *config.JwtKey {
+	for _, key := range c.jwtKeys {
+		if key.Id == kid {
+			return key
 		}
+	}
+	return nil
+}
+
+// signingKey is the secret or the private key of the key
+func signingKey(key *config.JwtKey) interface{} {
+	if key.Secret != nil {
+		return key.Secret
+	}
+	return key.PrivateKey
+}
 
-		userId = claims["userId"].(string)
-		token = claims["token"].(string)
-		expires = i
+// verifyingKey is the secret or the public key of the key
+func verifyingKey(key *config.JwtKey) interface{} {
+	if key.Secret != nil {
+		return key.Secret
+	}
+	return key.PublicKey
+}
 
-		return userId, token, expires, nil
-	} else {
-		return "", "", 0, nil
+// randomJwtKeys generates a single random key, for when none are configured
+func randomJwtKeys() (res []*config.JwtKey, err error) {
+	secret := make([]byte, config.JwtSecretMinLength)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return
 	}
+	res = []*config.JwtKey{{Id: xid.New().String(), Algorithm: config.JwtAlgorithmHS256, Secret: secret}}
+	return
 }
 
 // Ping godoc
@@ -132,6 +374,11 @@ func (c *Controller) Ping(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, "Pong")
 }
 
+// getSessionIdFromContext returns the session of the token of the request, set by Auth
+func (c *Controller) getSessionIdFromContext(ctx *gin.Context) (res types.Id) {
+	return types.Id(ctx.GetString("sessionId"))
+}
+
 func (c *Controller) getUserIdFromContext(ctx *gin.Context) (res types.Id, err error) {
 	x, exists := ctx.Get("userId")
 	if !exists {
@@ -146,3 +393,47 @@ func (c *Controller) getUserIdFromContext(ctx *gin.Context) (res types.Id, err e
 	res = types.Id(s)
 	return
 }
+
+// getCurrentUser returns the current user, otherwise it writes an error response and returns false
+func (c *Controller) getCurrentUser(ctx *gin.Context) (res *model.User, ok bool) {
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	res, err = c.users.UserOne(userId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ok = true
+	return
+}
+
+// getPermissions returns the permissions of the roles of the current user, loaded once per request
+func (c *Controller) getPermissions(ctx *gin.Context) (res model.PermissionSet, err error) {
+	if x, exists := ctx.Get("permissions"); exists {
+		return x.(model.PermissionSet), nil
+	}
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		return
+	}
+	user, err := c.users.UserOne(userId)
+	if err != nil {
+		return
+	}
+	roles, err := c.roles.RolesAll()
+	if err != nil {
+		return
+	}
+	res = model.UserPermissions(user, roles)
+	ctx.Set("permissions", res)
+	return
+}
+
+// can tells if the current user has any of the permissions
+func (c *Controller) can(ctx *gin.Context, permissions ...string) bool {
+	granted, err := c.getPermissions(ctx)
+	return err == nil && granted.HasAny(permissions...)
+}
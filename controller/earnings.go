@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+)
+
+// ShowEarnings godoc
+// @Summary      Show earnings
+// @Description  Show the balance and the ledger entries of current Seller user, needs the earnings:read:own permission. With earnings:read:any the earnings of any seller can be seen.
+// @Tags         Earnings
+// @Accept       json
+// @Produce      json
+// @Param        sellerId     query     string     false  "Seller ID, with earnings:read:any"
+// @Success      200  {object}  model.EarningsResponse
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /earnings [get]
+func (c *Controller) ShowEarnings(ctx *gin.Context) {
+	sellerId, ok := c.getSellerIdForRead(ctx)
+	if !ok {
+		return
+	}
+	if sellerId == "" {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidSeller)
+		return
+	}
+
+	entries, err := c.ledger.LedgerEntries(model.SellerAccount(sellerId))
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	balance, err := c.ledger.LedgerBalance(model.SellerAccount(sellerId))
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := &model.EarningsResponse{SellerId: sellerId, Balance: balance, Entries: entries, Currency: c.currency.Code}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case model.LedgerKindSale:
+			res.TotalSales = res.TotalSales + entry.Amount
+		case model.LedgerKindPayout:
+			res.TotalPayouts = res.TotalPayouts + entry.Amount
+		case model.LedgerKindRefund:
+			res.TotalRefunds = res.TotalRefunds + entry.Amount
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ListPayouts godoc
+// @Summary      List payouts
+// @Description  List the payouts of current Seller user, the newest first, needs the earnings:read:own permission. With earnings:read:any the payouts of any seller, or of all sellers, can be listed.
+// @Tags         Earnings
+// @Accept       json
+// @Produce      json
+// @Param        sellerId     query     string     false  "Seller ID, with earnings:read:any"
+// @Success      200  {array}   model.Payout
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /payouts [get]
+func (c *Controller) ListPayouts(ctx *gin.Context) {
+	sellerId, ok := c.getSellerIdForRead(ctx)
+	if !ok {
+		return
+	}
+
+	res, err := c.ledger.PayoutsAll(sellerId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// Payout godoc
+// @Summary      Pay out sellers
+// @Description  Settle the balance of given seller, or of all sellers with a positive balance, and record the payouts, needs the payout:write permission
+// @Tags         Earnings
+// @Accept       json
+// @Produce      json
+// @Param        payout  body      model.PayoutRequest  true  "Seller to settle"
+// @Success      200  {array}   model.Payout
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /payouts [post]
+func (c *Controller) Payout(ctx *gin.Context) {
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var req model.PayoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := c.ledger.Payout(req.SellerId, admin.ID)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	for _, payout := range res {
+		c.audit(admin, model.AuditActionPayout, model.AuditEntityPayout, payout.ID, nil, payout)
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// --------------- implementation details -------------
+
+// getSellerIdForRead returns the seller whose earnings are read: the current seller, or the one asked for by a user
+// allowed to read all earnings. It writes an error response and returns false if the current user is not allowed to.
+// For the latter without a sellerId query the empty ID is returned.
+func (c *Controller) getSellerIdForRead(ctx *gin.Context) (res types.Id, ok bool) {
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	res = types.Id(ctx.Query("sellerId"))
+	// can be viewed by themselves or by those allowed to view all
+	if !c.can(ctx, model.PermissionEarningsReadAny) {
+		if res == "" {
+			res = userId
+		}
+		if res != userId {
+			err = model.ErrAccessDenied
+			httputil.NewError(ctx, http.StatusForbidden, err)
+			return
+		}
+	}
+	ok = true
+	return
+}
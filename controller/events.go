@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+)
+
+// ListEvents godoc
+// @Summary      List events
+// @Description  List the events changing the deposits, the stock and the coins, the oldest first, needs the machine:manage permission. The log starts with a snapshot of the machine.
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Param        from     query     string     false  "Start of the time range, inclusive, RFC 3339"
+// @Param        to     query     string     false  "End of the time range, exclusive, RFC 3339"
+// @Success      200  {array}   model.Event
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/events [get]
+func (c *Controller) ListEvents(ctx *gin.Context) {
+	from, to, ok := getTimeRange(ctx)
+	if !ok {
+		return
+	}
+
+	res, err := c.events.EventsAll(from, to)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ShowMachineState godoc
+// @Summary      Show machine state
+// @Description  Show the deposits, the stock and the coins replayed from the events up to the given time, needs the machine:manage permission
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Param        at     query     string     false  "Point in time, RFC 3339, now if omitted"
+// @Success      200  {object}  model.MachineState
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/state [get]
+func (c *Controller) ShowMachineState(ctx *gin.Context) {
+	var at time.Time
+	if s := ctx.Query("at"); s != "" {
+		var err error
+		at, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidTimeRange)
+			return
+		}
+	}
+
+	res, err := c.events.MachineStateAt(at)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// Reconcile godoc
+// @Summary      Reconcile
+// @Description  Compare the deposits, the stock and the coins replayed from the events with the stored ones and list the differences, needs the machine:manage permission
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   model.Discrepancy
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/reconcile [get]
+func (c *Controller) Reconcile(ctx *gin.Context) {
+	res, err := c.events.Reconcile()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
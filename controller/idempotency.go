@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyResponseFormat = "application/json; charset=utf-8"
+)
+
+// Idempotent makes the money-moving endpoints safe to retry. A request with an Idempotency-Key header is executed
+// once per user and key: repeating it within the retention window returns the stored response without executing it
+// again, and reusing the key for a different request is rejected. It must run after Auth.
+func (c *Controller) Idempotent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > model.IdempotencyKeyMaxLength {
+			httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidIdempotencyKey)
+			ctx.Abort()
+			return
+		}
+
+		userId, err := c.getUserIdFromContext(ctx)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusForbidden, err)
+			ctx.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := &model.IdempotencyRecord{
+			UserId:      userId,
+			Key:         key,
+			Fingerprint: requestFingerprint(ctx.Request, body),
+			CreatedAt:   now,
+		}
+		existing, err := c.idempotency.IdempotencyBegin(record, now.Add(-c.idempotencyRetention))
+		if err != nil {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+			ctx.Abort()
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				httputil.NewError(ctx, http.StatusUnprocessableEntity, model.ErrIdempotencyKeyReused)
+			case existing.InProgress():
+				httputil.NewError(ctx, http.StatusConflict, model.ErrIdempotencyInProgress)
+			default:
+				ctx.Header(IdempotentReplayedHeader, "true")
+				ctx.Data(existing.Status, idempotencyResponseFormat, existing.Body)
+			}
+			ctx.Abort()
+			return
+		}
+
+		// a key neither completed nor released, e.g. on a panic of the handler, would be in progress for the whole
+		// retention, so it is released
+		var done bool
+		defer func() {
+			if done {
+				return
+			}
+			err := c.idempotency.IdempotencyRelease(userId, key)
+			if err != nil {
+				log.Printf("cannot release idempotency key %q of user %q: %v", key, userId, err)
+			}
+		}()
+
+		w := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = w
+		ctx.Next()
+
+		// server errors are not stored, so the request can be retried
+		if w.Status() >= http.StatusInternalServerError {
+			return
+		}
+		err = c.idempotency.IdempotencyComplete(userId, key, w.Status(), w.body.Bytes())
+		if err != nil {
+			log.Printf("cannot store the response of idempotency key %q of user %q: %v", key, userId, err)
+			return
+		}
+		done = true
+	}
+}
+
+// --------------- implementation details -------------
+
+// requestFingerprint identifies a request by its method, path, query and body
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "?" + req.URL.RawQuery + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
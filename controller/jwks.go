@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/model"
+)
+
+// ShowJwks lists the public keys verifying the RS256 and ES256 tokens, by kid, at /.well-known/jwks.json
+// outside the API base path. Secrets of HS256 tokens are never published.
+func (c *Controller) ShowJwks(ctx *gin.Context) {
+	res := &model.JwksResponse{Keys: []*model.Jwk{}}
+	for _, key := range c.jwtKeys {
+		if jwk := publicJwk(key); jwk != nil {
+			res.Keys = append(res.Keys, jwk)
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// --------------- implementation details -------------
+
+// publicJwk converts the public key of an RS256 or ES256 key, nil for others
+func publicJwk(key *config.JwtKey) (res *model.Jwk) {
+	switch public := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		res = &model.Jwk{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		res = &model.Jwk{
+			Kty: "EC",
+			Crv: public.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size))),
+		}
+	default:
+		return nil
+	}
+	res.Use = "sig"
+	res.Alg = key.Algorithm
+	res.Kid = key.Id
+	return
+}
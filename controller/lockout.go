@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+)
+
+// ListLockouts godoc
+// @Summary      List login lockouts
+// @Description  List the recent failed logins by account and by client IP, the latest first, with the lockouts they caused, needs the lockout:manage permission
+// @Tags         Lockouts
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   model.LoginLockout
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /lockouts [get]
+func (c *Controller) ListLockouts(ctx *gin.Context) {
+	res, err := c.lockouts.LockoutsAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ClearLockout godoc
+// @Summary      Clear a login lockout
+// @Description  Forget the failed logins of an account or a client IP, which lifts its lockout, needs the lockout:manage permission
+// @Tags         Lockouts
+// @Accept       json
+// @Produce      json
+// @Param        kind     query     string     true  "user or ip"
+// @Param        subject     query     string     true  "User name or client IP"
+// @Success      204  {string}  string "Ok"
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /lockouts [delete]
+func (c *Controller) ClearLockout(ctx *gin.Context) {
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	kind, subject := ctx.Query("kind"), ctx.Query("subject")
+	if kind != model.LockoutKindUser && kind != model.LockoutKindIp {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidLockoutKind)
+		return
+	}
+	before, err := c.lockouts.LockoutOne(kind, subject)
+	if err == nil {
+		err = c.lockouts.LockoutClear(kind, subject)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(admin, model.AuditActionLockoutClear, model.AuditEntityLockout, types.Id(kind+":"+subject), before, nil)
+
+	ctx.JSON(http.StatusNoContent, "Ok")
+}
+
+// --------------- implementation details -------------
+
+// loginAttempt is a login counted as failed for the account and the client IP until it succeeds
+type loginAttempt struct {
+	userName string
+	ip       string
+	at       time.Time
+}
+
+// beginLogin counts the login as failed for the account and the client IP before the password or code is verified,
+// so parallel requests cannot all pass the lockout before the first failure is recorded. It writes an error response,
+// with a Retry-After header if the account or the IP is locked out, and returns nil if the login cannot go on.
+func (c *Controller) beginLogin(ctx *gin.Context, userName string) (res *loginAttempt) {
+	attempt := &loginAttempt{userName: userName, ip: ctx.ClientIP(), at: time.Now()}
+	lockout, err := c.lockouts.LockoutAttempt(model.LockoutKindUser, userName, attempt.at, c.userLockoutPolicy)
+	if err == nil {
+		lockout, err = c.lockouts.LockoutAttempt(model.LockoutKindIp, attempt.ip, attempt.at, c.ipLockoutPolicy)
+		if err != nil {
+			c.takeBackLockout(model.LockoutKindUser, userName, attempt.at)
+		}
+	}
+	if errors.Is(err, model.ErrLoginLocked) {
+		retryAfter := int(math.Ceil(lockout.LockedUntil.Sub(attempt.at).Seconds()))
+		ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+		httputil.NewError(ctx, http.StatusTooManyRequests, err)
+		return
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	res = attempt
+	return
+}
+
+// loginSucceeded forgets the failed logins of the account and takes back the attempt of the client IP. The earlier
+// failures of the IP are kept, an attacker could reset them with an account of their own otherwise.
+func (c *Controller) loginSucceeded(attempt *loginAttempt) {
+	err := c.lockouts.LockoutClear(model.LockoutKindUser, attempt.userName)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		log.Printf("cannot clear failed logins of user %q: %v", attempt.userName, err)
+	}
+	c.takeBackLockout(model.LockoutKindIp, attempt.ip, attempt.at)
+}
+
+// loginNotFailed takes back the attempt of a login which did not fail, e.g. the password was right but the second
+// factor is still to be given, or it could not be checked. The earlier failures are kept.
+func (c *Controller) loginNotFailed(attempt *loginAttempt) {
+	c.takeBackLockout(model.LockoutKindUser, attempt.userName, attempt.at)
+	c.takeBackLockout(model.LockoutKindIp, attempt.ip, attempt.at)
+}
+
+// takeBackLockout takes back a counted attempt. The response does not depend on it, so a failure is only logged.
+func (c *Controller) takeBackLockout(kind string, subject string, at time.Time) {
+	err := c.lockouts.LockoutTakeBack(kind, subject, at)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		log.Printf("cannot take back the login attempt of %s %q: %v", kind, subject, err)
+	}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"strconv"
+)
+
+// ListCoins godoc
+// @Summary      List coins
+// @Description  List the coin tubes of the machine, needs the machine:manage permission
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   model.CoinTube
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/coins [get]
+func (c *Controller) ListCoins(ctx *gin.Context) {
+	res, err := c.coins.CoinsAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// LoadCoins godoc
+// @Summary      Load coins
+// @Description  Add coins to the tubes of the machine, needs the machine:manage permission
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Param        coins  body      model.LoadCoinsRequest  true  "Coins to load"
+// @Success      200    {array}   model.CoinTube
+// @Failure      400    {object}  httputil.HTTPError
+// @Failure      403    {object}  httputil.HTTPError
+// @Failure      500    {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/coins [post]
+func (c *Controller) LoadCoins(ctx *gin.Context) {
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var req model.LoadCoinsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Validation(c.currency); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	before, err := c.coins.CoinsAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := c.coins.CoinsLoad(req.Coins)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(admin, model.AuditActionCoinsLoad, model.AuditEntityCoins, "", before, res)
+	ctx.JSON(http.StatusOK, res)
+}
+
+// EmptyCoins godoc
+// @Summary      Empty coins
+// @Description  Empty the tube of given coin value, or all tubes, needs the machine:manage permission
+// @Tags         Machine
+// @Accept       json
+// @Produce      json
+// @Param        coinValue     query     int     false  "Coin value, all tubes if omitted"
+// @Success      200  {array}   model.CoinTube
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /machine/coins [delete]
+func (c *Controller) EmptyCoins(ctx *gin.Context) {
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	value := 0
+	if s := ctx.Query("coinValue"); s != "" {
+		var err error
+		value, err = strconv.Atoi(s)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		err = model.Coin{Value: value}.Validation(c.currency)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	before, err := c.coins.CoinsAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := c.coins.CoinsEmpty(value)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(admin, model.AuditActionCoinsEmpty, model.AuditEntityCoins, "", before, res)
+	ctx.JSON(http.StatusOK, res)
+}
@@ -24,7 +24,7 @@ import (
 func (c *Controller) ShowProduct(ctx *gin.Context) {
 	s := ctx.Param("id")
 	id := types.Id(s)
-	product, err := model.ProductOne(id)
+	product, err := c.products.ProductOne(id)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
@@ -46,7 +46,7 @@ func (c *Controller) ShowProduct(ctx *gin.Context) {
 // @Router       /product [get]
 func (c *Controller) ListProducts(ctx *gin.Context) {
 	q := ctx.Query("q")
-	products, err := model.ProductsAll(q)
+	products, err := c.products.ProductsAll(q)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
@@ -74,24 +74,18 @@ func (c *Controller) AddProduct(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Seller role
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	if user.Role != model.UserRoleSeller {
-		err = model.ErrInvalidSeller
-		httputil.NewError(ctx, http.StatusForbidden, err)
-		return
-	}
 
 	var req model.AddProductReq
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-	if err := req.Validation(); err != nil {
+	if err := req.Validation(c.currency); err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
@@ -102,11 +96,12 @@ func (c *Controller) AddProduct(ctx *gin.Context) {
 		AmountAvailable: req.AmountAvailable,
 		Cost:            req.Cost,
 	}
-	res, err := model.ProductInsert(product)
+	res, err := c.products.ProductInsert(product)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	c.audit(user, model.AuditActionProductAdd, model.AuditEntityProduct, res.ID, nil, res)
 	ctx.JSON(http.StatusOK, res)
 }
 
@@ -131,30 +126,44 @@ func (c *Controller) UpdateProduct(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Seller role
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	if user.Role != model.UserRoleSeller {
-		err = model.ErrInvalidSeller
-		httputil.NewError(ctx, http.StatusForbidden, err)
-		return
-	}
 
 	var updateProductReq model.UpdateProductRequest
 	if err := ctx.ShouldBindJSON(&updateProductReq); err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	if err := updateProductReq.Validation(c.currency); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
 
 	// update
-	err = model.ProductUpdate(&updateProductReq)
+	before, err := c.products.ProductOne(updateProductReq.ID)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	if before.SellerId != userId && !c.can(ctx, model.PermissionProductWriteAny) {
+		err = model.ErrWrongSeller
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	err = c.products.ProductUpdate(&updateProductReq)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	after, err := c.products.ProductOne(updateProductReq.ID)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(user, model.AuditActionProductUpdate, model.AuditEntityProduct, updateProductReq.ID, before, after)
 	ctx.JSON(http.StatusOK, updateProductReq)
 }
 
@@ -181,36 +190,31 @@ func (c *Controller) DeleteProduct(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Seller role
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	if user.Role != model.UserRoleSeller {
-		err = model.ErrInvalidSeller
-		httputil.NewError(ctx, http.StatusForbidden, err)
-		return
-	}
 
 	// check product ownership
-	product, err := model.ProductOne(id)
+	product, err := c.products.ProductOne(id)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
-	if product.SellerId != userId {
+	if product.SellerId != userId && !c.can(ctx, model.PermissionProductWriteAny) {
 		err = model.ErrWrongSeller
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
 
 	// delete
-	err = model.ProductDelete(id)
+	err = c.products.ProductDelete(id)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	c.audit(user, model.AuditActionProductDelete, model.AuditEntityProduct, id, product, nil)
 	ctx.JSON(http.StatusNoContent, "Ok")
 }
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+)
+
+// ListPurchases godoc
+// @Summary      List purchases
+// @Description  List the orders of current user, the newest first. With the purchase:read:any permission the orders of any buyer can be listed.
+// @Tags         Purchases
+// @Accept       json
+// @Produce      json
+// @Param        buyerId     query     string     false  "Buyer ID, with purchase:read:any"
+// @Success      200  {array}   model.Order
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /purchases [get]
+func (c *Controller) ListPurchases(ctx *gin.Context) {
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	buyerId := types.Id(ctx.Query("buyerId"))
+	if buyerId == "" {
+		buyerId = userId
+	}
+	// can be viewed by themselves or by those allowed to view all
+	if buyerId != userId && !c.can(ctx, model.PermissionPurchaseReadAny) {
+		err = model.ErrAccessDenied
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	res, err := c.orders.OrdersByBuyer(buyerId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ShowReceipt godoc
+// @Summary      Show receipt
+// @Description  Render the receipt of an order as plain text or JSON, for its buyer or with the purchase:read:any permission
+// @Tags         Purchases
+// @Accept       json
+// @Produce      plain
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Param        format     query     string     false  "Receipt format, text (default) or json"
+// @Success      200  {object}  model.Receipt
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /purchases/{id}/receipt [get]
+func (c *Controller) ShowReceipt(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", model.ReceiptFormatText)
+	if format != model.ReceiptFormatText && format != model.ReceiptFormatJson {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrInvalidReceiptFormat)
+		return
+	}
+
+	order, ok := c.getOrderForRead(ctx)
+	if !ok {
+		return
+	}
+
+	res := model.NewReceipt(order, c.currency.Code)
+	if format == model.ReceiptFormatJson {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	ctx.String(http.StatusOK, res.Text())
+}
+
+// RefundPurchase godoc
+// @Summary      Refund purchase
+// @Description  Reverse a whole order, e.g. when the machine failed to dispense: the stock is restored, the total is returned to the buyer as deposit or as coins and the sellers are debited, needs the purchase:refund permission
+// @Tags         Purchases
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Param        refund  body      model.RefundOrderRequest  true  "Refund method and reason"
+// @Success      200  {object}  model.OrderRefund
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /purchases/{id}/refund [post]
+func (c *Controller) RefundPurchase(ctx *gin.Context) {
+	id := types.Id(ctx.Param("id"))
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var req model.RefundOrderRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	before, err := c.orders.OrderOne(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	res, err := c.vending.RefundOrder(id, req.Method, req.Reason, admin.ID, c.makeChange)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		case errors.Is(err, model.ErrOrderAlreadyRefunded),
+			errors.Is(err, model.ErrCannotMakeChange):
+			httputil.NewError(ctx, http.StatusConflict, err)
+		case errors.Is(err, model.ErrInvalidRefundMethod):
+			httputil.NewError(ctx, http.StatusBadRequest, err)
+		default:
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	after, err := c.orders.OrderOne(id)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(admin, model.AuditActionOrderRefund, model.AuditEntityOrder, id, before, after)
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ShowPurchaseRefund godoc
+// @Summary      Show purchase refund
+// @Description  Show the refund of an order, for its buyer or with the purchase:read:any permission
+// @Tags         Purchases
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Success      200  {object}  model.OrderRefund
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /purchases/{id}/refund [get]
+func (c *Controller) ShowPurchaseRefund(ctx *gin.Context) {
+	order, ok := c.getOrderForRead(ctx)
+	if !ok {
+		return
+	}
+	if order.RefundId == "" {
+		httputil.NewError(ctx, http.StatusNotFound, model.ErrNotFound)
+		return
+	}
+
+	res, err := c.orders.OrderRefundOne(order.RefundId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// --------------- implementation details -------------
+
+// getOrderForRead loads the order of the id path parameter if the current user is its buyer or may read all orders,
+// otherwise it writes an error response and returns false
+func (c *Controller) getOrderForRead(ctx *gin.Context) (res *model.Order, ok bool) {
+	id := types.Id(ctx.Param("id"))
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	res, err = c.orders.OrderOne(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	// can be viewed by the buyer or by those allowed to view all
+	if res.BuyerId != userId && !c.can(ctx, model.PermissionPurchaseReadAny) {
+		err = model.ErrAccessDenied
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+)
+
+// ListRoles godoc
+// @Summary      List roles
+// @Description  List the roles with their permissions, sorted by name, needs the role:manage permission
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   model.Role
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /roles [get]
+func (c *Controller) ListRoles(ctx *gin.Context) {
+	res, err := c.roles.RolesAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// ListPermissions godoc
+// @Summary      List permissions
+// @Description  List the permissions roles can grant, needs the role:manage permission
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   string
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /roles/permissions [get]
+func (c *Controller) ListPermissions(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, model.Permissions)
+}
+
+// SaveRole godoc
+// @Summary      Save a role
+// @Description  Add a role or replace the permissions of one, which applies to the next requests of its users. Needs the role:manage permission, which cannot be taken from the last users having it.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string             true  "Role name"
+// @Param        role     body      model.RoleRequest  true  "Permissions"
+// @Success      200  {object}  model.Role
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /roles/{name} [put]
+func (c *Controller) SaveRole(ctx *gin.Context) {
+	var req model.RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	role := &model.Role{Name: ctx.Param("name"), Permissions: req.Permissions, Registrable: req.Registrable}
+	if err := role.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.roles.RoleOne(role.Name)
+	if errors.Is(err, model.ErrNotFound) {
+		before, err = nil, nil
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	err = c.roles.RoleSave(role)
+	if err == nil {
+		role, err = c.roles.RoleOne(role.Name)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrNoRoleManager) {
+			httputil.NewError(ctx, http.StatusConflict, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(admin, model.AuditActionRoleSave, model.AuditEntityRole, types.Id(role.Name), before, role)
+
+	ctx.JSON(http.StatusOK, role)
+}
+
+// DeleteRole godoc
+// @Summary      Delete a role
+// @Description  Delete a role no user holds, it is also no longer one requiring a second factor. Needs the role:manage permission.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string     true  "Role name"
+// @Success      204  {string}  string "Ok"
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /roles/{name} [delete]
+func (c *Controller) DeleteRole(ctx *gin.Context) {
+	name := ctx.Param("name")
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.roles.RoleOne(name)
+	if err == nil {
+		err = c.roles.RoleDelete(name)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		case errors.Is(err, model.ErrRoleInUse):
+			httputil.NewError(ctx, http.StatusConflict, err)
+		default:
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(admin, model.AuditActionRoleDelete, model.AuditEntityRole, types.Id(name), before, nil)
+
+	ctx.JSON(http.StatusNoContent, "Ok")
+}
+
+// SetUserRoles godoc
+// @Summary      Set the roles of a user
+// @Description  Replace the roles of a user, which applies to the next requests of the user. Needs the role:manage permission, which cannot be taken from the last users having it.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                  true  "User ID"
+// @Param        roles    body      model.UserRolesRequest  true  "Roles"
+// @Success      200  {object}  model.User
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/{id}/roles [put]
+func (c *Controller) SetUserRoles(ctx *gin.Context) {
+	id := types.Id(ctx.Param("id"))
+	var req model.UserRolesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	roles, err := c.roles.RolesAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if err := req.Validation(roles); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.users.UserOne(id)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	err = c.users.UserSetRoles(id, tools.UniqueStrings(req.Roles))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		case errors.Is(err, model.ErrNoRoleManager):
+			httputil.NewError(ctx, http.StatusConflict, err)
+		default:
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	after, err := c.users.UserOne(id)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(admin, model.AuditActionUserRoles, model.AuditEntityUser, id, before, after)
+
+	ctx.JSON(http.StatusOK, after)
+}
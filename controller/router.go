@@ -3,11 +3,12 @@ package controller
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/model"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 )
 
-func SetupRouter() (*gin.Engine, *Controller) {
+func SetupRouter(c *Controller) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.MaxMultipartMemory = 20 << 20 // 20 MiB
@@ -15,53 +16,120 @@ func SetupRouter() (*gin.Engine, *Controller) {
 	// TODO: Change to specific CORS rules?
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "X-Total-Count", "Authorization"}
-	config.ExposeHeaders = []string{"Origin", "Content-Length", "Content-Type", "X-Total-Count", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "X-Total-Count", "Authorization", IdempotencyKeyHeader}
+	config.ExposeHeaders = []string{"Origin", "Content-Length", "Content-Type", "X-Total-Count", "Authorization", IdempotentReplayedHeader}
 	r.Use(cors.New(config))
 
-	c := NewController()
-
 	v1 := r.Group("/api/v1")
 	{
 		deposit := v1.Group("/deposit")
 		{
-			deposit.Use(c.Auth())
+			deposit.Use(c.Auth(), c.Permit(model.PermissionMachineDeposit), c.Idempotent())
 			deposit.POST("", c.Deposit)
 		}
 		buy := v1.Group("/buy")
 		{
-			buy.Use(c.Auth())
+			buy.Use(c.Auth(), c.Permit(model.PermissionMachineBuy), c.Idempotent())
 			buy.POST("", c.Buy)
 		}
+		checkout := v1.Group("/checkout")
+		{
+			checkout.Use(c.Auth(), c.Permit(model.PermissionMachineBuy), c.Idempotent())
+			checkout.POST("", c.Checkout)
+		}
+		purchases := v1.Group("/purchases")
+		{
+			purchases.Use(c.Auth())
+			purchases.GET("", c.ListPurchases)
+			purchases.GET(":id/receipt", c.ShowReceipt)
+			purchases.GET(":id/refund", c.ShowPurchaseRefund)
+			purchases.POST(":id/refund", c.Permit(model.PermissionPurchaseRefund), c.RefundPurchase)
+		}
+		earnings := v1.Group("/earnings")
+		{
+			earnings.Use(c.Auth(), c.Permit(model.PermissionEarningsReadOwn, model.PermissionEarningsReadAny))
+			earnings.GET("", c.ShowEarnings)
+		}
+		payouts := v1.Group("/payouts")
+		{
+			payouts.Use(c.Auth())
+			payouts.GET("", c.Permit(model.PermissionEarningsReadOwn, model.PermissionEarningsReadAny), c.ListPayouts)
+			payouts.POST("", c.Permit(model.PermissionPayoutWrite), c.Payout)
+		}
 		reset := v1.Group("/reset")
 		{
-			reset.Use(c.Auth())
+			reset.Use(c.Auth(), c.Permit(model.PermissionMachineDeposit), c.Idempotent())
 			reset.POST("", c.Reset)
 		}
 		user := v1.Group("/user")
 		{
 			user.POST("", c.AddUser)
 			user.POST("/login", c.Login)
+			user.POST("/login/totp", c.LoginTotp)
+			user.POST("/token/refresh", c.RefreshToken)
 			user.POST("/logout/all", c.LogoutAll)
-			user.POST("/logout", c.Auth(), c.Logout)
+			user.POST("/logout", c.AuthWithoutTotp(), c.Logout)
+			user.POST("/totp", c.AuthWithoutTotp(), c.EnrollTotp)
+			user.POST("/totp/confirm", c.AuthWithoutTotp(), c.ConfirmTotp)
+			user.POST("/totp/disable", c.Auth(), c.DisableTotp)
+			user.GET("/sessions", c.Auth(), c.ListSessions)
+			user.DELETE("/sessions/:id", c.Auth(), c.RevokeSession)
 			user.GET(":id", c.Auth(), c.ShowUser)
-			user.GET("", c.Auth(), c.ListUsers)
+			user.GET("", c.Auth(), c.Permit(model.PermissionUserReadAny), c.ListUsers)
 			user.DELETE(":id", c.Auth(), c.DeleteUser)
 			user.PATCH(":id", c.Auth(), c.UpdateUser)
+			user.PUT(":id/roles", c.Auth(), c.Permit(model.PermissionRoleManage), c.SetUserRoles)
+			user.DELETE(":id/totp", c.Auth(), c.Permit(model.PermissionTotpManage), c.ResetUserTotp)
 		}
 		product := v1.Group("/product")
 		{
 			product.GET(":id", c.ShowProduct)
 			product.GET("", c.ListProducts)
-			product.POST("", c.Auth(), c.AddProduct)
-			product.DELETE(":id", c.Auth(), c.DeleteProduct)
-			product.PATCH(":id", c.Auth(), c.UpdateProduct)
+			product.POST("", c.Auth(), c.Permit(model.PermissionProductWriteOwn), c.AddProduct)
+			product.DELETE(":id", c.Auth(), c.Permit(model.PermissionProductWriteOwn, model.PermissionProductWriteAny), c.DeleteProduct)
+			product.PATCH(":id", c.Auth(), c.Permit(model.PermissionProductWriteOwn, model.PermissionProductWriteAny), c.UpdateProduct)
+		}
+		machine := v1.Group("/machine")
+		{
+			machine.Use(c.Auth(), c.Permit(model.PermissionMachineManage))
+			machine.GET("/coins", c.ListCoins)
+			machine.POST("/coins", c.LoadCoins)
+			machine.DELETE("/coins", c.EmptyCoins)
+			machine.GET("/events", c.ListEvents)
+			machine.GET("/state", c.ShowMachineState)
+			machine.GET("/reconcile", c.Reconcile)
+		}
+		lockouts := v1.Group("/lockouts")
+		{
+			lockouts.Use(c.Auth(), c.Permit(model.PermissionLockoutManage))
+			lockouts.GET("", c.ListLockouts)
+			lockouts.DELETE("", c.ClearLockout)
+		}
+		totp := v1.Group("/totp")
+		{
+			totp.Use(c.Auth(), c.Permit(model.PermissionTotpManage))
+			totp.GET("/required-roles", c.ShowTotpRequiredRoles)
+			totp.PUT("/required-roles", c.SetTotpRequiredRoles)
+		}
+		audit := v1.Group("/audit")
+		{
+			audit.Use(c.Auth(), c.Permit(model.PermissionAuditRead))
+			audit.GET("", c.ListAudit)
+		}
+		roles := v1.Group("/roles")
+		{
+			roles.Use(c.Auth(), c.Permit(model.PermissionRoleManage))
+			roles.GET("", c.ListRoles)
+			roles.GET("/permissions", c.ListPermissions)
+			roles.PUT(":name", c.SaveRole)
+			roles.DELETE(":name", c.DeleteRole)
 		}
 		tools := v1.Group("/tools")
 		{
 			tools.GET("/ping", c.Ping)
 		}
 	}
+	r.GET("/.well-known/jwks.json", c.ShowJwks)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	return r, c
+	return r
 }
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+)
+
+// ListSessions godoc
+// @Summary      List sessions
+// @Description  List the unexpired sessions of the current user, the newest first, the session of the request is marked as current
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   model.Session
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/sessions [get]
+func (c *Controller) ListSessions(ctx *gin.Context) {
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	res, err := c.sessions.SessionsByUser(userId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	current := c.getSessionIdFromContext(ctx)
+	for _, session := range res {
+		session.Current = session.ID == current
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// RevokeSession godoc
+// @Summary      Revoke a session
+// @Description  End a session of the current user, e.g. on a lost phone, its tokens are refused from then on
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Session ID"
+// @Success      204  {string}  string "Ok"
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/sessions/{id} [delete]
+func (c *Controller) RevokeSession(ctx *gin.Context) {
+	id := types.Id(ctx.Param("id"))
+
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	currentUser, err := c.users.UserOne(userId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	// sessions of other users are reported as missing, not to reveal them
+	sessions, err := c.sessions.SessionsByUser(userId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	var session *model.Session
+	for _, s := range sessions {
+		if s.ID == id {
+			session = s
+		}
+	}
+	if session == nil {
+		httputil.NewError(ctx, http.StatusNotFound, model.ErrNotFound)
+		return
+	}
+
+	err = c.sessions.SessionDelete(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(currentUser, model.AuditActionSessionRevoke, model.AuditEntitySession, id, session, nil)
+
+	ctx.JSON(http.StatusNoContent, "Ok")
+}
+
+// RefreshToken godoc
+// @Summary      Refresh tokens
+// @Description  Exchange a refresh token for a new access token and a new refresh token of the same session. A refresh token works once, using it again revokes the session.
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param		 request body	model.RefreshTokenRequest true  "Refresh Token Request"
+// @Success      200  {object}  model.LoginResponse
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      401  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Router       /user/token/refresh [post]
+func (c *Controller) RefreshToken(ctx *gin.Context) {
+	var req model.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now()
+	token, next, err := newRefreshToken(now)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := c.sessions.SessionRefresh(model.HashRefreshToken(req.RefreshToken), next, now)
+	if errors.Is(err, model.ErrRefreshTokenReused) {
+		// a used token is presented by someone holding a stolen copy, or the owner after a theft,
+		// so the whole session is ended and recorded
+		// the reuse is recorded without actor if the user is gone
+		user, userErr := c.users.UserOne(session.UserId)
+		if userErr != nil && !errors.Is(userErr, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusInternalServerError, userErr)
+			return
+		}
+		c.audit(user, model.AuditActionTokenReuse, model.AuditEntitySession, session.ID, session, nil)
+		httputil.NewError(ctx, http.StatusUnauthorized, err)
+		return
+	}
+	if errors.Is(err, model.ErrNotFound) {
+		httputil.NewError(ctx, http.StatusUnauthorized, model.ErrInvalidRefreshToken)
+		return
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	res, err := c.issueTokens(session, token, now)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,459 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+)
+
+const (
+	// loginChallengeTtl is how long the second step of a login can be done after the first one
+	loginChallengeTtl = 5 * time.Minute
+	// loginChallengeAudience is appended to the audience of the challenge tokens, so they are not access tokens
+	loginChallengeAudience = "/login/totp"
+)
+
+// loginChallengeClaims are the claims of a challenge token, the subject is the user
+type loginChallengeClaims struct {
+	jwt.StandardClaims
+	Device string `json:"device,omitempty"`
+}
+
+// LoginTotp godoc
+// @Summary      Login with a second factor
+// @Description  Completes the login of a user with a second factor, with the challenge token and a one-time password or a recovery code
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param		 request body	model.LoginTotpRequest true  "Login Totp Request"
+// @Success      200  {object}  model.LoginResponse
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      401  {object}  httputil.HTTPError
+// @Failure      429  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Router       /user/login/totp [post]
+func (c *Controller) LoginTotp(ctx *gin.Context) {
+	var req model.LoginTotpRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	userId, device, err := c.validateLoginChallenge(req.ChallengeToken)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusUnauthorized, err)
+		return
+	}
+	user, err := c.users.UserOne(userId)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusUnauthorized, model.ErrInvalidLoginChallenge)
+		return
+	}
+	attempt := c.beginLogin(ctx, user.UserName)
+	if attempt == nil {
+		return
+	}
+
+	err = c.verifySecondFactor(user.ID, req.TotpCodeRequest)
+	if errors.Is(err, model.ErrTotpInvalidCode) || errors.Is(err, model.ErrTotpCodeUsed) {
+		httputil.NewError(ctx, http.StatusUnauthorized, err)
+		return
+	}
+	if errors.Is(err, model.ErrTotpNotEnrolled) {
+		// the second factor was removed after the first step
+		c.loginNotFailed(attempt)
+		httputil.NewError(ctx, http.StatusUnauthorized, model.ErrInvalidLoginChallenge)
+		return
+	}
+	if err != nil {
+		c.loginNotFailed(attempt)
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.loginSucceeded(attempt)
+
+	_, tokens, err := c.startSession(user, device)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(user, model.AuditActionLogin, model.AuditEntityUser, user.ID, nil, nil)
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// EnrollTotp godoc
+// @Summary      Enroll a second factor
+// @Description  Starts the enrollment of a time-based one-time password second factor of the current user. The secret is added to an authenticator app, e.g. by scanning the provisioning URI as a QR code, and the enrollment is completed at /user/totp/confirm. An unconfirmed enrollment is replaced.
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  model.TotpEnrollmentResponse
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/totp [post]
+func (c *Controller) EnrollTotp(ctx *gin.Context) {
+	currentUser, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	totp, err := c.totps.TotpOne(currentUser.ID)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if err == nil && totp.Confirmed() {
+		httputil.NewError(ctx, http.StatusConflict, model.ErrTotpEnrolled)
+		return
+	}
+
+	totp, err = model.NewTotp(currentUser.ID, time.Now())
+	if err == nil {
+		err = c.totps.TotpSave(totp)
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &model.TotpEnrollmentResponse{
+		Secret:          totp.EncodedSecret(),
+		ProvisioningUri: totp.ProvisioningUri(currentUser.UserName),
+	})
+}
+
+// ConfirmTotp godoc
+// @Summary      Confirm a second factor
+// @Description  Completes the enrollment of the second factor of the current user with a one-time password from the authenticator app. The recovery codes are returned once.
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param		 request body	model.TotpCodeRequest true  "One-time password"
+// @Success      200  {object}  model.RecoveryCodesResponse
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/totp/confirm [post]
+func (c *Controller) ConfirmTotp(ctx *gin.Context) {
+	var req model.TotpCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if req.Code == "" {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrTotpRequired)
+		return
+	}
+	currentUser, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	totp, err := c.totps.TotpOne(currentUser.ID)
+	if errors.Is(err, model.ErrNotFound) {
+		httputil.NewError(ctx, http.StatusNotFound, model.ErrTotpNotEnrolled)
+		return
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if totp.Confirmed() {
+		httputil.NewError(ctx, http.StatusConflict, model.ErrTotpEnrolled)
+		return
+	}
+	now := time.Now()
+	step, ok := totp.Verify(req.Code, now)
+	if !ok {
+		httputil.NewError(ctx, http.StatusBadRequest, model.ErrTotpInvalidCode)
+		return
+	}
+
+	totp.ConfirmedAt = now
+	totp.LastUsedStep = step
+	recoveryCodes, err := totp.NewRecoveryCodes()
+	if err == nil {
+		err = c.totps.TotpSave(totp)
+	}
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(currentUser, model.AuditActionTotpEnroll, model.AuditEntityUser, currentUser.ID, nil, totp)
+
+	ctx.JSON(http.StatusOK, &model.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// DisableTotp godoc
+// @Summary      Disable a second factor
+// @Description  Removes the second factor of the current user, proven with a one-time password or a recovery code. A role requiring a second factor makes the user enroll a new one.
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param		 request body	model.TotpCodeRequest true  "One-time password or recovery code"
+// @Success      204  {string}  string "Ok"
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/totp/disable [post]
+func (c *Controller) DisableTotp(ctx *gin.Context) {
+	var req model.TotpCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	currentUser, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.totps.TotpOne(currentUser.ID)
+	if err == nil {
+		err = c.verifySecondFactor(currentUser.ID, req)
+	}
+	if err == nil {
+		err = c.totps.TotpDelete(currentUser.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrTotpNotEnrolled):
+			httputil.NewError(ctx, http.StatusNotFound, model.ErrTotpNotEnrolled)
+		case errors.Is(err, model.ErrTotpInvalidCode) || errors.Is(err, model.ErrTotpCodeUsed):
+			httputil.NewError(ctx, http.StatusBadRequest, err)
+		default:
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(currentUser, model.AuditActionTotpDisable, model.AuditEntityUser, currentUser.ID, before, nil)
+
+	ctx.JSON(http.StatusNoContent, "Ok")
+}
+
+// ResetUserTotp godoc
+// @Summary      Reset a second factor
+// @Description  Removes the second factor of a user who lost it and their recovery codes, needs the totp:manage permission
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      204  {string}  string "Ok"
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /user/{id}/totp [delete]
+func (c *Controller) ResetUserTotp(ctx *gin.Context) {
+	id := types.Id(ctx.Param("id"))
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.totps.TotpOne(id)
+	if err == nil {
+		err = c.totps.TotpDelete(id)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			httputil.NewError(ctx, http.StatusNotFound, model.ErrTotpNotEnrolled)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(admin, model.AuditActionTotpReset, model.AuditEntityUser, id, before, nil)
+
+	ctx.JSON(http.StatusNoContent, "Ok")
+}
+
+// ShowTotpRequiredRoles godoc
+// @Summary      Show roles requiring a second factor
+// @Description  Show the roles whose users must use a second factor, needs the totp:manage permission
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  model.TotpRequiredRolesRequest
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /totp/required-roles [get]
+func (c *Controller) ShowTotpRequiredRoles(ctx *gin.Context) {
+	roles, err := c.totps.TotpRequiredRoles()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &model.TotpRequiredRolesRequest{Roles: roles})
+}
+
+// SetTotpRequiredRoles godoc
+// @Summary      Require a second factor for roles
+// @Description  Set the roles whose users must use a second factor, needs the totp:manage permission. Their users without one can only enroll it until they do.
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param		 request body	model.TotpRequiredRolesRequest true  "Roles"
+// @Success      200  {object}  model.TotpRequiredRolesRequest
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      403  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /totp/required-roles [put]
+func (c *Controller) SetTotpRequiredRoles(ctx *gin.Context) {
+	var req model.TotpRequiredRolesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	roles, err := c.roles.RolesAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if err := req.Validation(roles); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	admin, ok := c.getCurrentUser(ctx)
+	if !ok {
+		return
+	}
+
+	before, err := c.totps.TotpRequiredRoles()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	err = c.totps.TotpSetRequiredRoles(tools.UniqueStrings(req.Roles))
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	after, err := c.totps.TotpRequiredRoles()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(admin, model.AuditActionTotpRoles, model.AuditEntitySettings, "totp.required-roles", before, after)
+
+	ctx.JSON(http.StatusOK, &model.TotpRequiredRolesRequest{Roles: after})
+}
+
+// --------------- implementation details -------------
+
+// checkTotpEnrollment returns ErrTotpEnrollmentRequired if a role of the user requires a second factor the user
+// has not enrolled yet
+func (c *Controller) checkTotpEnrollment(userId types.Id) (err error) {
+	roles, err := c.totps.TotpRequiredRoles()
+	if err != nil || len(roles) == 0 {
+		return
+	}
+	user, err := c.users.UserOne(userId)
+	if err != nil {
+		return
+	}
+	required := false
+	for _, role := range roles {
+		required = required || user.HasRole(role)
+	}
+	if !required {
+		return
+	}
+	totp, err := c.totps.TotpOne(userId)
+	if errors.Is(err, model.ErrNotFound) || (err == nil && !totp.Confirmed()) {
+		return model.ErrTotpEnrollmentRequired
+	}
+	return
+}
+
+// verifySecondFactor checks the one-time password or the recovery code of the user and uses it up
+func (c *Controller) verifySecondFactor(userId types.Id, req model.TotpCodeRequest) (err error) {
+	totp, err := c.totps.TotpOne(userId)
+	if errors.Is(err, model.ErrNotFound) || (err == nil && !totp.Confirmed()) {
+		return model.ErrTotpNotEnrolled
+	}
+	if err != nil {
+		return
+	}
+
+	if req.Code != "" {
+		step, ok := totp.Verify(req.Code, time.Now())
+		if !ok {
+			return model.ErrTotpInvalidCode
+		}
+		return c.totps.TotpUseStep(userId, step)
+	}
+	err = c.totps.TotpUseRecoveryCode(userId, model.HashRecoveryCode(req.RecoveryCode))
+	if errors.Is(err, model.ErrNotFound) {
+		err = model.ErrTotpInvalidCode
+	}
+	return
+}
+
+// createLoginChallenge signs a short-lived token for the second step of the login of the user on the device
+func (c *Controller) createLoginChallenge(userId types.Id, device string) (res *model.LoginChallengeResponse, err error) {
+	now := time.Now()
+	expires := now.Add(loginChallengeTtl).Truncate(time.Second)
+	token, err := c.signClaims(&loginChallengeClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   string(userId),
+			Id:        xid.New().String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expires.Unix(),
+			Issuer:    c.jwtIssuer,
+			Audience:  c.jwtAudience + loginChallengeAudience,
+		},
+		Device: device,
+	})
+	if err != nil {
+		err = model.ErrCannotGenerateUserToken
+		return
+	}
+	res = &model.LoginChallengeResponse{
+		TotpRequired:     true,
+		ChallengeToken:   token,
+		ChallengeExpires: expires.UnixMilli(),
+	}
+	return
+}
+
+// validateLoginChallenge checks the challenge token and returns its user and device
+func (c *Controller) validateLoginChallenge(token string) (userId types.Id, device string, err error) {
+	claims := &loginChallengeClaims{}
+	err = c.parseClaims(token, claims)
+	if err != nil || claims.Subject == "" || claims.ExpiresAt == 0 || !claims.VerifyIssuer(c.jwtIssuer, true) ||
+		!claims.VerifyAudience(c.jwtAudience+loginChallengeAudience, true) {
+		err = model.ErrInvalidLoginChallenge
+		return
+	}
+	return types.Id(claims.Subject), claims.Device, nil
+}
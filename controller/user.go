@@ -1,11 +1,12 @@
 package controller
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
-	"github.com/oltur/mvp-match/tools"
 	"github.com/oltur/mvp-match/types"
 	"github.com/rs/xid"
 	"net/http"
@@ -14,15 +15,16 @@ import (
 
 // Login godoc
 // @Summary      Login
-// @Description  Logs user in
+// @Description  Logs user in. A user with a second factor gets a challenge, completed at /user/login/totp.
 // @Tags         User
 // @Accept       json
 // @Produce      json
 // @Param		 credentials body	model.LoginRequest true  "Login Request"
 // @Success      200  {string}  model.LoginResponse
+// @Success      202  {object}  model.LoginChallengeResponse
 // @Failure      400  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
-// @Failure      409  {object}  httputil.HTTPError
+// @Failure      429  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Router       /user/login [post]
 func (c *Controller) Login(ctx *gin.Context) {
@@ -32,61 +34,152 @@ func (c *Controller) Login(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-	gwtToken, tokenExpires, err := c.DoLogin(req.UserName, req.Password)
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	attempt := c.beginLogin(ctx, req.UserName)
+	if attempt == nil {
+		return
+	}
+	user, _, tokens, err := c.doLogin(req.UserName, req.Password, req.Device)
+	if errors.Is(err, model.ErrTotpRequired) {
+		// the failures of the account are kept until the second step, so the codes cannot be guessed endlessly
+		c.loginNotFailed(attempt)
+		challenge, err := c.createLoginChallenge(user.ID, req.Device)
+		if err != nil {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, challenge)
+		return
+	}
 	if err != nil {
 		if errors.Is(err, model.ErrNotFound) {
 			httputil.NewError(ctx, http.StatusNotFound, err)
-		} else if errors.Is(err, model.ErrActiveSessionExists) {
-			httputil.NewError(ctx, http.StatusConflict, err)
 		} else {
+			c.loginNotFailed(attempt)
 			httputil.NewError(ctx, http.StatusInternalServerError, err)
 		}
 		return
 	}
+	c.loginSucceeded(attempt)
 
-	res := &model.LoginResponse{
-		Token:        gwtToken,
-		TokenExpires: tokenExpires,
-	}
+	c.audit(user, model.AuditActionLogin, model.AuditEntityUser, user.ID, nil, nil)
 
-	ctx.JSON(http.StatusOK, res)
+	ctx.JSON(http.StatusOK, tokens)
 }
 
+// DoLogin logs the user in, ErrTotpRequired is returned for a user with a second factor, who logs in with
+// Login and LoginTotp
 func (c *Controller) DoLogin(userName string, password string) (gwtToken string, tokenExpires int64, err error) {
-	user, err := model.GetUserByCredentials(userName, password)
+	_, _, tokens, err := c.doLogin(userName, password, "")
+	if err != nil {
+		return
+	}
+	return tokens.Token, tokens.TokenExpires, nil
+}
+
+// doLogin is DoLogin starting a session on the given device and returning the logged in user, the session and
+// both of its tokens. ErrTotpRequired is returned with the user if a second factor is required.
+func (c *Controller) doLogin(userName string, password string, device string) (user *model.User, session *model.Session, tokens *model.LoginResponse, err error) {
+	user, err = c.users.GetUserByCredentials(userName, password, c.passwordHasher)
 	if err != nil {
 		err = model.ErrNotFound
-		return "", 0, err
+		return nil, nil, nil, err
 	}
 
-	token := xid.New().String()
-	tokenExpires = time.Now().Add(30 * 24 * time.Hour).UnixMilli()
+	totp, err := c.totps.TotpOne(user.ID)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		return nil, nil, nil, err
+	}
+	if err == nil && totp.Confirmed() {
+		return user, nil, nil, model.ErrTotpRequired
+	}
 
-	gwtToken, err = c.createGwt(string(user.ID), token, tokenExpires)
+	session, tokens, err = c.startSession(user, device)
 	if err != nil {
-		err = model.ErrCannotGenerateUserToken
-		return "", 0, err
+		return nil, nil, nil, err
+	}
+	return user, session, tokens, nil
+}
+
+// startSession starts a session of the logged in user on the given device and returns both of its tokens
+func (c *Controller) startSession(user *model.User, device string) (session *model.Session, tokens *model.LoginResponse, err error) {
+	now := time.Now()
+	session = &model.Session{
+		ID:         types.Id(xid.New().String()),
+		UserId:     user.ID,
+		Device:     device,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(c.sessionTtl),
+	}
+	token, refreshToken, err := newRefreshToken(now)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken.SessionId = session.ID
+	tokens, err = c.issueTokens(session, token, now)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	now := time.Now().UnixMilli()
-	if user.Token != "" && user.TokenExpires >= now {
-		err = model.ErrActiveSessionExists
-		return "", 0, err
+	err = c.sessions.SessionInsert(session, refreshToken)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	user.Token = token
-	user.TokenExpires = tokenExpires
+	err = c.checkTotpEnrollment(user.ID)
+	tokens.TotpEnrollmentRequired = errors.Is(err, model.ErrTotpEnrollmentRequired)
+	if err != nil && !tokens.TotpEnrollmentRequired {
+		return nil, nil, err
+	}
+	return session, tokens, nil
+}
 
-	err = model.UserSave(user)
+// newRefreshToken generates a random refresh token, returned hashed for the store as well
+func newRefreshToken(now time.Time) (token string, res *model.RefreshToken, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
 	if err != nil {
-		return "", 0, err
+		err = model.ErrCannotGenerateUserToken
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	res = &model.RefreshToken{
+		Hash:      model.HashRefreshToken(token),
+		CreatedAt: now,
+	}
+	return
+}
+
+// issueTokens creates a new access token of the session and puts it into a response with the refresh token.
+// The access token does not outlive the session.
+func (c *Controller) issueTokens(session *model.Session, refreshToken string, now time.Time) (res *model.LoginResponse, err error) {
+	// whole seconds, as the token expiry is in seconds
+	expires := now.Add(c.accessTokenTtl).Truncate(time.Second)
+	if expires.After(session.ExpiresAt) {
+		expires = session.ExpiresAt.Truncate(time.Second)
+	}
+	gwtToken, err := c.createGwt(string(session.UserId), string(session.ID), expires)
+	if err != nil {
+		err = model.ErrCannotGenerateUserToken
+		return
+	}
+	res = &model.LoginResponse{
+		Token:               gwtToken,
+		TokenExpires:        expires.UnixMilli(),
+		RefreshToken:        refreshToken,
+		RefreshTokenExpires: session.ExpiresAt.UnixMilli(),
+		SessionId:           session.ID,
 	}
-	return gwtToken, tokenExpires, err
+	return
 }
 
 // Logout godoc
 // @Summary      Logout
-// @Description  Logs user out
+// @Description  Logs user out of the current session
 // @Tags         User
 // @Accept       json
 // @Produce      json
@@ -103,59 +196,81 @@ func (c *Controller) Logout(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Admin role
-	currentUser, err := model.UserOne(userId)
+	currentUser, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
 
-	err = model.UserLogout(currentUser.ID)
-	if err != nil {
+	sessionId := c.getSessionIdFromContext(ctx)
+	err = c.sessions.SessionDelete(sessionId)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
+	c.audit(currentUser, model.AuditActionLogout, model.AuditEntityUser, currentUser.ID, nil, nil)
 
 	ctx.JSON(http.StatusNoContent, "Ok")
 }
 
 // LogoutAll godoc
 // @Summary      Log out all user's sessions
-// @Description  Logs current user ouy of all sessions
+// @Description  Logs current user ouy of all sessions, a user with a second factor gives a one-time password or a recovery code too
 // @Tags         User
 // @Accept       json
 // @Produce      json
-// @Param		 credentials body	model.LoginRequest true  "Login Request"
+// @Param		 credentials body	model.LogoutAllRequest true  "Logout All Request"
 // @Success      204  {string}  string "Ok"
 // @Failure      400  {object}  httputil.HTTPError
+// @Failure      401  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
 // @Failure      409  {object}  httputil.HTTPError
+// @Failure      429  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Router       /user/logout/all [post]
 func (c *Controller) LogoutAll(ctx *gin.Context) {
 	var err error
-	var req model.LoginRequest
+	var req model.LogoutAllRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-	user, err := model.GetUserByCredentials(req.UserName, req.Password)
+	attempt := c.beginLogin(ctx, req.UserName)
+	if attempt == nil {
+		return
+	}
+	user, err := c.users.GetUserByCredentials(req.UserName, req.Password, c.passwordHasher)
 	if err != nil {
 		err = model.ErrNotFound
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	// the password alone does not end the sessions of a user with a second factor
+	err = c.verifySecondFactor(user.ID, req.TotpCodeRequest)
+	switch {
+	case err == nil, errors.Is(err, model.ErrTotpNotEnrolled):
+	case req.Code == "" && req.RecoveryCode == "":
+		c.loginNotFailed(attempt)
+		httputil.NewError(ctx, http.StatusUnauthorized, model.ErrTotpRequired)
+		return
+	case errors.Is(err, model.ErrTotpInvalidCode), errors.Is(err, model.ErrTotpCodeUsed):
+		httputil.NewError(ctx, http.StatusUnauthorized, err)
+		return
+	default:
+		c.loginNotFailed(attempt)
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.loginSucceeded(attempt)
 
-	user.Token = ""
-	user.TokenExpires = 0
-
-	err = model.UserSave(user)
+	err = c.users.UserLogout(user.ID)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
+	c.audit(user, model.AuditActionLogoutAll, model.AuditEntityUser, user.ID, nil, nil)
 
-	ctx.JSON(http.StatusNotFound, "Ok")
+	ctx.JSON(http.StatusNoContent, "Ok")
 }
 
 // ShowUser godoc
@@ -181,20 +296,14 @@ func (c *Controller) ShowUser(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Admin role
-	currentUser, err := model.UserOne(userId)
-	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
-		return
-	}
-	// can be viewed by themselves or by admin
-	if currentUser.Role != model.UserRoleAdmin && userId != id {
+	// can be viewed by themselves or by those allowed to view all
+	if userId != id && !c.can(ctx, model.PermissionUserReadAny) {
 		err = model.ErrAccessDenied
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
 
-	user, err := model.UserOne(id)
+	user, err := c.users.UserOne(id)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
@@ -217,27 +326,8 @@ func (c *Controller) ShowUser(ctx *gin.Context) {
 // @Security     ApiKeyAuth
 // @Router       /user [get]
 func (c *Controller) ListUsers(ctx *gin.Context) {
-
-	userId, err := c.getUserIdFromContext(ctx)
-	if err != nil {
-		httputil.NewError(ctx, http.StatusForbidden, err)
-		return
-	}
-	// check Admin role
-	currentUser, err := model.UserOne(userId)
-	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
-		return
-	}
-	// can be viewed by admin only
-	if currentUser.Role != model.UserRoleAdmin {
-		err = model.ErrInvalidSeller
-		httputil.NewError(ctx, http.StatusForbidden, err)
-		return
-	}
-
 	q := ctx.Query("q")
-	users, err := model.UsersAll(q)
+	users, err := c.users.UsersAll(q)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
@@ -247,7 +337,7 @@ func (c *Controller) ListUsers(ctx *gin.Context) {
 
 // AddUser godoc
 // @Summary      Add an user
-// @Description  Add new user
+// @Description  Add new user with one or more of the roles users can register with
 // @Tags         User
 // @Accept       json
 // @Produce      json
@@ -263,24 +353,33 @@ func (c *Controller) AddUser(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-	if err := req.Validation(); err != nil {
+	roles, err := c.roles.RolesAll()
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if err := req.Validation(c.passwordPolicy, roles); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	passwordHash, err := c.passwordHasher.Hash(req.Password)
+	if err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
 	user := &model.User{
 		ID:           types.Id(xid.New().String()),
 		UserName:     req.UserName,
-		PasswordHash: tools.Hash(req.Password),
+		PasswordHash: passwordHash,
 		Deposit:      0,
-		Role:         req.Role,
-		Token:        "",
-		TokenExpires: 0,
+		Roles:        req.AllRoles(),
 	}
-	res, err := model.UserInsert(user)
+	res, err := c.users.UserInsert(user)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	c.audit(res, model.AuditActionUserAdd, model.AuditEntityUser, res.ID, nil, res)
 	ctx.JSON(http.StatusOK, res)
 }
 
@@ -310,30 +409,48 @@ func (c *Controller) UpdateUser(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Admin role
-	currentUser, err := model.UserOne(userId)
+	currentUser, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	// can be updated by themselves or by admin
-	if currentUser.Role != model.UserRoleAdmin && userId != updateUserRequest.ID {
-		err = model.ErrInvalidSeller
+	// can be updated by themselves or by those allowed to change all
+	if userId != updateUserRequest.ID && !c.can(ctx, model.PermissionUserWriteAny) {
+		err = model.ErrAccessDenied
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
 
-	err = model.UserUpdate(&updateUserRequest)
+	before, err := c.users.UserOne(updateUserRequest.ID)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	if err := updateUserRequest.Validation(c.passwordPolicy, before.UserName); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	err = c.users.UserUpdate(&updateUserRequest, c.passwordHasher)
+	if errors.Is(err, model.ErrPasswordTooLong) {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	after, err := c.users.UserOne(updateUserRequest.ID)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	c.audit(currentUser, model.AuditActionUserUpdate, model.AuditEntityUser, updateUserRequest.ID, before, after)
 	ctx.JSON(http.StatusOK, updateUserRequest)
 }
 
 // DeleteUser godoc
 // @Summary      Delete an user
-// @Description  Delete by user ID
+// @Description  Delete by user ID, the last users allowed to manage the roles cannot be deleted
 // @Tags         User
 // @Accept       json
 // @Produce      json
@@ -341,6 +458,7 @@ func (c *Controller) UpdateUser(ctx *gin.Context) {
 // @Success 	 204  {string} string "Ok"
 // @Failure      400  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Failure      401      {object}  httputil.HTTPError
 // @Security     ApiKeyAuth
@@ -354,23 +472,35 @@ func (c *Controller) DeleteUser(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-	// check Admin role
-	currentUser, err := model.UserOne(userId)
+	currentUser, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	// can be deleted by themselves or by admin
-	if currentUser.Role != model.UserRoleAdmin && userId != id {
-		err = model.ErrInvalidSeller
+	// can be deleted by themselves or by those allowed to change all
+	if userId != id && !c.can(ctx, model.PermissionUserWriteAny) {
+		err = model.ErrAccessDenied
 		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
 
-	err = model.UserDelete(id)
+	before, err := c.users.UserOne(id)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	err = c.users.UserDelete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			httputil.NewError(ctx, http.StatusNotFound, err)
+		case errors.Is(err, model.ErrNoRoleManager):
+			httputil.NewError(ctx, http.StatusConflict, err)
+		default:
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	c.audit(currentUser, model.AuditActionUserDelete, model.AuditEntityUser, id, before, nil)
 	ctx.JSON(http.StatusNoContent, "Ok")
 }
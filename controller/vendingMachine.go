@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"github.com/oltur/mvp-match/types"
 	"net/http"
 	"strconv"
@@ -12,14 +13,17 @@ import (
 
 // Deposit godoc
 // @Summary      Deposit money
-// @Description  Deposit a coin of given value for current Buyer user
+// @Description  Deposit a coin of given value for current Buyer user, the accepted coin values depend on the machine currency
 // @Tags         Vending Machine
 // @Accept       json
 // @Produce      json
-// @Param        coinValue     query     int     false  "Coin value"       Enums(5, 10, 20, 50, 100)
+// @Param        coinValue     query     int     false  "Coin value, in the smallest currency unit"
+// @Param        Idempotency-Key  header  string  false  "Key to retry the request safely, the stored response is returned for a repeated key"
 // @Success      200  {object}  model.DepositResponse
 // @Failure      400  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      422  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Failure      401      {object}  httputil.HTTPError
 // @Security     ApiKeyAuth
@@ -39,33 +43,27 @@ func (c *Controller) Deposit(ctx *gin.Context) {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-	err = coin.Validation()
+	err = coin.Validation(c.currency)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
-	if user.Role != model.UserRoleBuyer {
-		err = model.ErrInvalidBuyer
-		httputil.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-
-	user.Deposit = user.Deposit + coin.Value
-
-	err = model.UserSave(user)
+	before := user
+	user, err = c.vending.Deposit(user.ID, coin)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
+	c.audit(before, model.AuditActionDeposit, model.AuditEntityUser, user.ID, before, user)
 
-	res := &model.DepositResponse{Deposit: user.Deposit}
+	res := &model.DepositResponse{Deposit: user.Deposit, Coins: user.DepositCoins, Currency: c.currency.Code}
 
 	ctx.JSON(http.StatusOK, res)
 }
@@ -78,9 +76,12 @@ func (c *Controller) Deposit(ctx *gin.Context) {
 // @Produce      json
 // @Param        productId   query      string  true  "Product ID"
 // @Param        amountOfProducts     query     int     false  "Amount of products"
+// @Param        Idempotency-Key  header  string  false  "Key to retry the request safely, the stored response is returned for a repeated key"
 // @Success      200  {object}  model.BuyResponse
 // @Failure      400  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      422  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Failure      401      {object}  httputil.HTTPError
 // @Security     ApiKeyAuth
@@ -103,76 +104,99 @@ func (c *Controller) Buy(ctx *gin.Context) {
 	}
 
 	// load and validate data
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
-	if user.Role != model.UserRoleBuyer {
-		err = model.ErrInvalidBuyer
-		httputil.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
 
-	product, err := model.ProductOne(productId)
+	// buy!
+	purchase, err := c.vending.Purchase(user.ID, []*model.CartItem{{ProductId: productId, AmountOfProducts: amountOfProducts}}, c.makeChange)
 	if err != nil {
-		httputil.NewError(ctx, http.StatusNotFound, err)
+		c.purchaseError(ctx, err)
 		return
 	}
+	c.audit(user, model.AuditActionBuy, model.AuditEntityOrder, purchase.Order.ID, nil, purchase.Order)
 
-	totalCost := product.Cost * amountOfProducts
+	res := &model.BuyResponse{OrderId: purchase.Order.ID, Total: purchase.Total, ProductName: purchase.Lines[0].Product.ProductName, Change: purchase.Change, Currency: c.currency.Code}
 
-	if user.Deposit < totalCost {
-		err = model.ErrNotEnoughDeposit
-		httputil.NewError(ctx, http.StatusBadRequest, err)
+	ctx.JSON(http.StatusOK, res)
+
+}
+
+// Checkout godoc
+// @Summary      Checkout cart
+// @Description  Buy several products at once for current Buyer user, the whole cart is bought or nothing, with the change paid once
+// @Tags         Vending Machine
+// @Accept       json
+// @Produce      json
+// @Param        cart  body      model.CheckoutRequest  true  "Cart items"
+// @Param        Idempotency-Key  header  string  false  "Key to retry the request safely, the stored response is returned for a repeated key"
+// @Success      200  {object}  model.CheckoutResponse
+// @Failure      400  {object}  httputil.HTTPError
+// @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      422  {object}  httputil.HTTPError
+// @Failure      500  {object}  httputil.HTTPError
+// @Failure      401      {object}  httputil.HTTPError
+// @Security     ApiKeyAuth
+// @Router       /checkout [post]
+func (c *Controller) Checkout(ctx *gin.Context) {
+	var err error
+	userId, err := c.getUserIdFromContext(ctx)
+	if err != nil {
+		httputil.NewError(ctx, http.StatusForbidden, err)
 		return
 	}
-
-	if product.AmountAvailable < amountOfProducts {
-		err = model.ErrNotEnoughAmount
+	var req model.CheckoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
-
-	// check change
-	totalChange := user.Deposit - totalCost
-	change, err := c.calculateChange(totalChange)
-	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
+	if err := req.Validation(); err != nil {
+		httputil.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	// buy!
-	product.AmountAvailable = product.AmountAvailable - amountOfProducts
-	user.Deposit = 0
-
-	err = model.ProductSave(product)
+	// load and validate data
+	user, err := c.users.UserOne(userId)
 	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
-
-	err = model.UserSave(user)
+	purchase, err := c.vending.Purchase(user.ID, req.Items, c.makeChange)
 	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		c.purchaseError(ctx, err)
 		return
 	}
+	c.audit(user, model.AuditActionCheckout, model.AuditEntityOrder, purchase.Order.ID, nil, purchase.Order)
 
-	res := &model.BuyResponse{Total: totalCost, ProductName: product.ProductName, Change: change}
+	res := &model.CheckoutResponse{OrderId: purchase.Order.ID, Total: purchase.Total, Change: purchase.Change, Currency: c.currency.Code}
+	for _, line := range purchase.Lines {
+		res.Lines = append(res.Lines, &model.CheckoutResponseLine{
+			ProductId:        line.Product.ID,
+			ProductName:      line.Product.ProductName,
+			AmountOfProducts: line.Amount,
+			Cost:             line.Product.Cost,
+			Total:            line.Total,
+		})
+	}
 
 	ctx.JSON(http.StatusOK, res)
-
 }
 
 // Reset godoc
 // @Summary      Reset deposit
-// @Description  Reset current user deposit
+// @Description  Reset current user deposit, returning it as change or, if the machine refund policy requires it, as the inserted coins
 // @Tags         Vending Machine
 // @Accept       json
 // @Produce      json
-// @Success      200  {object}  model.BuyResponse
+// @Param        Idempotency-Key  header  string  false  "Key to retry the request safely, the stored response is returned for a repeated key"
+// @Success      200  {object}  model.ResetResponse
 // @Failure      400  {object}  httputil.HTTPError
 // @Failure      404  {object}  httputil.HTTPError
+// @Failure      409  {object}  httputil.HTTPError
+// @Failure      422  {object}  httputil.HTTPError
 // @Failure      500  {object}  httputil.HTTPError
 // @Failure      401      {object}  httputil.HTTPError
 // @Security     ApiKeyAuth
@@ -195,30 +219,28 @@ func (c *Controller) Reset(ctx *gin.Context) {
 	userId := types.Id(s)
 
 	// load and validate data
-	user, err := model.UserOne(userId)
+	user, err := c.users.UserOne(userId)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
-	if user.Role != model.UserRoleBuyer {
-		err = model.ErrInvalidBuyer
-		httputil.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-
-	change, err := c.calculateChange(user.Deposit)
+	change, err := c.vending.Refund(user.ID, c.returnInsertedCoins, c.makeChange)
 	if err != nil {
-		httputil.NewError(ctx, http.StatusInternalServerError, err)
+		if errors.Is(err, model.ErrCannotMakeChange) {
+			httputil.NewError(ctx, http.StatusConflict, err)
+		} else {
+			httputil.NewError(ctx, http.StatusInternalServerError, err)
+		}
 		return
 	}
-
-	err = model.UserResetDeposit(user.ID)
+	after, err := c.users.UserOne(user.ID)
 	if err != nil {
 		httputil.NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
+	c.audit(user, model.AuditActionReset, model.AuditEntityUser, user.ID, user, after)
 
-	res := &model.ResetResponse{Change: change}
+	res := &model.ResetResponse{Change: change, Currency: c.currency.Code}
 
 	ctx.JSON(http.StatusOK, res)
 
@@ -226,30 +248,25 @@ func (c *Controller) Reset(ctx *gin.Context) {
 
 // --------------- implementation details -------------
 
-func (c Controller) calculateChange(totalChange int) (res []*model.Coin, err error) {
-	res = make([]*model.Coin, 0, 1)
-	for totalChange > 0 {
-		coin := &model.Coin{}
-		if totalChange >= 100 {
-			coin.Value = 100
-		} else if totalChange >= 50 {
-			coin.Value = 50
-		} else if totalChange >= 20 {
-			coin.Value = 20
-		} else if totalChange >= 10 {
-			coin.Value = 10
-		} else if totalChange >= 5 {
-			coin.Value = 5
-		} else {
-			err = model.ErrInvalidCost
-			return
-		}
-		err = coin.Validation()
-		if err != nil {
-			return
-		}
-		res = append(res, coin)
-		totalChange = totalChange - coin.Value
+// purchaseError maps a failed purchase to the response status
+func (c *Controller) purchaseError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		httputil.NewError(ctx, http.StatusNotFound, err)
+	case errors.Is(err, model.ErrPurchaseConflict):
+		httputil.NewError(ctx, http.StatusConflict, err)
+	case errors.Is(err, model.ErrInvalidAmountOfProducts),
+		errors.Is(err, model.ErrEmptyCart),
+		errors.Is(err, model.ErrNotEnoughDeposit),
+		errors.Is(err, model.ErrNotEnoughAmount),
+		errors.Is(err, model.ErrCannotMakeChange):
+		httputil.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputil.NewError(ctx, http.StatusInternalServerError, err)
 	}
-	return
+}
+
+// makeChange pays the change with the machine strategy, using coins of the machine currency only
+func (c *Controller) makeChange(totalChange int, available map[int]int) (res []*model.Coin, err error) {
+	return c.changeStrategy.MakeChange(totalChange, c.currency.FilterCoins(available))
 }
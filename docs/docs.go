@@ -25,14 +25,14 @@ const docTemplate_swagger = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/buy": {
-            "post": {
+        "/audit": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Buy given amount of given product for current Buyer user",
+                "description": "List the audit log entries, the oldest first, filtered by actor, entity and time range, needs the audit:read permission. The log cannot be changed through the API.",
                 "consumes": [
                     "application/json"
                 ],
@@ -40,21 +40,38 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Vending Machine"
+                    "Audit"
                 ],
-                "summary": "Buy product",
+                "summary": "List audit log",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Product ID",
-                        "name": "productId",
-                        "in": "query",
-                        "required": true
+                        "description": "Actor user ID",
+                        "name": "actorId",
+                        "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "description": "Amount of products",
-                        "name": "amountOfProducts",
+                        "type": "string",
+                        "description": "Entity type, e.g. user, product, order, coins, payout",
+                        "name": "entityType",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Entity ID",
+                        "name": "entityId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the time range, inclusive, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the time range, exclusive, RFC 3339",
+                        "name": "to",
                         "in": "query"
                     }
                 ],
@@ -62,7 +79,10 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.BuyResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.AuditEntry"
+                            }
                         }
                     },
                     "400": {
@@ -77,8 +97,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -92,14 +112,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/deposit": {
+        "/buy": {
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Deposit a coin of given value for current Buyer user",
+                "description": "Buy given amount of given product for current Buyer user",
                 "consumes": [
                     "application/json"
                 ],
@@ -109,27 +129,33 @@ const docTemplate_swagger = `{
                 "tags": [
                     "Vending Machine"
                 ],
-                "summary": "Deposit money",
+                "summary": "Buy product",
                 "parameters": [
                     {
-                        "enum": [
-                            5,
-                            10,
-                            20,
-                            50,
-                            100
-                        ],
+                        "type": "string",
+                        "description": "Product ID",
+                        "name": "productId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
                         "type": "integer",
-                        "description": "Coin value",
-                        "name": "coinValue",
+                        "description": "Amount of products",
+                        "name": "amountOfProducts",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to retry the request safely, the stored response is returned for a repeated key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.DepositResponse"
+                            "$ref": "#/definitions/model.BuyResponse"
                         }
                     },
                     "400": {
@@ -150,6 +176,18 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -159,9 +197,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/product": {
-            "get": {
-                "description": "get products",
+        "/checkout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Buy several products at once for current Buyer user, the whole cart is bought or nothing, with the change paid once",
                 "consumes": [
                     "application/json"
                 ],
@@ -169,26 +212,31 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Product"
+                    "Vending Machine"
                 ],
-                "summary": "List products",
+                "summary": "Checkout cart",
                 "parameters": [
+                    {
+                        "description": "Cart items",
+                        "name": "cart",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.CheckoutRequest"
+                        }
+                    },
                     {
                         "type": "string",
-                        "format": "email",
-                        "description": "name search by q",
-                        "name": "q",
-                        "in": "query"
+                        "description": "Key to retry the request safely, the stored response is returned for a repeated key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.Product"
-                            }
+                            "$ref": "#/definitions/model.CheckoutResponse"
                         }
                     },
                     "400": {
@@ -197,12 +245,30 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -210,14 +276,16 @@ const docTemplate_swagger = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/deposit": {
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Add new product",
+                "description": "Deposit a coin of given value for current Buyer user, the accepted coin values depend on the machine currency",
                 "consumes": [
                     "application/json"
                 ],
@@ -225,25 +293,28 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Product"
+                    "Vending Machine"
                 ],
-                "summary": "Add product",
+                "summary": "Deposit money",
                 "parameters": [
                     {
-                        "description": "Add product request",
-                        "name": "product",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.AddProductReq"
-                        }
+                        "type": "integer",
+                        "description": "Coin value, in the smallest currency unit",
+                        "name": "coinValue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to retry the request safely, the stored response is returned for a repeated key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.Product"
+                            "$ref": "#/definitions/model.DepositResponse"
                         }
                     },
                     "400": {
@@ -264,6 +335,18 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -273,9 +356,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/product/{id}": {
+        "/earnings": {
             "get": {
-                "description": "get string by ID",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Show the balance and the ledger entries of current Seller user, needs the earnings:read:own permission. With earnings:read:any the earnings of any seller can be seen.",
                 "consumes": [
                     "application/json"
                 ],
@@ -283,23 +371,22 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Product"
+                    "Earnings"
                 ],
-                "summary": "Show a product",
+                "summary": "Show earnings",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Product ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Seller ID, with earnings:read:any",
+                        "name": "sellerId",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.Product"
+                            "$ref": "#/definitions/model.EarningsResponse"
                         }
                     },
                     "400": {
@@ -308,8 +395,14 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -321,14 +414,16 @@ const docTemplate_swagger = `{
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/lockouts": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by product ID",
+                "description": "List the recent failed logins by account and by client IP, the latest first, with the lockouts they caused, needs the lockout:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -336,29 +431,17 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Product"
-                ],
-                "summary": "Delete a product",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Product ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "Lockouts"
                 ],
+                "summary": "List login lockouts",
                 "responses": {
-                    "204": {
-                        "description": "Ok",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/httputil.HTTPError"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.LoginLockout"
+                            }
                         }
                     },
                     "401": {
@@ -367,8 +450,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -381,13 +464,13 @@ const docTemplate_swagger = `{
                     }
                 }
             },
-            "patch": {
+            "delete": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update by json product",
+                "description": "Forget the failed logins of an account or a client IP, which lifts its lockout, needs the lockout:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -395,25 +478,30 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Product"
+                    "Lockouts"
                 ],
-                "summary": "Update a product",
+                "summary": "Clear a login lockout",
                 "parameters": [
                     {
-                        "description": "Update product info",
-                        "name": "product",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.Product"
-                        }
+                        "type": "string",
+                        "description": "user or ip",
+                        "name": "kind",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User name or client IP",
+                        "name": "subject",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "Ok",
                         "schema": {
-                            "$ref": "#/definitions/model.UpdateProductRequest"
+                            "type": "string"
                         }
                     },
                     "400": {
@@ -428,6 +516,12 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -443,14 +537,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/reset": {
-            "post": {
+        "/machine/coins": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Reset current user deposit",
+                "description": "List the coin tubes of the machine, needs the machine:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -458,14 +552,17 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Vending Machine"
+                    "Machine"
                 ],
-                "summary": "Reset deposit",
+                "summary": "List coins",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.BuyResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CoinTube"
+                            }
                         }
                     },
                     "400": {
@@ -480,8 +577,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -493,11 +590,14 @@ const docTemplate_swagger = `{
                         }
                     }
                 }
-            }
-        },
-        "/tools/ping": {
-            "put": {
-                "description": "pings",
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Add coins to the tubes of the machine, needs the machine:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -505,44 +605,18 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Tools"
+                    "Machine"
                 ],
-                "summary": "Ping",
-                "responses": {
-                    "200": {
-                        "description": "Pong",
-                        "schema": {
-                            "type": "string"
-                        }
-                    }
-                }
-            }
-        },
-        "/user": {
-            "get": {
-                "security": [
-                    {
-                        "ApiKeyAuth": []
-                    }
-                ],
-                "description": "get users",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "User"
-                ],
-                "summary": "List users",
+                "summary": "Load coins",
                 "parameters": [
                     {
-                        "type": "string",
-                        "format": "email",
-                        "description": "name search by q",
-                        "name": "q",
-                        "in": "query"
+                        "description": "Coins to load",
+                        "name": "coins",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.LoadCoinsRequest"
+                        }
                     }
                 ],
                 "responses": {
@@ -551,7 +625,7 @@ const docTemplate_swagger = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/model.User"
+                                "$ref": "#/definitions/model.CoinTube"
                             }
                         }
                     },
@@ -567,8 +641,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -581,8 +655,13 @@ const docTemplate_swagger = `{
                     }
                 }
             },
-            "post": {
-                "description": "Add new user",
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Empty the tube of given coin value, or all tubes, needs the machine:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -590,25 +669,25 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Machine"
                 ],
-                "summary": "Add an user",
+                "summary": "Empty coins",
                 "parameters": [
                     {
-                        "description": "Add user request",
-                        "name": "user",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.AddUserReq"
-                        }
+                        "type": "integer",
+                        "description": "Coin value, all tubes if omitted",
+                        "name": "coinValue",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.User"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CoinTube"
+                            }
                         }
                     },
                     "400": {
@@ -617,8 +696,14 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -632,9 +717,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/user/login": {
-            "post": {
-                "description": "Logs user in",
+        "/machine/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the events changing the deposits, the stock and the coins, the oldest first, needs the machine:manage permission. The log starts with a snapshot of the machine.",
                 "consumes": [
                     "application/json"
                 ],
@@ -642,25 +732,31 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Machine"
                 ],
-                "summary": "Login",
+                "summary": "List events",
                 "parameters": [
                     {
-                        "description": "Login Request",
-                        "name": "credentials",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.LoginRequest"
-                        }
+                        "type": "string",
+                        "description": "Start of the time range, inclusive, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the time range, exclusive, RFC 3339",
+                        "name": "to",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Event"
+                            }
                         }
                     },
                     "400": {
@@ -669,14 +765,14 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "409": {
-                        "description": "Conflict",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -690,14 +786,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/user/logout": {
-            "post": {
+        "/machine/reconcile": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Logs user out",
+                "description": "Compare the deposits, the stock and the coins replayed from the events with the stored ones and list the differences, needs the machine:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -705,20 +801,17 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Machine"
                 ],
-                "summary": "Logout",
+                "summary": "Reconcile",
                 "responses": {
-                    "204": {
-                        "description": "Ok",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/httputil.HTTPError"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Discrepancy"
+                            }
                         }
                     },
                     "401": {
@@ -727,8 +820,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -742,9 +835,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/user/logout/all": {
-            "post": {
-                "description": "Logs current user ouy of all sessions",
+        "/machine/state": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Show the deposits, the stock and the coins replayed from the events up to the given time, needs the machine:manage permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -752,25 +850,22 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Machine"
                 ],
-                "summary": "Log out all user's sessions",
+                "summary": "Show machine state",
                 "parameters": [
                     {
-                        "description": "Login Request",
-                        "name": "credentials",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.LoginRequest"
-                        }
+                        "type": "string",
+                        "description": "Point in time, RFC 3339, now if omitted",
+                        "name": "at",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Ok",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/model.MachineState"
                         }
                     },
                     "400": {
@@ -779,14 +874,14 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "409": {
-                        "description": "Conflict",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -800,14 +895,14 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/user/{id}": {
+        "/payouts": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "get string by ID",
+                "description": "List the payouts of current Seller user, the newest first, needs the earnings:read:own permission. With earnings:read:any the payouts of any seller, or of all sellers, can be listed.",
                 "consumes": [
                     "application/json"
                 ],
@@ -815,23 +910,25 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Earnings"
                 ],
-                "summary": "Show an user",
+                "summary": "List payouts",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "User ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Seller ID, with earnings:read:any",
+                        "name": "sellerId",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.User"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Payout"
+                            }
                         }
                     },
                     "400": {
@@ -846,8 +943,8 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
@@ -860,13 +957,13 @@ const docTemplate_swagger = `{
                     }
                 }
             },
-            "delete": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by user ID",
+                "description": "Settle the balance of given seller, or of all sellers with a positive balance, and record the payouts, needs the payout:write permission",
                 "consumes": [
                     "application/json"
                 ],
@@ -874,23 +971,28 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Earnings"
                 ],
-                "summary": "Delete an user",
+                "summary": "Pay out sellers",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "User ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Seller to settle",
+                        "name": "payout",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.PayoutRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Ok",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Payout"
+                            }
                         }
                     },
                     "400": {
@@ -905,6 +1007,59 @@ const docTemplate_swagger = `{
                             "$ref": "#/definitions/httputil.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/product": {
+            "get": {
+                "description": "get products",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "List products",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "email",
+                        "description": "name search by q",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Product"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -919,13 +1074,13 @@ const docTemplate_swagger = `{
                     }
                 }
             },
-            "patch": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update by json user",
+                "description": "Add new product",
                 "consumes": [
                     "application/json"
                 ],
@@ -933,17 +1088,17 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Product"
                 ],
-                "summary": "Update an user",
+                "summary": "Add product",
                 "parameters": [
                     {
-                        "description": "Update user info",
-                        "name": "user",
+                        "description": "Add product request",
+                        "name": "product",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/model.UpdateUserRequest"
+                            "$ref": "#/definitions/model.AddProductReq"
                         }
                     }
                 ],
@@ -951,7 +1106,7 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.UpdateUserRequest"
+                            "$ref": "#/definitions/model.Product"
                         }
                     },
                     "400": {
@@ -980,26 +1135,2508 @@ const docTemplate_swagger = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "httputil.HTTPError": {
+        },
+        "/product/{id}": {
+            "get": {
+                "description": "get string by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Show a product",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by product ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Delete a product",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json product",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Update a product",
+                "parameters": [
+                    {
+                        "description": "Update product info",
+                        "name": "product",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.Product"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.UpdateProductRequest"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/purchases": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the orders of current user, the newest first. With the purchase:read:any permission the orders of any buyer can be listed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Purchases"
+                ],
+                "summary": "List purchases",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Buyer ID, with purchase:read:any",
+                        "name": "buyerId",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Order"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/purchases/{id}/receipt": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Render the receipt of an order as plain text or JSON, for its buyer or with the purchase:read:any permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain",
+                    "application/json"
+                ],
+                "tags": [
+                    "Purchases"
+                ],
+                "summary": "Show receipt",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Receipt format, text (default) or json",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Receipt"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/purchases/{id}/refund": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Show the refund of an order, for its buyer or with the purchase:read:any permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Purchases"
+                ],
+                "summary": "Show purchase refund",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.OrderRefund"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Reverse a whole order, e.g. when the machine failed to dispense: the stock is restored, the total is returned to the buyer as deposit or as coins and the sellers are debited, needs the purchase:refund permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Purchases"
+                ],
+                "summary": "Refund purchase",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Refund method and reason",
+                        "name": "refund",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RefundOrderRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.OrderRefund"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reset": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Reset current user deposit, returning it as change or, if the machine refund policy requires it, as the inserted coins",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Vending Machine"
+                ],
+                "summary": "Reset deposit",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key to retry the request safely, the stored response is returned for a repeated key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ResetResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the roles with their permissions, sorted by name, needs the role:manage permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Roles"
+                ],
+                "summary": "List roles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Role"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles/permissions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the permissions roles can grant, needs the role:manage permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Roles"
+                ],
+                "summary": "List permissions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles/{name}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Add a role or replace the permissions of one, which applies to the next requests of its users. Needs the role:manage permission, which cannot be taken from the last users having it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Roles"
+                ],
+                "summary": "Save a role",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Role name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Permissions",
+                        "name": "role",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RoleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Role"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete a role no user holds, it is also no longer one requiring a second factor. Needs the role:manage permission.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Roles"
+                ],
+                "summary": "Delete a role",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Role name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/tools/ping": {
+            "put": {
+                "description": "pings",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tools"
+                ],
+                "summary": "Ping",
+                "responses": {
+                    "200": {
+                        "description": "Pong",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/totp/required-roles": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Show the roles whose users must use a second factor, needs the totp:manage permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Show roles requiring a second factor",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpRequiredRolesRequest"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Set the roles whose users must use a second factor, needs the totp:manage permission. Their users without one can only enroll it until they do.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Require a second factor for roles",
+                "parameters": [
+                    {
+                        "description": "Roles",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpRequiredRolesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpRequiredRolesRequest"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get users",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "email",
+                        "description": "name search by q",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.User"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Add new user with one or more of the roles users can register with",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Add an user",
+                "parameters": [
+                    {
+                        "description": "Add user request",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.AddUserReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/login": {
+            "post": {
+                "description": "Logs user in. A user with a second factor gets a challenge, completed at /user/login/totp.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Login",
+                "parameters": [
+                    {
+                        "description": "Login Request",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/model.LoginChallengeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/login/totp": {
+            "post": {
+                "description": "Completes the login of a user with a second factor, with the challenge token and a one-time password or a recovery code",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Login with a second factor",
+                "parameters": [
+                    {
+                        "description": "Login Totp Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.LoginTotpRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.LoginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Logs user out of the current session",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Logout",
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/logout/all": {
+            "post": {
+                "description": "Logs current user ouy of all sessions, a user with a second factor gives a one-time password or a recovery code too",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Log out all user's sessions",
+                "parameters": [
+                    {
+                        "description": "Logout All Request",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.LogoutAllRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the unexpired sessions of the current user, the newest first, the session of the request is marked as current",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List sessions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Session"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "End a session of the current user, e.g. on a lost phone, its tokens are refused from then on",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Revoke a session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/token/refresh": {
+            "post": {
+                "description": "Exchange a refresh token for a new access token and a new refresh token of the same session. A refresh token works once, using it again revokes the session.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Refresh tokens",
+                "parameters": [
+                    {
+                        "description": "Refresh Token Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RefreshTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.LoginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/totp": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Starts the enrollment of a time-based one-time password second factor of the current user. The secret is added to an authenticator app, e.g. by scanning the provisioning URI as a QR code, and the enrollment is completed at /user/totp/confirm. An unconfirmed enrollment is replaced.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Enroll a second factor",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpEnrollmentResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/totp/confirm": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Completes the enrollment of the second factor of the current user with a one-time password from the authenticator app. The recovery codes are returned once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Confirm a second factor",
+                "parameters": [
+                    {
+                        "description": "One-time password",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecoveryCodesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/totp/disable": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Removes the second factor of the current user, proven with a one-time password or a recovery code. A role requiring a second factor makes the user enroll a new one.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Disable a second factor",
+                "parameters": [
+                    {
+                        "description": "One-time password or recovery code",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.TotpCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get string by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Show an user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by user ID, the last users allowed to manage the roles cannot be deleted",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Delete an user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Update an user",
+                "parameters": [
+                    {
+                        "description": "Update user info",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UpdateUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.UpdateUserRequest"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/roles": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Replace the roles of a user, which applies to the next requests of the user. Needs the role:manage permission, which cannot be taken from the last users having it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Roles"
+                ],
+                "summary": "Set the roles of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Roles",
+                        "name": "roles",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UserRolesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/totp": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Removes the second factor of a user who lost it and their recovery codes, needs the totp:manage permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Reset a second factor",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/httputil.HTTPError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "httputil.HTTPError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer",
+                    "example": 400
+                },
+                "details": {
+                    "description": "Details are given by some errors, e.g. the violated rules of a validation error",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "status bad request"
+                }
+            }
+        },
+        "model.AddProductReq": {
+            "type": "object",
+            "properties": {
+                "amountAvailable": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "cost": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "productName": {
+                    "type": "string",
+                    "example": "product_name"
+                }
+            }
+        },
+        "model.AddUserReq": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "role": {
+                    "description": "Role is a single role, Roles several ones, both can be given",
+                    "type": "string",
+                    "example": "buyer"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "seller"
+                    ]
+                },
+                "userName": {
+                    "type": "string",
+                    "example": "user_name"
+                }
+            }
+        },
+        "model.AuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "vending.deposit"
+                },
+                "actorId": {
+                    "description": "ActorId and ActorRole identify the user who made the change, as they were at that time. A user with several\nroles has them separated by commas.",
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "actorRole": {
+                    "type": "string",
+                    "example": "buyer"
+                },
+                "after": {
+                    "type": "object"
+                },
+                "before": {
+                    "description": "Before and After are JSON snapshots of the entity, secrets such as password hashes are left out",
+                    "type": "object"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "entityId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "entityType": {
+                    "type": "string",
+                    "example": "user"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.BuyResponse": {
+            "type": "object",
+            "properties": {
+                "change": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Coin"
+                    }
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "orderId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "productName": {
+                    "type": "string",
+                    "example": "product_name"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.CartItem": {
+            "type": "object",
+            "properties": {
+                "amountOfProducts": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "productId": {
+                    "type": "string",
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.CheckoutRequest": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CartItem"
+                    }
+                }
+            }
+        },
+        "model.CheckoutResponse": {
+            "type": "object",
+            "properties": {
+                "change": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Coin"
+                    }
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CheckoutResponseLine"
+                    }
+                },
+                "orderId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.CheckoutResponseLine": {
+            "type": "object",
+            "properties": {
+                "amountOfProducts": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "cost": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "productId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "productName": {
+                    "type": "string",
+                    "example": "product_name"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.Coin": {
+            "type": "object",
+            "properties": {
+                "value": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.CoinTube": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "value": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.DepositResponse": {
+            "type": "object",
+            "properties": {
+                "coins": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CoinTube"
+                    }
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "deposit": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.Discrepancy": {
+            "type": "object",
+            "properties": {
+                "actual": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "expected": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "id": {
+                    "description": "Id is the user, the product or the coin value",
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "stock"
+                }
+            }
+        },
+        "model.EarningsResponse": {
+            "type": "object",
+            "properties": {
+                "balance": {
+                    "description": "Balance is the amount owed to the seller, sales minus refunds and payouts",
+                    "type": "integer",
+                    "example": 5
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.LedgerEntry"
+                    }
+                },
+                "sellerId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "totalPayouts": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "totalRefunds": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "totalSales": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "model.Event": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "object"
+                },
+                "seq": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "type": {
+                    "type": "string",
+                    "example": "deposit"
+                }
+            }
+        },
+        "model.LedgerEntry": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "creditAccount": {
+                    "type": "string",
+                    "example": "seller:xxx"
+                },
+                "debitAccount": {
+                    "type": "string",
+                    "example": "machine"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "sale"
+                },
+                "orderId": {
+                    "description": "OrderId and ProductId link a sale to the order line",
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "payoutId": {
+                    "description": "PayoutId links a payout entry to its settlement",
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "productId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "refundId": {
+                    "description": "RefundId links a refund entry to the order refund",
+                    "type": "string",
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.LoadCoinsRequest": {
+            "type": "object",
+            "properties": {
+                "coins": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CoinTube"
+                    }
+                }
+            }
+        },
+        "model.LoginChallengeResponse": {
+            "type": "object",
+            "properties": {
+                "challengeExpires": {
+                    "description": "ChallengeExpires is in milliseconds",
+                    "type": "integer"
+                },
+                "challengeToken": {
+                    "type": "string"
+                },
+                "totpRequired": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "model.LoginLockout": {
+            "type": "object",
+            "properties": {
+                "failures": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "user"
+                },
+                "lastFailureAt": {
+                    "type": "string"
+                },
+                "lockedUntil": {
+                    "description": "LockedUntil is zero if there was no lockout",
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "User #3, Buyer"
+                }
+            }
+        },
+        "model.LoginRequest": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "description": "Device labels the session, e.g. machine or phone",
+                    "type": "string",
+                    "example": "phone"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "userName": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.LoginResponse": {
+            "type": "object",
+            "properties": {
+                "refreshToken": {
+                    "description": "RefreshToken is exchanged once for new tokens of the session until RefreshTokenExpires, in milliseconds",
+                    "type": "string"
+                },
+                "refreshTokenExpires": {
+                    "type": "integer"
+                },
+                "sessionId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "token": {
+                    "description": "Token is the short-lived access token, TokenExpires is its expiry in milliseconds",
+                    "type": "string"
+                },
+                "tokenExpires": {
+                    "type": "integer"
+                },
+                "totpEnrollmentRequired": {
+                    "description": "TotpEnrollmentRequired tells that the role of the user requires a second factor, which is to be enrolled\nbefore anything else",
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.LoginTotpRequest": {
+            "type": "object",
+            "properties": {
+                "challengeToken": {
+                    "description": "ChallengeToken is given by the first step",
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "123456"
+                },
+                "recoveryCode": {
+                    "type": "string",
+                    "example": "abcde-fghij"
+                }
+            }
+        },
+        "model.LogoutAllRequest": {
             "type": "object",
             "properties": {
                 "code": {
+                    "type": "string",
+                    "example": "123456"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "recoveryCode": {
+                    "type": "string",
+                    "example": "abcde-fghij"
+                },
+                "userName": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.MachineState": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "coins": {
+                    "description": "Coins are the coin counts by value",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "deposits": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "seq": {
+                    "description": "Seq is the last applied event, At its time",
                     "type": "integer",
-                    "example": 400
+                    "example": 1
+                },
+                "stock": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "model.Order": {
+            "type": "object",
+            "properties": {
+                "buyerId": {
+                    "type": "string",
+                    "example": "xxx"
                 },
-                "message": {
+                "change": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Coin"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
                     "type": "string",
-                    "example": "status bad request"
+                    "example": "xxx"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.OrderLine"
+                    }
+                },
+                "refundId": {
+                    "description": "RefundId links to the refund of the order, if any",
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 5
                 }
             }
         },
-        "model.AddProductReq": {
+        "model.OrderLine": {
             "type": "object",
             "properties": {
-                "amountAvailable": {
+                "amountOfProducts": {
                     "type": "integer",
                     "example": 1
                 },
@@ -1007,39 +3644,162 @@ const docTemplate_swagger = `{
                     "type": "integer",
                     "example": 5
                 },
+                "productId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
                 "productName": {
                     "type": "string",
                     "example": "product_name"
+                },
+                "sellerId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 5
                 }
             }
         },
-        "model.AddUserReq": {
+        "model.OrderRefund": {
             "type": "object",
             "properties": {
-                "password": {
+                "amount": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "buyerId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "coins": {
+                    "description": "Coins are paid out with the coins method",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Coin"
+                    }
+                },
+                "createdAt": {
                     "type": "string"
                 },
-                "role": {
+                "id": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "method": {
+                    "type": "string",
+                    "example": "deposit"
+                },
+                "orderId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "not dispensed"
+                },
+                "refundedBy": {
+                    "type": "string",
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.Payout": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "createdAt": {
                     "type": "string"
                 },
-                "userName": {
+                "id": {
                     "type": "string",
-                    "example": "user_name"
+                    "example": "xxx"
+                },
+                "paidBy": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "sellerId": {
+                    "type": "string",
+                    "example": "xxx"
                 }
             }
         },
-        "model.BuyResponse": {
+        "model.PayoutRequest": {
+            "type": "object",
+            "properties": {
+                "sellerId": {
+                    "description": "SellerId selects the seller to settle, all sellers are settled if it is empty",
+                    "type": "string",
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.Product": {
+            "type": "object",
+            "properties": {
+                "amountAvailable": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "cost": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "id": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "productName": {
+                    "type": "string",
+                    "example": "product_name"
+                },
+                "sellerId": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.Receipt": {
             "type": "object",
             "properties": {
+                "buyerId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
                 "change": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/model.Coin"
                     }
                 },
-                "productName": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "currency": {
                     "type": "string",
-                    "example": "product_name"
+                    "example": "EUR"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.OrderLine"
+                    }
+                },
+                "orderId": {
+                    "type": "string",
+                    "example": "xxx"
+                },
+                "paid": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "refundId": {
+                    "type": "string",
+                    "example": "xxx"
                 },
                 "total": {
                     "type": "integer",
@@ -1047,56 +3807,168 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "model.Coin": {
+        "model.RecoveryCodesResponse": {
             "type": "object",
             "properties": {
-                "value": {
-                    "type": "integer",
-                    "example": 5
+                "recoveryCodes": {
+                    "description": "RecoveryCodes are shown once, each one replaces a one-time password once",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "abcde-fghij"
+                    ]
                 }
             }
         },
-        "model.DepositResponse": {
+        "model.RefreshTokenRequest": {
             "type": "object",
             "properties": {
-                "deposit": {
-                    "type": "integer",
-                    "example": 5
+                "refreshToken": {
+                    "type": "string"
                 }
             }
         },
-        "model.LoginRequest": {
+        "model.RefundOrderRequest": {
             "type": "object",
             "properties": {
-                "password": {
-                    "type": "string"
+                "method": {
+                    "description": "Method is \"deposit\" or \"coins\"",
+                    "type": "string",
+                    "example": "deposit"
                 },
-                "userName": {
-                    "type": "string"
+                "reason": {
+                    "type": "string",
+                    "example": "not dispensed"
                 }
             }
         },
-        "model.Product": {
+        "model.ResetResponse": {
             "type": "object",
             "properties": {
-                "amountAvailable": {
-                    "type": "integer",
-                    "example": 1
+                "change": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Coin"
+                    }
                 },
-                "cost": {
-                    "type": "integer",
-                    "example": 5
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                }
+            }
+        },
+        "model.Role": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "seller"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "product:write:own"
+                    ]
+                },
+                "registrable": {
+                    "description": "Registrable roles can be chosen by new users at sign-up",
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.RoleRequest": {
+            "type": "object",
+            "properties": {
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "product:write:own"
+                    ]
+                },
+                "registrable": {
+                    "description": "Registrable roles can be chosen by new users at sign-up",
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.Session": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "current": {
+                    "description": "Current tells if the session is the one of the request, it is not stored",
+                    "type": "boolean"
+                },
+                "device": {
+                    "description": "Device is a label given at login",
+                    "type": "string",
+                    "example": "phone"
+                },
+                "expiresAt": {
+                    "type": "string"
                 },
                 "id": {
+                    "description": "ID is the jti claim of the tokens of the session",
                     "type": "string",
                     "example": "xxx"
                 },
-                "productName": {
+                "lastSeenAt": {
+                    "type": "string"
+                },
+                "userId": {
                     "type": "string",
-                    "example": "product_name"
+                    "example": "xxx"
+                }
+            }
+        },
+        "model.TotpCodeRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "123456"
                 },
-                "sellerId": {
-                    "type": "string"
+                "recoveryCode": {
+                    "type": "string",
+                    "example": "abcde-fghij"
+                }
+            }
+        },
+        "model.TotpEnrollmentResponse": {
+            "type": "object",
+            "properties": {
+                "provisioningUri": {
+                    "type": "string",
+                    "example": "otpauth://totp/MVP%20Match:user_name?algorithm=SHA1\u0026digits=6\u0026issuer=MVP+Match\u0026period=30\u0026secret=JBSWY3DPEHPK3PXP"
+                },
+                "secret": {
+                    "description": "Secret is for typing into an authenticator app, ProvisioningUri for showing as a QR code to scan",
+                    "type": "string",
+                    "example": "JBSWY3DPEHPK3PXP"
+                }
+            }
+        },
+        "model.TotpRequiredRolesRequest": {
+            "type": "object",
+            "properties": {
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "admin"
+                    ]
                 }
             }
         },
@@ -1140,27 +4012,46 @@ const docTemplate_swagger = `{
                     "type": "integer",
                     "example": 5
                 },
+                "depositCoins": {
+                    "description": "DepositCoins are the coins inserted by the user, held apart from the machine tubes until a purchase or refund",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CoinTube"
+                    }
+                },
                 "id": {
                     "type": "string",
                     "example": "xxx"
                 },
-                "passwordHash": {
-                    "type": "string"
-                },
-                "role": {
-                    "type": "string"
-                },
-                "token": {
-                    "type": "string"
-                },
-                "tokenExpires": {
-                    "type": "integer"
+                "roles": {
+                    "description": "Roles are the names of the roles of the user, whose permissions the user has",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "buyer"
+                    ]
                 },
                 "userName": {
                     "type": "string",
                     "example": "user_name"
                 }
             }
+        },
+        "model.UserRolesRequest": {
+            "type": "object",
+            "properties": {
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "seller"
+                    ]
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -1,16 +1,32 @@
 package httputil
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
 
 func NewError(ctx *gin.Context, status int, err error) {
 	er := HTTPError{
 		Code:    status,
 		Message: err.Error(),
 	}
+	var detailed detailedError
+	if errors.As(err, &detailed) {
+		er.Details = detailed.ErrorDetails()
+	}
 	ctx.JSON(status, er)
 }
 
 type HTTPError struct {
 	Code    int    `json:"code" example:"400"`
 	Message string `json:"message" example:"status bad request"`
+	// Details are given by some errors, e.g. the violated rules of a validation error
+	Details interface{} `json:"details,omitempty" swaggertype:"array,object"`
+}
+
+// detailedError is an error with details for the response
+type detailedError interface {
+	error
+	ErrorDetails() interface{}
 }
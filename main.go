@@ -1,8 +1,11 @@
 package main
 
 import (
+	"github.com/oltur/mvp-match/config"
 	"github.com/oltur/mvp-match/controller"
 	_ "github.com/oltur/mvp-match/docs"
+	"github.com/oltur/mvp-match/model"
+	"log"
 )
 
 // @title           MVP Match test task
@@ -27,6 +30,53 @@ import (
 // @in                          header
 // @name                        Authorization
 func main() {
-	r, _ := controller.SetupRouter()
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.JwtKeys == "" && cfg.JwtKeysFile == "" && cfg.JwtPemKeys == "" {
+		log.Print("no JWT keys configured, tokens are signed with a random key and expire on restart")
+	}
+
+	var store model.Store
+	switch cfg.Storage {
+	case config.StorageMemory:
+		store = model.NewMemoryStore()
+	case config.StorageSqlite:
+		sqlStore, err := model.NewSqlStore(cfg.SqlitePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sqlStore.Close()
+		store = sqlStore
+	default:
+		log.Fatalf("unsupported storage %q", cfg.Storage)
+	}
+
+	if cfg.Seed {
+		// seeded before the controller replays the event log into a memory store, which restores its deposits, stock
+		// and coins
+		err = seed(store, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := controller.SetupRouter(c)
 	r.Run(":8081")
 }
+
+func seed(store model.Store, cfg *config.Config) (err error) {
+	currency, err := cfg.Currency()
+	if err != nil {
+		return
+	}
+	hasher, err := model.PasswordHasherByName(cfg.PasswordHash, cfg.PasswordHashCost)
+	if err != nil {
+		return
+	}
+	return model.SeedIfEmpty(store, currency, hasher)
+}
@@ -6,8 +6,8 @@ type AddProductReq struct {
 	Cost            int    `json:"cost" example:"5"`
 }
 
-func (a AddProductReq) Validation() (err error) {
-	if a.Cost%5 != 0 {
+func (a AddProductReq) Validation(currency *Currency) (err error) {
+	if !currency.IsValidCost(a.Cost) {
 		err = ErrInvalidCost
 		return
 	}
@@ -1,29 +1,51 @@
 package model
 
+import "github.com/oltur/mvp-match/tools"
+
 type AddUserReq struct {
 	UserName string `json:"userName" example:"user_name"`
 	Password string `json:"password"`
-	Role     string `json:"role"`
+	// Role is a single role, Roles several ones, both can be given
+	Role  string   `json:"role" example:"buyer"`
+	Roles []string `json:"roles" example:"seller"`
+}
+
+// AllRoles returns the role and the roles without duplicates
+func (a AddUserReq) AllRoles() []string {
+	return tools.UniqueStrings(append([]string{a.Role}, a.Roles...))
 }
 
-func (a AddUserReq) Validation() (err error) {
+// Validation checks the request against the roles and the password against the policy, the error lists every
+// violated rule. Only registrable roles can be chosen.
+func (a AddUserReq) Validation(policy *PasswordPolicy, roles []*Role) (err error) {
+	res := &ValidationError{}
 	if a.UserName == "" {
-		err = ErrInvalidUserName
-		return
+		res.add("userName", ValidationRuleRequired, ErrInvalidUserName)
 	}
 	if a.Password == "" {
-		err = ErrInvalidPassword
-		return
+		res.add("password", ValidationRuleRequired, ErrInvalidPassword)
+	} else {
+		policy.check(a.Password, a.UserName, res)
+	}
+	if len(a.AllRoles()) == 0 {
+		res.add("role", ValidationRuleRequired, ErrInvalidUserRole)
 	}
-	if _, ok := allowedUserRoles[a.Role]; !ok {
-		err = ErrInvalidUserRole
-		return
+	if a.Role != "" {
+		validateRegistrableRole("role", a.Role, roles, res)
 	}
-	// TODO: Add separate API?
-	if a.Role == UserRoleAdmin {
-		err = ErrCannotCreateAdmin
-		return
+	for _, name := range a.Roles {
+		validateRegistrableRole("roles", name, roles, res)
 	}
 
-	return
+	return res.errorOrNil()
+}
+
+// validateRegistrableRole adds a violation of the field if the name is not a role users can register with
+func validateRegistrableRole(field string, name string, roles []*Role, res *ValidationError) {
+	role := FindRole(roles, name)
+	if role == nil {
+		res.add(field, ValidationRuleAllowed, ErrInvalidUserRole)
+	} else if !role.Registrable {
+		res.add(field, ValidationRuleAllowed, ErrRoleNotRegistrable)
+	}
 }
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+const (
+	AuditEntityUser     = "user"
+	AuditEntityProduct  = "product"
+	AuditEntityOrder    = "order"
+	AuditEntityCoins    = "coins"
+	AuditEntityPayout   = "payout"
+	AuditEntitySession  = "session"
+	AuditEntityLockout  = "lockout"
+	AuditEntitySettings = "settings"
+	AuditEntityRole     = "role"
+)
+
+const (
+	AuditActionUserAdd       = "user.add"
+	AuditActionUserUpdate    = "user.update"
+	AuditActionUserDelete    = "user.delete"
+	AuditActionLogin         = "user.login"
+	AuditActionLogout        = "user.logout"
+	AuditActionLogoutAll     = "user.logout.all"
+	AuditActionSessionRevoke = "user.session.revoke"
+	AuditActionTokenReuse    = "user.token.reuse"
+	AuditActionLockoutClear  = "lockout.clear"
+	AuditActionTotpEnroll    = "user.totp.enroll"
+	AuditActionTotpDisable   = "user.totp.disable"
+	AuditActionTotpReset     = "user.totp.reset"
+	AuditActionTotpRoles     = "settings.totp.roles"
+	AuditActionUserRoles     = "user.roles"
+	AuditActionRoleSave      = "role.save"
+	AuditActionRoleDelete    = "role.delete"
+	AuditActionProductAdd    = "product.add"
+	AuditActionProductUpdate = "product.update"
+	AuditActionProductDelete = "product.delete"
+	AuditActionDeposit       = "vending.deposit"
+	AuditActionBuy           = "vending.buy"
+	AuditActionCheckout      = "vending.checkout"
+	AuditActionReset         = "vending.reset"
+	AuditActionCoinsLoad     = "coins.load"
+	AuditActionCoinsEmpty    = "coins.empty"
+	AuditActionPayout        = "ledger.payout"
+	AuditActionOrderRefund   = "order.refund"
+)
+
+// AuditEntry records a state change, entries are append only
+type AuditEntry struct {
+	ID types.Id `json:"id" example:"xxx"`
+	// ActorId and ActorRole identify the user who made the change, as they were at that time. A user with several
+	// roles has them separated by commas.
+	ActorId    types.Id `json:"actorId" example:"xxx"`
+	ActorRole  string   `json:"actorRole" example:"buyer"`
+	Action     string   `json:"action" example:"vending.deposit"`
+	EntityType string   `json:"entityType" example:"user"`
+	EntityId   types.Id `json:"entityId" example:"xxx"`
+	// Before and After are JSON snapshots of the entity, secrets such as password hashes are left out
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// AuditQuery filters the audit log, empty fields match everything. From is inclusive and To exclusive.
+type AuditQuery struct {
+	ActorId    types.Id
+	EntityType string
+	EntityId   types.Id
+	From       time.Time
+	To         time.Time
+}
+
+func (a *AuditQuery) matches(entry *AuditEntry) bool {
+	if a.ActorId != "" && entry.ActorId != a.ActorId {
+		return false
+	}
+	if a.EntityType != "" && entry.EntityType != a.EntityType {
+		return false
+	}
+	if a.EntityId != "" && entry.EntityId != a.EntityId {
+		return false
+	}
+	if !a.From.IsZero() && entry.CreatedAt.Before(a.From) {
+		return false
+	}
+	if !a.To.IsZero() && !entry.CreatedAt.Before(a.To) {
+		return false
+	}
+	return true
+}
+
+func (a *AuditEntry) copy() *AuditEntry {
+	res := *a
+	res.Before = append(json.RawMessage(nil), a.Before...)
+	res.After = append(json.RawMessage(nil), a.After...)
+	return &res
+}
@@ -1,7 +1,11 @@
 package model
 
+import "github.com/oltur/mvp-match/types"
+
 type BuyResponse struct {
-	ProductName string  `json:"productName" example:"product_name"`
-	Change      []*Coin `json:"change"`
-	Total       int     `json:"total" example:"5"`
+	OrderId     types.Id `json:"orderId" example:"xxx"`
+	ProductName string   `json:"productName" example:"product_name"`
+	Change      []*Coin  `json:"change"`
+	Total       int      `json:"total" example:"5"`
+	Currency    string   `json:"currency" example:"EUR"`
 }
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	ChangeStrategyGreedy         = "greedy"
+	ChangeStrategyMinCoins       = "min-coins"
+	ChangeStrategyPreserveScarce = "preserve-scarce"
+)
+
+var ErrInvalidChangeStrategy = errors.New("unsupported change strategy")
+
+// ChangeStrategy decides which coins to pay the change with
+type ChangeStrategy interface {
+	Name() string
+	// MakeChange is a ChangeMaker, the coins are returned in descending value order
+	MakeChange(totalChange int, available map[int]int) (res []*Coin, err error)
+}
+
+// ChangeStrategyByName returns the strategy registered under the given name
+func ChangeStrategyByName(name string) (res ChangeStrategy, err error) {
+	switch name {
+	case ChangeStrategyGreedy:
+		res = GreedyChangeStrategy{}
+	case ChangeStrategyMinCoins:
+		res = MinCoinsChangeStrategy{}
+	case ChangeStrategyPreserveScarce:
+		res = PreserveScarceChangeStrategy{}
+	default:
+		err = ErrInvalidChangeStrategy
+	}
+	return
+}
+
+// GreedyChangeStrategy pays largest coins first. It is fast, but with a limited inventory it can miss a solution,
+// e.g. 60 with 50, 20, 20, 20 available.
+type GreedyChangeStrategy struct{}
+
+func (a GreedyChangeStrategy) Name() string {
+	return ChangeStrategyGreedy
+}
+
+func (a GreedyChangeStrategy) MakeChange(totalChange int, available map[int]int) (res []*Coin, err error) {
+	res = make([]*Coin, 0, 1)
+	for _, tube := range CoinTubesFromCounts(available) {
+		count := tube.Count
+		for totalChange >= tube.Value && count > 0 {
+			res = append(res, &Coin{Value: tube.Value})
+			totalChange = totalChange - tube.Value
+			count--
+		}
+	}
+	if totalChange != 0 {
+		err = ErrCannotMakeChange
+		return
+	}
+	return
+}
+
+// MinCoinsChangeStrategy pays with the smallest possible number of coins
+type MinCoinsChangeStrategy struct{}
+
+func (a MinCoinsChangeStrategy) Name() string {
+	return ChangeStrategyMinCoins
+}
+
+func (a MinCoinsChangeStrategy) MakeChange(totalChange int, available map[int]int) (res []*Coin, err error) {
+	return makeChangeWithCost(totalChange, available, func(value int, count int) float64 {
+		return 1
+	})
+}
+
+// PreserveScarceChangeStrategy prefers coins from well-filled tubes, so that the machine runs out of a coin value
+// as late as possible. Taking a coin from a tube of n coins costs 1/n, and the cheapest change is paid.
+type PreserveScarceChangeStrategy struct{}
+
+func (a PreserveScarceChangeStrategy) Name() string {
+	return ChangeStrategyPreserveScarce
+}
+
+func (a PreserveScarceChangeStrategy) MakeChange(totalChange int, available map[int]int) (res []*Coin, err error) {
+	return makeChangeWithCost(totalChange, available, PreserveScarceCoinCost)
+}
+
+// PreserveScarceCoinCost is the cost of taking one coin from a tube of count coins
+func PreserveScarceCoinCost(value int, count int) float64 {
+	return 1 / float64(count)
+}
+
+// makeChangeWithCost finds the change of the lowest total cost with a bounded knapsack over the available coins.
+// A tube is taken as bundles of 1, 2, 4... coins and the rest, at most the coins fitting into the change, so any
+// number of its coins is a choice of bundles and the work grows with the log of the coin counts. best[a] is the
+// cheapest way to pay a with the bundles seen so far, and take[i][a] remembers if it uses the i-th bundle.
+func makeChangeWithCost(totalChange int, available map[int]int, cost func(value int, count int) float64) (res []*Coin, err error) {
+	if totalChange < 0 {
+		err = ErrCannotMakeChange
+		return
+	}
+	tubes := CoinTubesFromCounts(available)
+
+	var bundles []changeBundle
+	for i, tube := range tubes {
+		count := tube.Count
+		if count > totalChange/tube.Value {
+			count = totalChange / tube.Value
+		}
+		coinCost := cost(tube.Value, tube.Count)
+		for size := 1; count > 0; size = size * 2 {
+			if size > count {
+				size = count
+			}
+			bundles = append(bundles, changeBundle{tube: i, count: size, cost: float64(size) * coinCost})
+			count = count - size
+		}
+	}
+
+	best := make([]float64, totalChange+1)
+	for a := 1; a <= totalChange; a++ {
+		best[a] = math.Inf(1)
+	}
+	take := make([][]bool, len(bundles))
+	for i, bundle := range bundles {
+		take[i] = make([]bool, totalChange+1)
+		amount := bundle.count * tubes[bundle.tube].Value
+		// downwards, so best[a-amount] does not use the bundle yet
+		for a := totalChange; a >= amount; a-- {
+			c := best[a-amount] + bundle.cost
+			if c < best[a] {
+				best[a] = c
+				take[i][a] = true
+			}
+		}
+	}
+	if math.IsInf(best[totalChange], 1) {
+		err = ErrCannotMakeChange
+		return
+	}
+
+	counts := make([]int, len(tubes))
+	for i, a := len(bundles)-1, totalChange; i >= 0; i-- {
+		if take[i][a] {
+			counts[bundles[i].tube] = counts[bundles[i].tube] + bundles[i].count
+			a = a - bundles[i].count*tubes[bundles[i].tube].Value
+		}
+	}
+	res = make([]*Coin, 0, 1)
+	for i, tube := range tubes {
+		for k := 0; k < counts[i]; k++ {
+			res = append(res, &Coin{Value: tube.Value})
+		}
+	}
+	return
+}
+
+// changeBundle is count coins of the tube-th tube taken together, at the cost of them all
+type changeBundle struct {
+	tube  int
+	count int
+	cost  float64
+}
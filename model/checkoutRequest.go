@@ -0,0 +1,9 @@
+package model
+
+type CheckoutRequest struct {
+	Items []*CartItem `json:"items"`
+}
+
+func (a CheckoutRequest) Validation() (err error) {
+	return validateCart(a.Items)
+}
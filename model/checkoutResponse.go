@@ -0,0 +1,19 @@
+package model
+
+import "github.com/oltur/mvp-match/types"
+
+type CheckoutResponseLine struct {
+	ProductId        types.Id `json:"productId" example:"xxx"`
+	ProductName      string   `json:"productName" example:"product_name"`
+	AmountOfProducts int      `json:"amountOfProducts" example:"1"`
+	Cost             int      `json:"cost" example:"5"`
+	Total            int      `json:"total" example:"5"`
+}
+
+type CheckoutResponse struct {
+	OrderId  types.Id                `json:"orderId" example:"xxx"`
+	Lines    []*CheckoutResponseLine `json:"lines"`
+	Change   []*Coin                 `json:"change"`
+	Total    int                     `json:"total" example:"5"`
+	Currency string                  `json:"currency" example:"EUR"`
+}
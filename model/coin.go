@@ -2,22 +2,102 @@ package model
 
 import (
 	"errors"
+	"sort"
 )
 
 var (
 	ErrInvalidCoinValue = errors.New("invalid coin value")
+	ErrInvalidCoinCount = errors.New("coin count should be positive")
+	ErrCannotMakeChange = errors.New("the machine cannot make change, please insert the exact amount")
 )
 
-var allowedCoinValues = map[int]*struct{}{5: nil, 10: nil, 20: nil, 50: nil, 100: nil}
-
 type Coin struct {
 	Value int `json:"value" example:"5"`
 }
 
-// Validation example
-func (a Coin) Validation() (err error) {
-	if _, ok := allowedCoinValues[a.Value]; !ok {
+// Validation checks the coin is accepted in the given currency
+func (a Coin) Validation(currency *Currency) (err error) {
+	if !currency.IsCoinValue(a.Value) {
 		return ErrInvalidCoinValue
 	}
 	return
 }
+
+// CoinTube holds the coins of one value in the machine
+type CoinTube struct {
+	Value int `json:"value" example:"5"`
+	Count int `json:"count" example:"10"`
+}
+
+func (a CoinTube) Validation(currency *Currency) (err error) {
+	err = Coin{Value: a.Value}.Validation(currency)
+	if err != nil {
+		return
+	}
+	if a.Count <= 0 {
+		err = ErrInvalidCoinCount
+		return
+	}
+	return
+}
+
+// CountCoins groups the coins by value
+func CountCoins(coins []*Coin) (res map[int]int) {
+	res = make(map[int]int)
+	for _, coin := range coins {
+		res[coin.Value]++
+	}
+	return
+}
+
+// CoinTubesFromCounts converts coin counts by value to tubes ordered by descending value, skipping empty ones
+func CoinTubesFromCounts(counts map[int]int) (res []*CoinTube) {
+	res = []*CoinTube{}
+	for value, count := range counts {
+		if count > 0 {
+			res = append(res, &CoinTube{Value: value, Count: count})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Value > res[j].Value })
+	return
+}
+
+// CoinCountsFromTubes converts tubes to coin counts by value
+func CoinCountsFromTubes(tubes []*CoinTube) (res map[int]int) {
+	res = make(map[int]int, len(tubes))
+	for _, tube := range tubes {
+		res[tube.Value] = res[tube.Value] + tube.Count
+	}
+	return
+}
+
+// CoinsFromCounts converts coin counts by value to coins in descending value order
+func CoinsFromCounts(counts map[int]int) (res []*Coin) {
+	res = make([]*Coin, 0, 1)
+	for _, tube := range CoinTubesFromCounts(counts) {
+		for i := 0; i < tube.Count; i++ {
+			res = append(res, &Coin{Value: tube.Value})
+		}
+	}
+	return
+}
+
+// SumCoinCounts returns the total value of the coins
+func SumCoinCounts(counts map[int]int) (res int) {
+	for value, count := range counts {
+		res = res + value*count
+	}
+	return
+}
+
+// AddCoinCounts returns the sum of two coin counts
+func AddCoinCounts(a map[int]int, b map[int]int) (res map[int]int) {
+	res = make(map[int]int, len(a)+len(b))
+	for value, count := range a {
+		res[value] = res[value] + count
+	}
+	for value, count := range b {
+		res[value] = res[value] + count
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrInvalidCurrency = errors.New("invalid currency: code is required, smallest unit and coin values should be positive and coin values multiples of the smallest unit")
+)
+
+// Currency describes the money accepted by the machine, all amounts are integers in the smallest currency unit
+type Currency struct {
+	Code string `json:"code" example:"EUR"`
+	// CoinValues are the accepted coins, in descending order
+	CoinValues []int `json:"coinValues" example:"100,50,20,10,5"`
+	// SmallestUnit is the step of product costs
+	SmallestUnit int `json:"smallestUnit" example:"5"`
+
+	coinValues map[int]*struct{}
+}
+
+// NewCurrency creates a validated currency
+func NewCurrency(code string, coinValues []int, smallestUnit int) (res *Currency, err error) {
+	if code == "" || smallestUnit <= 0 || len(coinValues) == 0 {
+		err = ErrInvalidCurrency
+		return
+	}
+	res = &Currency{
+		Code:         code,
+		CoinValues:   make([]int, 0, len(coinValues)),
+		SmallestUnit: smallestUnit,
+		coinValues:   make(map[int]*struct{}, len(coinValues)),
+	}
+	for _, value := range coinValues {
+		if value <= 0 || value%smallestUnit != 0 {
+			return nil, ErrInvalidCurrency
+		}
+		if _, ok := res.coinValues[value]; ok {
+			continue
+		}
+		res.coinValues[value] = nil
+		res.CoinValues = append(res.CoinValues, value)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(res.CoinValues)))
+	return
+}
+
+// IsCoinValue tells if a coin of the given value is accepted
+func (a *Currency) IsCoinValue(value int) bool {
+	_, ok := a.coinValues[value]
+	return ok
+}
+
+// IsValidCost tells if the cost is positive and a multiple of the smallest unit
+func (a *Currency) IsValidCost(cost int) bool {
+	return cost > 0 && cost%a.SmallestUnit == 0
+}
+
+// FilterCoins returns the coin counts of accepted coin values only
+func (a *Currency) FilterCoins(counts map[int]int) (res map[int]int) {
+	res = make(map[int]int, len(counts))
+	for value, count := range counts {
+		if a.IsCoinValue(value) {
+			res[value] = count
+		}
+	}
+	return
+}
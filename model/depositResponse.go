@@ -1,5 +1,7 @@
 package model
 
 type DepositResponse struct {
-	Deposit int `json:"deposit" example:"5"`
+	Deposit  int         `json:"deposit" example:"5"`
+	Coins    []*CoinTube `json:"coins"`
+	Currency string      `json:"currency" example:"EUR"`
 }
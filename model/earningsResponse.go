@@ -0,0 +1,14 @@
+package model
+
+import "github.com/oltur/mvp-match/types"
+
+type EarningsResponse struct {
+	SellerId types.Id `json:"sellerId" example:"xxx"`
+	// Balance is the amount owed to the seller, sales minus refunds and payouts
+	Balance      int            `json:"balance" example:"5"`
+	TotalSales   int            `json:"totalSales" example:"5"`
+	TotalPayouts int            `json:"totalPayouts" example:"5"`
+	TotalRefunds int            `json:"totalRefunds" example:"5"`
+	Entries      []*LedgerEntry `json:"entries"`
+	Currency     string         `json:"currency" example:"EUR"`
+}
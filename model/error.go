@@ -4,7 +4,7 @@ import "errors"
 
 var (
 	ErrNotFound                = errors.New("not found")
-	ErrInvalidCost             = errors.New("cost should be positive and in multiples of 5")
+	ErrInvalidCost             = errors.New("cost should be positive and in multiples of the smallest currency unit")
 	ErrInvalidAmount           = errors.New("amount should be non-negative")
 	ErrInvalidSeller           = errors.New("user is not a seller")
 	ErrInvalidBuyer            = errors.New("user is not a buyer")
@@ -17,7 +17,6 @@ var (
 	ErrInvalidUserName         = errors.New("invalid user name")
 	ErrInvalidPassword         = errors.New("invalid password")
 	ErrInvalidUserRole         = errors.New("unsupported user role")
-	ErrCannotCreateAdmin       = errors.New("cannot create admin user")
 	ErrUserNotFoundInContext   = errors.New("user not found in context")
 	ErrNotEnoughDeposit        = errors.New("not enough deposit")
 	ErrCannotGenerateUserToken = errors.New("cannot generate user token")
@@ -26,5 +25,40 @@ var (
 	ErrUnauthorized            = errors.New("'Authorization' is required Header")
 	ErrUserIdExists            = errors.New("user with given ID already exists")
 	ErrUserNameExists          = errors.New("user with given name already exists")
-	ErrActiveSessionExists     = errors.New("there is already an active session using your account")
+	ErrInvalidAmountOfProducts = errors.New("amount of products should be positive")
+	ErrPurchaseConflict        = errors.New("the purchase conflicted with a concurrent one, please retry")
+	ErrEmptyCart               = errors.New("the cart is empty")
+	ErrInvalidIdempotencyKey   = errors.New("idempotency key is too long")
+	ErrIdempotencyKeyReused    = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyInProgress   = errors.New("a request with this idempotency key is still in progress")
+	ErrInvalidReceiptFormat    = errors.New("receipt format should be text or json")
+	ErrInvalidRefundMethod     = errors.New("refund method should be deposit or coins")
+	ErrOrderAlreadyRefunded    = errors.New("the order is already refunded")
+	ErrInvalidJwtKeys          = errors.New("JWT keys should be kid:secret pairs with unique kids")
+	ErrJwtSecretTooShort       = errors.New("JWT secrets should be at least 32 characters long")
+	ErrNoJwtSigningKey         = errors.New("JWT keys should include a secret or a private key to sign tokens")
+	ErrUnsupportedJwtKey       = errors.New("JWT PEM keys should be RSA keys of at least 2048 bits or EC P-256 keys")
+	ErrTokenMalformed          = errors.New("the token is malformed")
+	ErrTokenSignatureInvalid   = errors.New("the token signature is invalid or made with an unknown key")
+	ErrTokenExpired            = errors.New("the token is expired")
+	ErrTokenClaimsInvalid      = errors.New("the token subject, issuer, audience or times are invalid")
+	ErrSessionEnded            = errors.New("the session of the token has ended, please log in again")
+	ErrSessionIdExists         = errors.New("session with given ID already exists")
+	ErrInvalidDevice           = errors.New("device label is too long")
+	ErrRefreshTokenReused      = errors.New("the refresh token was used before, the session is revoked")
+	ErrInvalidRefreshToken     = errors.New("the refresh token is invalid or its session has ended")
+	ErrLoginLocked             = errors.New("too many failed logins, try again later")
+	ErrInvalidLockoutPolicy    = errors.New("login lockout settings should be positive, the max lockout at least the lockout")
+	ErrInvalidLockoutKind      = errors.New("lockout kind should be user or ip")
+	ErrTotpRequired            = errors.New("a one-time password is required")
+	ErrTotpInvalidCode         = errors.New("the one-time password or recovery code is invalid")
+	ErrTotpCodeUsed            = errors.New("the one-time password was used already, please wait for the next one")
+	ErrTotpEnrolled            = errors.New("the second factor is enrolled already")
+	ErrTotpNotEnrolled         = errors.New("the second factor is not enrolled")
+	ErrTotpEnrollmentRequired  = errors.New("a second factor is required for the role, please enroll it")
+	ErrInvalidLoginChallenge   = errors.New("the login challenge is invalid or expired, please log in again")
+	ErrCorruptEventLog         = errors.New("the event log is corrupt")
+	ErrInvalidTimeRange        = errors.New("time range bounds should be RFC 3339 times, from before to")
+	ErrAuditNotRecorded        = errors.New("the change could not be recorded in the audit log")
+	ErrInvalidEnvValue         = errors.New("environment variable cannot be parsed")
 )
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+const (
+	// EventSnapshot records the machine state the event log starts from
+	EventSnapshot       = "snapshot"
+	EventDeposit        = "deposit"
+	EventPurchase       = "purchase"
+	EventReset          = "reset"
+	EventOrderRefund    = "order.refund"
+	EventStock          = "product.stock"
+	EventProductDeleted = "product.deleted"
+	EventCoinsLoaded    = "coins.loaded"
+	EventCoinsEmptied   = "coins.emptied"
+	EventDepositCleared = "deposit.cleared"
+	EventUserDeleted    = "user.deleted"
+)
+
+// Event is a domain event of the vending machine, events are append only and ordered by Seq
+type Event struct {
+	Seq  int64           `json:"seq" example:"1"`
+	Type string          `json:"type" example:"deposit"`
+	At   time.Time       `json:"at"`
+	Data json.RawMessage `json:"data" swaggertype:"object"`
+}
+
+// DepositEvent is a coin inserted by a buyer
+type DepositEvent struct {
+	BuyerId types.Id `json:"buyerId"`
+	Coin    int      `json:"coin"`
+}
+
+// PurchaseEvent is a bought cart, the inserted coins join the tubes and the change is paid out of them
+type PurchaseEvent struct {
+	BuyerId  types.Id    `json:"buyerId"`
+	OrderId  types.Id    `json:"orderId"`
+	Items    []*CartItem `json:"items"`
+	Inserted []*CoinTube `json:"inserted"`
+	Change   []*Coin     `json:"change"`
+}
+
+// ResetEvent is a returned deposit. Inserted are the deposit coins joining the tubes, none of them when they are
+// given back as they are, and Paid are the coins paid out of the tubes.
+type ResetEvent struct {
+	BuyerId  types.Id    `json:"buyerId"`
+	Inserted []*CoinTube `json:"inserted"`
+	Paid     []*Coin     `json:"paid"`
+}
+
+// OrderRefundEvent is a reversed order, the items are restocked and the amount credited or paid as coins
+type OrderRefundEvent struct {
+	OrderId  types.Id    `json:"orderId"`
+	RefundId types.Id    `json:"refundId"`
+	BuyerId  types.Id    `json:"buyerId"`
+	Method   string      `json:"method"`
+	Amount   int         `json:"amount"`
+	Items    []*CartItem `json:"items"`
+	Coins    []*Coin     `json:"coins"`
+}
+
+// StockEvent sets the stock of a product, when it is added, restocked or edited
+type StockEvent struct {
+	ProductId       types.Id `json:"productId"`
+	AmountAvailable int      `json:"amountAvailable"`
+}
+
+// ProductDeletedEvent is a removed product
+type ProductDeletedEvent struct {
+	ProductId types.Id `json:"productId"`
+}
+
+// CoinsLoadedEvent is a load of the coin tubes
+type CoinsLoadedEvent struct {
+	Coins []*CoinTube `json:"coins"`
+}
+
+// CoinsEmptiedEvent is an emptied tube, or all of them for value 0
+type CoinsEmptiedEvent struct {
+	Value int `json:"value"`
+}
+
+// UserEvent is a deposit cleared without returning it, or a deleted user
+type UserEvent struct {
+	UserId types.Id `json:"userId"`
+}
+
+// EventRecorder records the event of an operation changing the deposits, the stock or the coins. The memory store
+// calls it once the operation is checked and before it is applied, the SQL store once the operation is committed and
+// again for events it could not record, so an event which cannot be recorded fails the operation instead of leaving
+// a gap in the history.
+type EventRecorder func(eventType string, data interface{}) (err error)
+
+// recordEvent calls the recorder, a store without one records nothing
+func recordEvent(record EventRecorder, eventType string, data interface{}) (err error) {
+	if record == nil {
+		return
+	}
+	return record(eventType, data)
+}
+
+// purchaseEvent is the event of a prepared purchase, the inserted coins are the deposit of the user
+func purchaseEvent(user *User, items []*CartItem, purchase *Purchase) *PurchaseEvent {
+	return &PurchaseEvent{
+		BuyerId:  user.ID,
+		OrderId:  purchase.Order.ID,
+		Items:    items,
+		Inserted: user.DepositCoins,
+		Change:   purchase.Change,
+	}
+}
+
+// resetEvent is the event of a prepared refund of the user deposit, paid are the coins taken out of the tubes
+func resetEvent(user *User, returnInserted bool, paid []*Coin) *ResetEvent {
+	res := &ResetEvent{BuyerId: user.ID, Inserted: user.DepositCoins, Paid: paid}
+	if returnInserted {
+		// the inserted coins are given back as they are
+		res.Inserted = nil
+	}
+	return res
+}
+
+// orderRefundEvent is the event of a prepared refund of the order
+func orderRefundEvent(order *Order, refund *OrderRefund) *OrderRefundEvent {
+	items := make([]*CartItem, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		items = append(items, &CartItem{ProductId: line.ProductId, AmountOfProducts: line.AmountOfProducts})
+	}
+	return &OrderRefundEvent{
+		OrderId:  order.ID,
+		RefundId: refund.ID,
+		BuyerId:  refund.BuyerId,
+		Method:   refund.Method,
+		Amount:   refund.Amount,
+		Items:    items,
+		Coins:    refund.Coins,
+	}
+}
+
+// newEvent serializes the event data
+func newEvent(eventType string, data interface{}) (res *Event, err error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	res = &Event{Type: eventType, At: time.Now(), Data: b}
+	return
+}
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"sync"
+	"time"
+)
+
+// EventSourcedStore records the operations changing the user deposits, the product stock and the coin tubes of the
+// wrapped store as events, so the machine state can be replayed at any point in time and reconciled with the store.
+// The memory store records the event of an operation before applying it, the SQL store stages it in the transaction
+// of the operation and records it after the commit, so an operation whose event cannot be appended fails and the log
+// never misses a change made through the store, nor has one of a change rolled back. The operations are serialized
+// with the replays, so a reconciliation does not see an operation half done.
+type EventSourcedStore struct {
+	Store
+	mu     sync.Mutex
+	events EventStore
+}
+
+// NewEventSourcedStore wraps the store. An empty event log gets a snapshot of the store as the starting point of the
+// events after it. With restore, a non-empty log is replayed into the store instead, restore is meant for stores which
+// do not keep their state between runs and are filled again, e.g. seeded, before they are wrapped.
+func NewEventSourcedStore(store Store, events EventStore, restore bool) (res *EventSourcedStore, err error) {
+	count, err := events.EventsCount()
+	if err != nil {
+		return
+	}
+	if count == 0 {
+		var state *MachineState
+		state, err = snapshotState(store)
+		if err != nil {
+			return
+		}
+		err = appendEvent(events, EventSnapshot, state)
+		if err != nil {
+			return
+		}
+	} else if restore {
+		var all []*Event
+		all, err = events.EventsAll(time.Time{}, time.Time{})
+		if err != nil {
+			return
+		}
+		var state *MachineState
+		state, err = Replay(all, time.Time{})
+		if err != nil {
+			return
+		}
+		// the recorder is not set yet, so restoring records no events
+		err = restoreState(state, store)
+		if err != nil {
+			return
+		}
+	}
+	res = &EventSourcedStore{Store: store, events: events}
+	store.SetEventRecorder(res.record)
+	// the events of the operations committed before a failed recording
+	err = store.FlushEvents()
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// ------- events ---------------
+
+func (s *EventSourcedStore) EventsAll(from time.Time, to time.Time) (res []*Event, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.FlushEvents()
+	if err != nil {
+		return
+	}
+	res, err = s.events.EventsAll(from, to)
+	return
+}
+
+func (s *EventSourcedStore) MachineStateAt(at time.Time) (res *MachineState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.FlushEvents()
+	if err != nil {
+		return
+	}
+	events, err := s.events.EventsAll(time.Time{}, time.Time{})
+	if err != nil {
+		return
+	}
+	res, err = Replay(events, at)
+	return
+}
+
+func (s *EventSourcedStore) Reconcile() (res []*Discrepancy, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.FlushEvents()
+	if err != nil {
+		return
+	}
+	events, err := s.events.EventsAll(time.Time{}, time.Time{})
+	if err != nil {
+		return
+	}
+	state, err := Replay(events, time.Time{})
+	if err != nil {
+		return
+	}
+	res, err = Reconcile(state, s.Store)
+	return
+}
+
+// ------- users ---------------
+
+func (s *EventSourcedStore) UserResetDeposit(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.UserResetDeposit(id)
+	return
+}
+
+func (s *EventSourcedStore) UserDelete(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.UserDelete(id)
+	return
+}
+
+// ------- products ---------------
+
+func (s *EventSourcedStore) ProductInsert(req *Product) (res *Product, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.ProductInsert(req)
+	return
+}
+
+func (s *EventSourcedStore) ProductUpdate(req *UpdateProductRequest) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.ProductUpdate(req)
+	return
+}
+
+// ProductSave Internal use only
+func (s *EventSourcedStore) ProductSave(req *Product) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.ProductSave(req)
+	return
+}
+
+func (s *EventSourcedStore) ProductDelete(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.Store.ProductDelete(id)
+	return
+}
+
+// ------- coins ---------------
+
+func (s *EventSourcedStore) CoinsLoad(req []*CoinTube) (res []*CoinTube, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.CoinsLoad(req)
+	return
+}
+
+func (s *EventSourcedStore) CoinsEmpty(value int) (res []*CoinTube, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.CoinsEmpty(value)
+	return
+}
+
+// ------- vending ---------------
+
+func (s *EventSourcedStore) Deposit(buyerId types.Id, coin *Coin) (res *User, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.Deposit(buyerId, coin)
+	return
+}
+
+func (s *EventSourcedStore) Purchase(buyerId types.Id, items []*CartItem, makeChange ChangeMaker) (res *Purchase, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.Purchase(buyerId, items, makeChange)
+	return
+}
+
+func (s *EventSourcedStore) Refund(buyerId types.Id, returnInserted bool, makeChange ChangeMaker) (res []*Coin, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.Refund(buyerId, returnInserted, makeChange)
+	return
+}
+
+func (s *EventSourcedStore) RefundOrder(orderId types.Id, method string, reason string, refundedBy types.Id, makeChange ChangeMaker) (res *OrderRefund, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err = s.Store.RefundOrder(orderId, method, reason, refundedBy, makeChange)
+	return
+}
+
+// ------- implementation details ---------------
+
+// record appends the event of an operation of the wrapped store, it is the recorder of the store
+func (s *EventSourcedStore) record(eventType string, data interface{}) (err error) {
+	return appendEvent(s.events, eventType, data)
+}
+
+func appendEvent(events EventStore, eventType string, data interface{}) (err error) {
+	event, err := newEvent(eventType, data)
+	if err != nil {
+		return
+	}
+	err = events.EventAppend(event)
+	return
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventStore is an append-only log of events, implementations must be safe for concurrent use
+type EventStore interface {
+	// EventAppend assigns the next sequence number to the event and appends it
+	EventAppend(req *Event) (err error)
+	// EventsAll returns the events between from, inclusive, and to, exclusive, the oldest first.
+	// Zero times leave the range open.
+	EventsAll(from time.Time, to time.Time) (res []*Event, err error)
+	// EventsCount returns the number of events in the log
+	EventsCount() (res int64, err error)
+}
+
+// MemoryEventStore keeps the events in memory, without persistence
+type MemoryEventStore struct {
+	mu     sync.RWMutex
+	events []*Event
+}
+
+// NewMemoryEventStore creates an empty in-memory event store
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{}
+}
+
+func (s *MemoryEventStore) EventAppend(req *Event) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.Seq = int64(len(s.events)) + 1
+	e := *req
+	s.events = append(s.events, &e)
+	return
+}
+
+func (s *MemoryEventStore) EventsAll(from time.Time, to time.Time) (res []*Event, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*Event{}
+	for _, event := range s.events {
+		if eventInRange(event, from, to) {
+			e := *event
+			res = append(res, &e)
+		}
+	}
+	return
+}
+
+func (s *MemoryEventStore) EventsCount() (res int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = int64(len(s.events))
+	return
+}
+
+// FileEventStore keeps the events in a local file, one JSON document per line.
+// Every append is synced to disk before it returns.
+type FileEventStore struct {
+	mu   sync.RWMutex
+	path string
+	// count is the number of events in the file
+	count int64
+}
+
+// NewFileEventStore opens the event log at the given path, the file is created if it does not exist
+func NewFileEventStore(path string) (res *FileEventStore, err error) {
+	res = &FileEventStore{path: path}
+	err = res.scan(func(event *Event) {
+		res.count = event.Seq
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *FileEventStore) EventAppend(req *Event) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.Seq = s.count + 1
+	b, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	s.count = req.Seq
+	return
+}
+
+func (s *FileEventStore) EventsAll(from time.Time, to time.Time) (res []*Event, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*Event{}
+	err = s.scan(func(event *Event) {
+		if eventInRange(event, from, to) {
+			res = append(res, event)
+		}
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *FileEventStore) EventsCount() (res int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = s.count
+	return
+}
+
+// scan reads the events of the file in order, a missing file has no events
+func (s *FileEventStore) scan(fn func(event *Event)) (err error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		event := &Event{}
+		err = json.Unmarshal(scanner.Bytes(), event)
+		if err != nil || event.Seq != int64(line) {
+			err = fmt.Errorf("%w: line %d of %s", ErrCorruptEventLog, line, s.path)
+			return
+		}
+		fn(event)
+	}
+	err = scanner.Err()
+	return
+}
+
+func eventInRange(event *Event, from time.Time, to time.Time) bool {
+	if !from.IsZero() && event.At.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !event.At.Before(to) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+// IdempotencyKeyMaxLength limits the Idempotency-Key header
+const IdempotencyKeyMaxLength = 255
+
+// IdempotencyRecord remembers the response of a request sent with an Idempotency-Key, keys are scoped by user
+type IdempotencyRecord struct {
+	UserId types.Id
+	Key    string
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string
+	// Status is the stored response status, 0 while the original request is in progress
+	Status    int
+	Body      []byte
+	CreatedAt time.Time
+}
+
+// InProgress tells if the original request has not completed yet
+func (a *IdempotencyRecord) InProgress() bool {
+	return a.Status == 0
+}
+
+func (a *IdempotencyRecord) copy() (res *IdempotencyRecord) {
+	res = &IdempotencyRecord{}
+	*res = *a
+	res.Body = append([]byte(nil), a.Body...)
+	return
+}
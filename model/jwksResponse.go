@@ -0,0 +1,20 @@
+package model
+
+// Jwk is a public key in the JSON Web Key format, RFC 7517
+type Jwk struct {
+	Kty string `json:"kty" example:"RSA"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"RS256"`
+	Kid string `json:"kid" example:"k1"`
+	// N and E are the RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty" example:"AQAB"`
+	// Crv, X and Y are the EC curve and point
+	Crv string `json:"crv,omitempty" example:"P-256"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JwksResponse struct {
+	Keys []*Jwk `json:"keys"`
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+	"strings"
+	"time"
+)
+
+const (
+	// LedgerAccountMachine is the cash taken in by the machine
+	LedgerAccountMachine = "machine"
+	// ledgerAccountSellerPrefix prefixes the accounts of the amounts owed to sellers
+	ledgerAccountSellerPrefix = "seller:"
+)
+
+const (
+	LedgerKindSale   = "sale"
+	LedgerKindPayout = "payout"
+	LedgerKindRefund = "refund"
+)
+
+// LedgerEntry moves an amount from the credit account to the debit account, so every entry is balanced by construction.
+// The balance of an account is its credits minus its debits.
+type LedgerEntry struct {
+	ID            types.Id `json:"id" example:"xxx"`
+	Kind          string   `json:"kind" example:"sale"`
+	DebitAccount  string   `json:"debitAccount" example:"machine"`
+	CreditAccount string   `json:"creditAccount" example:"seller:xxx"`
+	Amount        int      `json:"amount" example:"5"`
+	// OrderId and ProductId link a sale to the order line
+	OrderId   types.Id `json:"orderId,omitempty" example:"xxx"`
+	ProductId types.Id `json:"productId,omitempty" example:"xxx"`
+	// PayoutId links a payout entry to its settlement
+	PayoutId types.Id `json:"payoutId,omitempty" example:"xxx"`
+	// RefundId links a refund entry to the order refund
+	RefundId  types.Id  `json:"refundId,omitempty" example:"xxx"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Payout is the settlement of a seller balance
+type Payout struct {
+	ID        types.Id  `json:"id" example:"xxx"`
+	SellerId  types.Id  `json:"sellerId" example:"xxx"`
+	Amount    int       `json:"amount" example:"5"`
+	PaidBy    types.Id  `json:"paidBy" example:"xxx"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SellerAccount is the ledger account of the amount owed to the seller
+func SellerAccount(sellerId types.Id) string {
+	return ledgerAccountSellerPrefix + string(sellerId)
+}
+
+// sellerIdFromAccount returns the seller of a seller account
+func sellerIdFromAccount(account string) (res types.Id, ok bool) {
+	if !strings.HasPrefix(account, ledgerAccountSellerPrefix) {
+		return
+	}
+	return types.Id(strings.TrimPrefix(account, ledgerAccountSellerPrefix)), true
+}
+
+// saleEntries credit the sellers with the order lines
+func saleEntries(order *Order) (res []*LedgerEntry) {
+	res = make([]*LedgerEntry, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		res = append(res, &LedgerEntry{
+			ID:            types.Id(xid.New().String()),
+			Kind:          LedgerKindSale,
+			DebitAccount:  LedgerAccountMachine,
+			CreditAccount: SellerAccount(line.SellerId),
+			Amount:        line.Total,
+			OrderId:       order.ID,
+			ProductId:     line.ProductId,
+			CreatedAt:     order.CreatedAt,
+		})
+	}
+	return
+}
+
+// newPayout settles the seller balance, the payout entry debits the seller
+func newPayout(sellerId types.Id, amount int, paidBy types.Id, createdAt time.Time) (res *Payout, entry *LedgerEntry) {
+	res = &Payout{
+		ID:        types.Id(xid.New().String()),
+		SellerId:  sellerId,
+		Amount:    amount,
+		PaidBy:    paidBy,
+		CreatedAt: createdAt,
+	}
+	entry = &LedgerEntry{
+		ID:            types.Id(xid.New().String()),
+		Kind:          LedgerKindPayout,
+		DebitAccount:  SellerAccount(sellerId),
+		CreditAccount: LedgerAccountMachine,
+		Amount:        amount,
+		PayoutId:      res.ID,
+		CreatedAt:     createdAt,
+	}
+	return
+}
+
+// ledgerBalances sums the entries into balances by account
+func ledgerBalances(entries []*LedgerEntry) (res map[string]int) {
+	res = make(map[string]int)
+	for _, entry := range entries {
+		res[entry.CreditAccount] = res[entry.CreditAccount] + entry.Amount
+		res[entry.DebitAccount] = res[entry.DebitAccount] - entry.Amount
+	}
+	return
+}
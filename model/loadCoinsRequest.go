@@ -0,0 +1,15 @@
+package model
+
+type LoadCoinsRequest struct {
+	Coins []*CoinTube `json:"coins"`
+}
+
+func (a LoadCoinsRequest) Validation(currency *Currency) (err error) {
+	for _, tube := range a.Coins {
+		err = tube.Validation(currency)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,10 @@
+package model
+
+// LoginChallengeResponse is the answer to the password of a user with a second factor, the login is completed
+// with the challenge token and a one-time password or a recovery code
+type LoginChallengeResponse struct {
+	TotpRequired   bool   `json:"totpRequired" example:"true"`
+	ChallengeToken string `json:"challengeToken"`
+	// ChallengeExpires is in milliseconds
+	ChallengeExpires int64 `json:"challengeExpires"`
+}
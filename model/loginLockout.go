@@ -0,0 +1,104 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// LockoutKindUser tracks the failed logins by user name, the names of missing users included
+	LockoutKindUser = "user"
+	// LockoutKindIp tracks the failed logins by client IP
+	LockoutKindIp = "ip"
+)
+
+// LoginLockout counts the recent failed logins of an account or a client IP, which are locked out for a while
+// after too many of them
+type LoginLockout struct {
+	Kind          string    `json:"kind" example:"user"`
+	Subject       string    `json:"subject" example:"User #3, Buyer"`
+	Failures      int       `json:"failures" example:"5"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+	// LockedUntil is zero if there was no lockout
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// Locked tells if logins are refused at the given time
+func (a *LoginLockout) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+// Stale tells if the failures are forgotten at the given time
+func (a *LoginLockout) Stale(now time.Time, policy *LockoutPolicy) bool {
+	return !a.Locked(now) && now.Sub(a.LastFailureAt) >= policy.FailureWindow
+}
+
+// fail records a failed login at the given time, locking out as the policy says
+func (a *LoginLockout) fail(now time.Time, policy *LockoutPolicy) {
+	if a.Stale(now, policy) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	if lockout := policy.Lockout(a.Failures); lockout > 0 {
+		a.LockedUntil = now.Add(lockout)
+	}
+}
+
+// attempt counts a login attempt at the given time as a failed login until it is taken back, so parallel attempts
+// cannot pass before the first failure is recorded. ErrLoginLocked if logins are refused.
+func (a *LoginLockout) attempt(now time.Time, policy *LockoutPolicy) (err error) {
+	if a.Locked(now) {
+		return ErrLoginLocked
+	}
+	a.fail(now, policy)
+	return
+}
+
+// takeBack undoes the failure counted by the attempt at the given time, and the lockout it caused: the attempt was
+// let in, so there was none before, and a later attempt would have changed the time of the last failure
+func (a *LoginLockout) takeBack(at time.Time) {
+	if a.Failures > 0 {
+		a.Failures--
+	}
+	if a.LastFailureAt.Equal(at) {
+		a.LockedUntil = time.Time{}
+	}
+}
+
+func (a *LoginLockout) copy() *LoginLockout {
+	res := *a
+	return &res
+}
+
+// LockoutPolicy decides how long an account or IP is locked out after failed logins
+type LockoutPolicy struct {
+	// MaxFailures are allowed before the first lockout, every further failure doubles the lockout
+	MaxFailures int
+	// BaseLockout is the first lockout, MaxLockout the longest one
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// FailureWindow is the quiet time after which the failures are forgotten
+	FailureWindow time.Duration
+}
+
+// Lockout returns the lockout after the given number of failures, zero if there is none
+func (a *LockoutPolicy) Lockout(failures int) (res time.Duration) {
+	if failures < a.MaxFailures {
+		return
+	}
+	res = a.BaseLockout
+	for i := a.MaxFailures; i < failures && res < a.MaxLockout; i++ {
+		res *= 2
+	}
+	if res > a.MaxLockout {
+		res = a.MaxLockout
+	}
+	return
+}
+
+func sortLockoutsLatestFirst(lockouts []*LoginLockout) {
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailureAt.After(lockouts[j].LastFailureAt)
+	})
+}
@@ -3,4 +3,14 @@ package model
 type LoginRequest struct {
 	UserName string `json:"userName"`
 	Password string `json:"password"`
+	// Device labels the session, e.g. machine or phone
+	Device string `json:"device" example:"phone"`
+}
+
+func (a LoginRequest) Validation() (err error) {
+	if len(a.Device) > SessionDeviceMaxLength {
+		err = ErrInvalidDevice
+		return
+	}
+	return
 }
@@ -1,6 +1,16 @@
 package model
 
+import "github.com/oltur/mvp-match/types"
+
 type LoginResponse struct {
+	// Token is the short-lived access token, TokenExpires is its expiry in milliseconds
 	Token        string `json:"token"`
 	TokenExpires int64  `json:"tokenExpires"`
+	// RefreshToken is exchanged once for new tokens of the session until RefreshTokenExpires, in milliseconds
+	RefreshToken        string   `json:"refreshToken"`
+	RefreshTokenExpires int64    `json:"refreshTokenExpires"`
+	SessionId           types.Id `json:"sessionId" example:"xxx"`
+	// TotpEnrollmentRequired tells that the role of the user requires a second factor, which is to be enrolled
+	// before anything else
+	TotpEnrollmentRequired bool `json:"totpEnrollmentRequired,omitempty"`
 }
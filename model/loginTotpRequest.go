@@ -0,0 +1,16 @@
+package model
+
+// LoginTotpRequest is the second step of the login of a user with a second factor
+type LoginTotpRequest struct {
+	// ChallengeToken is given by the first step
+	ChallengeToken string `json:"challengeToken"`
+	TotpCodeRequest
+}
+
+func (a LoginTotpRequest) Validation() (err error) {
+	if a.ChallengeToken == "" {
+		err = ErrInvalidLoginChallenge
+		return
+	}
+	return a.TotpCodeRequest.Validation()
+}
@@ -0,0 +1,8 @@
+package model
+
+// LogoutAllRequest ends all sessions of a user with the credentials, a user with a second factor proves it too
+type LogoutAllRequest struct {
+	UserName string `json:"userName"`
+	Password string `json:"password"`
+	TotpCodeRequest
+}
@@ -0,0 +1,1212 @@
+package model
+
+import (
+	"errors"
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps users, products and coins in memory, without persistence.
+// It is safe for concurrent use: every method holds the store lock, and entities
+// are copied on the way in and out, so callers never share a pointer with the store.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	usersByIds    map[types.Id]*User
+	productsByIds map[types.Id]*Product
+	sessionsByIds map[types.Id]*Session
+	// refreshTokensByHashes are the refresh tokens of the sessions, used ones included
+	refreshTokensByHashes map[string]*RefreshToken
+	// lockouts are the failed logins by kind and subject
+	lockouts map[lockoutKey]*LoginLockout
+	// totpsByUserIds are the second factors, totpRequiredRoles the roles whose users must use one
+	totpsByUserIds    map[types.Id]*Totp
+	totpRequiredRoles []string
+	// rolesByNames are the roles and their permissions
+	rolesByNames map[string]*Role
+	// coins is the machine coin inventory, counts by coin value
+	coins map[int]int
+	// ordersByIds are the recorded purchases
+	ordersByIds map[types.Id]*Order
+	// orderRefundsByIds are the reversed orders
+	orderRefundsByIds map[types.Id]*OrderRefund
+	// ledger and payouts are append only, the oldest first
+	ledger  []*LedgerEntry
+	payouts []*Payout
+	// auditLog is append only, the oldest first
+	auditLog []*AuditEntry
+	// idempotencyRecords are keyed by user and Idempotency-Key
+	idempotencyRecords map[idempotencyRecordKey]*IdempotencyRecord
+	// record records the events of the operations, it is called with the lock held
+	record EventRecorder
+}
+
+type lockoutKey struct {
+	kind    string
+	subject string
+}
+
+type idempotencyRecordKey struct {
+	userId types.Id
+	key    string
+}
+
+// NewMemoryStore creates an in-memory store without users, with the default roles
+func NewMemoryStore() *MemoryStore {
+	rolesByNames := make(map[string]*Role)
+	for _, role := range DefaultRoles() {
+		rolesByNames[role.Name] = role
+	}
+	return &MemoryStore{
+		usersByIds:    make(map[types.Id]*User),
+		productsByIds: make(map[types.Id]*Product),
+		sessionsByIds: make(map[types.Id]*Session),
+		coins:         make(map[int]int),
+		ordersByIds:   make(map[types.Id]*Order),
+
+		orderRefundsByIds: make(map[types.Id]*OrderRefund),
+
+		refreshTokensByHashes: make(map[string]*RefreshToken),
+		lockouts:              make(map[lockoutKey]*LoginLockout),
+		totpsByUserIds:        make(map[types.Id]*Totp),
+		rolesByNames:          rolesByNames,
+		idempotencyRecords:    make(map[idempotencyRecordKey]*IdempotencyRecord),
+	}
+}
+
+func (s *MemoryStore) SetEventRecorder(record EventRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record = record
+}
+
+// FlushEvents does nothing, the memory store records the events before it applies the operations
+func (s *MemoryStore) FlushEvents() (err error) {
+	return
+}
+
+// ------- users ---------------
+
+func (s *MemoryStore) UsersAll(q string) (res []*User, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	allUsers := GetMapValuesForUsers(s.usersByIds)
+	if q == "" {
+		res = allUsers
+		return
+	}
+	res = []*User{}
+	for k, v := range allUsers {
+		if q == v.UserName {
+			res = append(res, allUsers[k])
+		}
+	}
+	return
+}
+
+func (s *MemoryStore) UserOne(id types.Id) (res *User, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, err := s.userOne(id)
+	if err != nil {
+		return
+	}
+	res = user.copy()
+	return
+}
+
+func (s *MemoryStore) UserInsert(req *User) (res *User, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.ID = types.Id(xid.New().String())
+
+	_, err = s.userOne(req.ID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return
+		} else {
+			err = nil
+		}
+	} else {
+		err = ErrUserIdExists
+		return
+	}
+
+	if !s.isUserNameFree(req.UserName) {
+		err = ErrUserNameExists
+		return
+	}
+
+	s.usersByIds[req.ID] = req.copy()
+	res = req
+	return
+}
+
+func (s *MemoryStore) UserResetDeposit(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.userOne(id)
+	if err != nil {
+		return
+	}
+	err = recordEvent(s.record, EventDepositCleared, &UserEvent{UserId: id})
+	if err != nil {
+		return
+	}
+
+	user.Deposit = 0
+	user.DepositCoins = nil
+	return
+}
+
+// UserUpdate part of CRUD
+func (s *MemoryStore) UserUpdate(req *UpdateUserRequest, hasher PasswordHasher) (err error) {
+	// hashing is slow on purpose, so it is done before taking the lock
+	passwordHash, err := hasher.Hash(req.Password)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.userOne(req.ID)
+	if err != nil {
+		return
+	}
+
+	user.PasswordHash = passwordHash
+	return
+}
+
+// UserSave Internal use only
+func (s *MemoryStore) UserSave(req *User) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usersByIds[req.ID] = req.copy()
+	return
+}
+
+func (s *MemoryStore) UserDelete(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.usersByIds[id]; !ok {
+		err = ErrNotFound
+		return
+	}
+	users := []*User{}
+	for _, user := range s.usersByIds {
+		if user.ID != id {
+			users = append(users, user)
+		}
+	}
+	err = s.checkRoleManagers(users, s.roles())
+	if err != nil {
+		return
+	}
+	err = recordEvent(s.record, EventUserDeleted, &UserEvent{UserId: id})
+	if err != nil {
+		return
+	}
+	delete(s.usersByIds, id)
+	delete(s.totpsByUserIds, id)
+	s.deleteUserSessions(id, func(*Session) bool { return true })
+	return
+}
+
+// GetMapValuesForUsers returns copies of the map values
+func GetMapValuesForUsers(m map[types.Id]*User) (res []*User) {
+	res = make([]*User, len(m))
+	i := 0
+	for _, v := range m {
+		res[i] = v.copy()
+		i++
+	}
+	return res
+}
+
+func (s *MemoryStore) IsUserNameFree(userName string) (res bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = s.isUserNameFree(userName)
+	return
+}
+
+func (s *MemoryStore) GetUserByCredentials(userName string, password string, hasher PasswordHasher) (res *User, err error) {
+	s.mu.RLock()
+	for _, user := range s.usersByIds {
+		if user.UserName == userName {
+			res = user.copy()
+		}
+	}
+	s.mu.RUnlock()
+	if res == nil {
+		verifyUnknownUser(hasher, password)
+		err = ErrNotFound
+		return
+	}
+
+	ok, rehash := hasher.Verify(res.PasswordHash, password)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !rehash {
+		return
+	}
+	// the login does not depend on the rehash, e.g. an old password too long for bcrypt keeps its hash
+	passwordHash, hashErr := hasher.Hash(password)
+	if hashErr != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the password could have been changed meanwhile
+	user, ok := s.usersByIds[res.ID]
+	if ok && user.PasswordHash == res.PasswordHash {
+		user.PasswordHash = passwordHash
+		res.PasswordHash = passwordHash
+	}
+	return
+}
+
+func (s *MemoryStore) UserLogout(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.userOne(id)
+	if err != nil {
+		return
+	}
+	s.deleteUserSessions(id, func(*Session) bool { return true })
+	return
+}
+
+func (s *MemoryStore) UserSetRoles(id types.Id, roles []string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.usersByIds[id]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	changed := user.copy()
+	changed.Roles = append([]string{}, roles...)
+	users := []*User{changed}
+	for _, u := range s.usersByIds {
+		if u.ID != id {
+			users = append(users, u)
+		}
+	}
+	err = s.checkRoleManagers(users, s.roles())
+	if err != nil {
+		return
+	}
+	user.Roles = changed.Roles
+	return
+}
+
+// ------- sessions ---------------
+
+func (s *MemoryStore) SessionInsert(req *Session, refreshToken *RefreshToken) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessionsByIds[req.ID]; ok {
+		err = ErrSessionIdExists
+		return
+	}
+	now := time.Now()
+	s.deleteUserSessions(req.UserId, func(session *Session) bool { return session.Expired(now) })
+	s.sessionsByIds[req.ID] = req.copy()
+	s.refreshTokensByHashes[refreshToken.Hash] = refreshToken.copy()
+	return
+}
+
+func (s *MemoryStore) SessionsByUser(userId types.Id) (res []*Session, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	res = []*Session{}
+	for _, session := range s.sessionsByIds {
+		if session.UserId == userId && !session.Expired(now) {
+			res = append(res, session.copy())
+		}
+	}
+	sortSessionsNewestFirst(res)
+	return
+}
+
+func (s *MemoryStore) SessionTouch(id types.Id, now time.Time) (res *Session, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessionsByIds[id]
+	if !ok || session.Expired(now) {
+		err = ErrNotFound
+		return
+	}
+	session.LastSeenAt = now
+	res = session.copy()
+	return
+}
+
+func (s *MemoryStore) SessionDelete(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessionsByIds[id]; !ok {
+		err = ErrNotFound
+		return
+	}
+	s.deleteSession(id)
+	return
+}
+
+func (s *MemoryStore) SessionRefresh(hash string, next *RefreshToken, now time.Time) (res *Session, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokensByHashes[hash]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	session, ok := s.sessionsByIds[token.SessionId]
+	if !ok || session.Expired(now) {
+		err = ErrNotFound
+		return
+	}
+	if !token.UsedAt.IsZero() {
+		s.deleteSession(session.ID)
+		res = session.copy()
+		err = ErrRefreshTokenReused
+		return
+	}
+
+	token.UsedAt = now
+	next.SessionId = session.ID
+	s.refreshTokensByHashes[next.Hash] = next.copy()
+	session.LastSeenAt = now
+	res = session.copy()
+	return
+}
+
+// ------- lockouts ---------------
+
+func (s *MemoryStore) LockoutOne(kind string, subject string) (res *LoginLockout, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lockout, ok := s.lockouts[lockoutKey{kind: kind, subject: subject}]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	res = lockout.copy()
+	return
+}
+
+func (s *MemoryStore) LockoutAttempt(kind string, subject string, now time.Time, policy *LockoutPolicy) (res *LoginLockout, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lockoutKey{kind: kind, subject: subject}
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		lockout = &LoginLockout{Kind: kind, Subject: subject}
+	}
+	err = lockout.attempt(now, policy)
+	if err != nil {
+		res = lockout.copy()
+		return
+	}
+	s.lockouts[key] = lockout
+	for k, v := range s.lockouts {
+		if v.Stale(now, policy) {
+			delete(s.lockouts, k)
+		}
+	}
+	res = lockout.copy()
+	return
+}
+
+func (s *MemoryStore) LockoutTakeBack(kind string, subject string, at time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lockoutKey{kind: kind, subject: subject}
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	lockout.takeBack(at)
+	if lockout.Failures == 0 {
+		delete(s.lockouts, key)
+	}
+	return
+}
+
+func (s *MemoryStore) LockoutsAll() (res []*LoginLockout, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = make([]*LoginLockout, 0, len(s.lockouts))
+	for _, lockout := range s.lockouts {
+		res = append(res, lockout.copy())
+	}
+	sortLockoutsLatestFirst(res)
+	return
+}
+
+func (s *MemoryStore) LockoutClear(kind string, subject string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lockoutKey{kind: kind, subject: subject}
+	if _, ok := s.lockouts[key]; !ok {
+		err = ErrNotFound
+		return
+	}
+	delete(s.lockouts, key)
+	return
+}
+
+// ------- second factors ---------------
+
+func (s *MemoryStore) TotpOne(userId types.Id) (res *Totp, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totp, ok := s.totpsByUserIds[userId]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	res = totp.copy()
+	return
+}
+
+func (s *MemoryStore) TotpSave(req *Totp) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.userOne(req.UserId); err != nil {
+		return
+	}
+	s.totpsByUserIds[req.UserId] = req.copy()
+	return
+}
+
+func (s *MemoryStore) TotpDelete(userId types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.totpsByUserIds[userId]; !ok {
+		err = ErrNotFound
+		return
+	}
+	delete(s.totpsByUserIds, userId)
+	return
+}
+
+func (s *MemoryStore) TotpUseStep(userId types.Id, step int64) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totpsByUserIds[userId]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	if step <= totp.LastUsedStep {
+		err = ErrTotpCodeUsed
+		return
+	}
+	totp.LastUsedStep = step
+	return
+}
+
+func (s *MemoryStore) TotpUseRecoveryCode(userId types.Id, hash string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totpsByUserIds[userId]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	for i, h := range totp.RecoveryCodeHashes {
+		if h == hash {
+			totp.RecoveryCodeHashes = append(totp.RecoveryCodeHashes[:i:i], totp.RecoveryCodeHashes[i+1:]...)
+			return
+		}
+	}
+	err = ErrNotFound
+	return
+}
+
+func (s *MemoryStore) TotpRequiredRoles() (res []string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = append([]string{}, s.totpRequiredRoles...)
+	return
+}
+
+func (s *MemoryStore) TotpSetRequiredRoles(roles []string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totpRequiredRoles = append([]string{}, roles...)
+	sort.Strings(s.totpRequiredRoles)
+	return
+}
+
+// ------- roles ---------------
+
+func (s *MemoryStore) RolesAll() (res []*Role, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = make([]*Role, 0, len(s.rolesByNames))
+	for _, role := range s.rolesByNames {
+		res = append(res, role.copy())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return
+}
+
+func (s *MemoryStore) RoleOne(name string) (res *Role, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.rolesByNames[name]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	res = role.copy()
+	return
+}
+
+func (s *MemoryStore) RoleSave(req *Role) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	role := req.copy()
+	role.normalize()
+	roles := []*Role{role}
+	for _, r := range s.rolesByNames {
+		if r.Name != role.Name {
+			roles = append(roles, r)
+		}
+	}
+	err = s.checkRoleManagers(s.users(), roles)
+	if err != nil {
+		return
+	}
+	s.rolesByNames[role.Name] = role
+	return
+}
+
+func (s *MemoryStore) RoleDelete(name string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rolesByNames[name]; !ok {
+		err = ErrNotFound
+		return
+	}
+	for _, user := range s.usersByIds {
+		if user.HasRole(name) {
+			err = ErrRoleInUse
+			return
+		}
+	}
+	delete(s.rolesByNames, name)
+	required := []string{}
+	for _, role := range s.totpRequiredRoles {
+		if role != name {
+			required = append(required, role)
+		}
+	}
+	s.totpRequiredRoles = required
+	return
+}
+
+// ------- products ---------------
+
+func (s *MemoryStore) ProductsAll(q string) (res []*Product, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	allProducts := GetMapValuesForProducts(s.productsByIds)
+	if q == "" {
+		res = allProducts
+		return
+	}
+	res = []*Product{}
+	for k, v := range allProducts {
+		if q == v.ProductName {
+			res = append(res, allProducts[k])
+		}
+	}
+	return
+}
+
+func (s *MemoryStore) ProductOne(id types.Id) (res *Product, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, err := s.productOne(id)
+	if err != nil {
+		return
+	}
+	res = product.copy()
+	return
+}
+
+func (s *MemoryStore) ProductUpdate(req *UpdateProductRequest) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, err := s.productOne(req.ID)
+	if err != nil {
+		return
+	}
+	err = recordEvent(s.record, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+	if err != nil {
+		return
+	}
+
+	product.ProductName = req.ProductName
+	product.Cost = req.Cost
+	product.AmountAvailable = req.AmountAvailable
+	return
+}
+
+// ProductSave Internal use only
+func (s *MemoryStore) ProductSave(req *Product) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = recordEvent(s.record, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+	if err != nil {
+		return
+	}
+	s.productsByIds[req.ID] = req.copy()
+	return
+}
+
+func (s *MemoryStore) ProductDelete(id types.Id) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.productsByIds[id]; !ok {
+		err = ErrNotFound
+		return
+	}
+	err = recordEvent(s.record, EventProductDeleted, &ProductDeletedEvent{ProductId: id})
+	if err != nil {
+		return
+	}
+	delete(s.productsByIds, id)
+	return
+}
+
+// GetMapValuesForProducts returns copies of the map values
+func GetMapValuesForProducts(m map[types.Id]*Product) (res []*Product) {
+	res = make([]*Product, len(m))
+	i := 0
+	for _, v := range m {
+		res[i] = v.copy()
+		i++
+	}
+	return res
+}
+
+func (s *MemoryStore) ProductInsert(req *Product) (res *Product, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.ID = types.Id(xid.New().String())
+
+	_, err = s.productOne(req.ID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return
+		} else {
+			err = nil
+		}
+	} else {
+		err = ErrProductIdExists
+		return
+	}
+	err = recordEvent(s.record, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+	if err != nil {
+		return
+	}
+
+	s.productsByIds[req.ID] = req.copy()
+	res = req
+	return
+}
+
+// ------- coins ---------------
+
+func (s *MemoryStore) CoinsAll() (res []*CoinTube, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = CoinTubesFromCounts(s.coins)
+	return
+}
+
+func (s *MemoryStore) CoinsLoad(req []*CoinTube) (res []*CoinTube, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = recordEvent(s.record, EventCoinsLoaded, &CoinsLoadedEvent{Coins: req})
+	if err != nil {
+		return
+	}
+	for _, tube := range req {
+		s.coins[tube.Value] = s.coins[tube.Value] + tube.Count
+	}
+	res = CoinTubesFromCounts(s.coins)
+	return
+}
+
+func (s *MemoryStore) CoinsEmpty(value int) (res []*CoinTube, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = recordEvent(s.record, EventCoinsEmptied, &CoinsEmptiedEvent{Value: value})
+	if err != nil {
+		return
+	}
+	removed := make(map[int]int)
+	for k, count := range s.coins {
+		if value == 0 || value == k {
+			removed[k] = count
+			s.coins[k] = 0
+		}
+	}
+	res = CoinTubesFromCounts(removed)
+	return
+}
+
+// ------- vending ---------------
+
+func (s *MemoryStore) Deposit(buyerId types.Id, coin *Coin) (res *User, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.userOne(buyerId)
+	if err != nil {
+		return
+	}
+	err = recordEvent(s.record, EventDeposit, &DepositEvent{BuyerId: buyerId, Coin: coin.Value})
+	if err != nil {
+		return
+	}
+
+	user.Deposit = user.Deposit + coin.Value
+	user.DepositCoins = CoinTubesFromCounts(AddCoinCounts(CoinCountsFromTubes(user.DepositCoins), map[int]int{coin.Value: 1}))
+	res = user.copy()
+	return
+}
+
+func (s *MemoryStore) Purchase(buyerId types.Id, items []*CartItem, makeChange ChangeMaker) (res *Purchase, err error) {
+	err = validateCart(items)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.userOne(buyerId)
+	if err != nil {
+		return
+	}
+	amounts := cartAmounts(items)
+	products := make(map[types.Id]*Product, len(amounts))
+	for id := range amounts {
+		products[id], err = s.productOne(id)
+		if err != nil {
+			return
+		}
+	}
+
+	res, err = preparePurchase(user, items, products, s.availableCoins(), makeChange)
+	if err == nil {
+		err = recordEvent(s.record, EventPurchase, purchaseEvent(user, items, res))
+	}
+	if err != nil {
+		res = nil
+		return
+	}
+
+	for id, amount := range amounts {
+		products[id].AmountAvailable = products[id].AmountAvailable - amount
+	}
+	s.putCoins(user.DepositCoins)
+	s.takeCoins(res.Change)
+	user.Deposit = 0
+	user.DepositCoins = nil
+	s.ordersByIds[res.Order.ID] = res.Order.copy()
+	s.ledger = append(s.ledger, saleEntries(res.Order)...)
+	return
+}
+
+func (s *MemoryStore) Refund(buyerId types.Id, returnInserted bool, makeChange ChangeMaker) (res []*Coin, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.userOne(buyerId)
+	if err != nil {
+		return
+	}
+
+	res, take, err := prepareRefund(user, s.availableCoins(), returnInserted, makeChange)
+	if err == nil {
+		err = recordEvent(s.record, EventReset, resetEvent(user, returnInserted, take))
+	}
+	if err != nil {
+		res = nil
+		return
+	}
+
+	if !returnInserted {
+		s.putCoins(user.DepositCoins)
+	}
+	s.takeCoins(take)
+	user.Deposit = 0
+	user.DepositCoins = nil
+	return
+}
+
+func (s *MemoryStore) RefundOrder(orderId types.Id, method string, reason string, refundedBy types.Id, makeChange ChangeMaker) (res *OrderRefund, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.ordersByIds[orderId]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	var buyer *User
+	if method == RefundMethodDeposit {
+		buyer, err = s.userOne(order.BuyerId)
+		if err != nil {
+			return
+		}
+	}
+
+	refund, entries, err := prepareOrderRefund(order, method, reason, refundedBy, s.availableCoins(), makeChange)
+	if err != nil {
+		return
+	}
+	err = recordEvent(s.record, EventOrderRefund, orderRefundEvent(order, refund))
+	if err != nil {
+		return
+	}
+
+	for _, line := range order.Lines {
+		// deleted products are not restored
+		if product, err := s.productOne(line.ProductId); err == nil {
+			product.AmountAvailable = product.AmountAvailable + line.AmountOfProducts
+		}
+	}
+	if buyer != nil {
+		buyer.Deposit = buyer.Deposit + refund.Amount
+	}
+	s.takeCoins(refund.Coins)
+	s.ledger = append(s.ledger, entries...)
+	order.RefundId = refund.ID
+	s.orderRefundsByIds[refund.ID] = refund
+	res = refund.copy()
+	return
+}
+
+// ------- orders ---------------
+
+func (s *MemoryStore) OrdersByBuyer(buyerId types.Id) (res []*Order, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*Order{}
+	for _, order := range s.ordersByIds {
+		if order.BuyerId == buyerId {
+			res = append(res, order.copy())
+		}
+	}
+	sortOrdersNewestFirst(res)
+	return
+}
+
+func (s *MemoryStore) OrderOne(id types.Id) (res *Order, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.ordersByIds[id]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	res = order.copy()
+	return
+}
+
+func (s *MemoryStore) OrderRefundOne(id types.Id) (res *OrderRefund, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refund, ok := s.orderRefundsByIds[id]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	res = refund.copy()
+	return
+}
+
+// ------- ledger ---------------
+
+func (s *MemoryStore) LedgerEntries(account string) (res []*LedgerEntry, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*LedgerEntry{}
+	for _, entry := range s.ledger {
+		if entry.DebitAccount == account || entry.CreditAccount == account {
+			e := *entry
+			res = append(res, &e)
+		}
+	}
+	return
+}
+
+func (s *MemoryStore) LedgerBalance(account string) (res int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = ledgerBalances(s.ledger)[account]
+	return
+}
+
+func (s *MemoryStore) Payout(sellerId types.Id, paidBy types.Id) (res []*Payout, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balances := ledgerBalances(s.ledger)
+	accounts := make([]string, 0, len(balances))
+	for account := range balances {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	now := time.Now()
+	res = []*Payout{}
+	for _, account := range accounts {
+		id, ok := sellerIdFromAccount(account)
+		if !ok || balances[account] <= 0 || (sellerId != "" && id != sellerId) {
+			continue
+		}
+		payout, entry := newPayout(id, balances[account], paidBy, now)
+		s.payouts = append(s.payouts, payout)
+		s.ledger = append(s.ledger, entry)
+		p := *payout
+		res = append(res, &p)
+	}
+	return
+}
+
+func (s *MemoryStore) PayoutsAll(sellerId types.Id) (res []*Payout, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*Payout{}
+	for i := len(s.payouts) - 1; i >= 0; i-- {
+		if sellerId == "" || s.payouts[i].SellerId == sellerId {
+			p := *s.payouts[i]
+			res = append(res, &p)
+		}
+	}
+	return
+}
+
+// ------- audit ---------------
+
+func (s *MemoryStore) AuditAppend(req *AuditEntry) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auditLog = append(s.auditLog, req.copy())
+	return
+}
+
+func (s *MemoryStore) AuditQuery(q *AuditQuery) (res []*AuditEntry, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res = []*AuditEntry{}
+	for _, entry := range s.auditLog {
+		if q.matches(entry) {
+			res = append(res, entry.copy())
+		}
+	}
+	return
+}
+
+// ------- idempotency ---------------
+
+func (s *MemoryStore) IdempotencyBegin(req *IdempotencyRecord, notBefore time.Time) (res *IdempotencyRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.idempotencyRecords {
+		if v.CreatedAt.Before(notBefore) {
+			delete(s.idempotencyRecords, k)
+		}
+	}
+
+	k := idempotencyRecordKey{userId: req.UserId, key: req.Key}
+	if record, ok := s.idempotencyRecords[k]; ok {
+		res = record.copy()
+		return
+	}
+	s.idempotencyRecords[k] = req.copy()
+	return
+}
+
+func (s *MemoryStore) IdempotencyComplete(userId types.Id, key string, status int, body []byte) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.idempotencyRecords[idempotencyRecordKey{userId: userId, key: key}]
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	record.Status = status
+	record.Body = append([]byte(nil), body...)
+	return
+}
+
+func (s *MemoryStore) IdempotencyRelease(userId types.Id, key string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotencyRecords, idempotencyRecordKey{userId: userId, key: key})
+	return
+}
+
+// ------- implementation details ---------------
+// the helpers below expect the caller to hold the store lock
+
+func (s *MemoryStore) userOne(id types.Id) (res *User, err error) {
+	res, ok := s.usersByIds[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+func (s *MemoryStore) isUserNameFree(userName string) (res bool) {
+	for k := range s.usersByIds {
+		if s.usersByIds[k].UserName == userName {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteUserSessions drops the sessions of the user matching the filter
+func (s *MemoryStore) deleteUserSessions(userId types.Id, filter func(session *Session) bool) {
+	for id, session := range s.sessionsByIds {
+		if session.UserId == userId && filter(session) {
+			s.deleteSession(id)
+		}
+	}
+}
+
+// deleteSession drops the session with its refresh tokens
+func (s *MemoryStore) deleteSession(id types.Id) {
+	delete(s.sessionsByIds, id)
+	for hash, token := range s.refreshTokensByHashes {
+		if token.SessionId == id {
+			delete(s.refreshTokensByHashes, hash)
+		}
+	}
+}
+
+// checkRoleManagers checks that a change leading to the users and roles keeps a user allowed to manage the roles
+func (s *MemoryStore) checkRoleManagers(users []*User, roles []*Role) (err error) {
+	return checkRoleManagers(s.users(), s.roles(), users, roles)
+}
+
+// users returns the stored users, not copies
+func (s *MemoryStore) users() (res []*User) {
+	res = make([]*User, 0, len(s.usersByIds))
+	for _, user := range s.usersByIds {
+		res = append(res, user)
+	}
+	return
+}
+
+// roles returns the stored roles, not copies
+func (s *MemoryStore) roles() (res []*Role) {
+	res = make([]*Role, 0, len(s.rolesByNames))
+	for _, role := range s.rolesByNames {
+		res = append(res, role)
+	}
+	return
+}
+
+func (s *MemoryStore) productOne(id types.Id) (res *Product, err error) {
+	res, ok := s.productsByIds[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+// availableCoins returns a copy of the coin inventory
+func (s *MemoryStore) availableCoins() (res map[int]int) {
+	res = make(map[int]int, len(s.coins))
+	for k, v := range s.coins {
+		res[k] = v
+	}
+	return
+}
+
+// putCoins adds the coins to the inventory
+func (s *MemoryStore) putCoins(tubes []*CoinTube) {
+	for _, tube := range tubes {
+		s.coins[tube.Value] = s.coins[tube.Value] + tube.Count
+	}
+}
+
+// takeCoins removes the coins from the inventory, they must have been checked to be available
+func (s *MemoryStore) takeCoins(coins []*Coin) {
+	for _, coin := range coins {
+		s.coins[coin.Value]--
+	}
+}
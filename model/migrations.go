@@ -0,0 +1,325 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// migrations are applied in order, each exactly once; never edit an applied one, add a new version instead
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create users and products",
+		Up: `
+CREATE TABLE users (
+	id            TEXT PRIMARY KEY,
+	user_name     TEXT    NOT NULL UNIQUE,
+	password_hash TEXT    NOT NULL,
+	deposit       INTEGER NOT NULL DEFAULT 0,
+	role          TEXT    NOT NULL,
+	token         TEXT    NOT NULL DEFAULT '',
+	token_expires INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE products (
+	id               TEXT PRIMARY KEY,
+	product_name     TEXT    NOT NULL,
+	seller_id        TEXT    NOT NULL,
+	amount_available INTEGER NOT NULL DEFAULT 0,
+	cost             INTEGER NOT NULL
+);
+CREATE INDEX products_product_name ON products (product_name);
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create coin inventory",
+		Up: `
+CREATE TABLE coins (
+	value INTEGER PRIMARY KEY,
+	count INTEGER NOT NULL DEFAULT 0 CHECK (count >= 0)
+);
+`,
+	},
+	{
+		Version: 3,
+		Name:    "track deposit coins",
+		// deposits made before are already in the coin tubes, so they are left without coins
+		Up: `
+ALTER TABLE users ADD COLUMN deposit_coins TEXT NOT NULL DEFAULT '[]';
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create idempotency keys",
+		Up: `
+CREATE TABLE idempotency_keys (
+	user_id     TEXT    NOT NULL,
+	key         TEXT    NOT NULL,
+	fingerprint TEXT    NOT NULL,
+	status      INTEGER NOT NULL DEFAULT 0,
+	body        BLOB    NOT NULL,
+	created_at  INTEGER NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at);
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create orders",
+		Up: `
+CREATE TABLE orders (
+	id           TEXT PRIMARY KEY,
+	buyer_id     TEXT    NOT NULL,
+	total        INTEGER NOT NULL,
+	change_coins TEXT    NOT NULL DEFAULT '[]',
+	created_at   INTEGER NOT NULL
+);
+CREATE INDEX orders_buyer_id ON orders (buyer_id, created_at);
+CREATE TABLE order_lines (
+	order_id     TEXT    NOT NULL REFERENCES orders (id),
+	line_no      INTEGER NOT NULL,
+	product_id   TEXT    NOT NULL,
+	product_name TEXT    NOT NULL,
+	seller_id    TEXT    NOT NULL,
+	cost         INTEGER NOT NULL,
+	amount       INTEGER NOT NULL,
+	total        INTEGER NOT NULL,
+	PRIMARY KEY (order_id, line_no)
+);
+CREATE INDEX order_lines_seller_id ON order_lines (seller_id);
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create seller ledger",
+		// the orders recorded before are credited to their sellers
+		Up: `
+CREATE TABLE ledger_entries (
+	id             TEXT PRIMARY KEY,
+	kind           TEXT    NOT NULL,
+	debit_account  TEXT    NOT NULL,
+	credit_account TEXT    NOT NULL,
+	amount         INTEGER NOT NULL CHECK (amount > 0),
+	order_id       TEXT    NOT NULL DEFAULT '',
+	product_id     TEXT    NOT NULL DEFAULT '',
+	payout_id      TEXT    NOT NULL DEFAULT '',
+	created_at     INTEGER NOT NULL
+);
+CREATE INDEX ledger_entries_debit_account ON ledger_entries (debit_account);
+CREATE INDEX ledger_entries_credit_account ON ledger_entries (credit_account);
+CREATE TABLE payouts (
+	id         TEXT PRIMARY KEY,
+	seller_id  TEXT    NOT NULL,
+	amount     INTEGER NOT NULL,
+	paid_by    TEXT    NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX payouts_seller_id ON payouts (seller_id);
+INSERT INTO ledger_entries (id, kind, debit_account, credit_account, amount, order_id, product_id, created_at)
+SELECT l.order_id || '-' || l.line_no, 'sale', 'machine', 'seller:' || l.seller_id, l.total, l.order_id, l.product_id, o.created_at
+FROM order_lines l JOIN orders o ON o.id = l.order_id
+WHERE l.total > 0;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "create order refunds",
+		Up: `
+CREATE TABLE order_refunds (
+	id          TEXT PRIMARY KEY,
+	order_id    TEXT    NOT NULL UNIQUE REFERENCES orders (id),
+	buyer_id    TEXT    NOT NULL,
+	amount      INTEGER NOT NULL,
+	method      TEXT    NOT NULL,
+	coins       TEXT    NOT NULL DEFAULT '[]',
+	reason      TEXT    NOT NULL DEFAULT '',
+	refunded_by TEXT    NOT NULL,
+	created_at  INTEGER NOT NULL
+);
+ALTER TABLE orders ADD COLUMN refund_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE ledger_entries ADD COLUMN refund_id TEXT NOT NULL DEFAULT '';
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create audit log",
+		// the triggers keep the log append only, whatever the client
+		Up: `
+CREATE TABLE audit_log (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	id          TEXT    NOT NULL UNIQUE,
+	actor_id    TEXT    NOT NULL,
+	actor_role  TEXT    NOT NULL,
+	action      TEXT    NOT NULL,
+	entity_type TEXT    NOT NULL,
+	entity_id   TEXT    NOT NULL,
+	before      TEXT,
+	after       TEXT,
+	created_at  INTEGER NOT NULL
+);
+CREATE INDEX audit_log_actor_id ON audit_log (actor_id, created_at);
+CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id, created_at);
+CREATE INDEX audit_log_created_at ON audit_log (created_at);
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append only');
+END;
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append only');
+END;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "create sessions",
+		// the tokens issued before do not carry the standard claims, so their sessions are not kept
+		Up: `
+CREATE TABLE sessions (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT    NOT NULL,
+	device       TEXT    NOT NULL DEFAULT '',
+	created_at   INTEGER NOT NULL,
+	last_seen_at INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL
+);
+CREATE INDEX sessions_user_id ON sessions (user_id, created_at);
+ALTER TABLE users DROP COLUMN token;
+ALTER TABLE users DROP COLUMN token_expires;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create refresh tokens",
+		Up: `
+CREATE TABLE refresh_tokens (
+	hash       TEXT PRIMARY KEY,
+	session_id TEXT    NOT NULL,
+	created_at INTEGER NOT NULL,
+	used_at    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX refresh_tokens_session_id ON refresh_tokens (session_id);
+`,
+	},
+	{
+		Version: 11,
+		Name:    "create login lockouts",
+		Up: `
+CREATE TABLE login_lockouts (
+	kind            TEXT    NOT NULL,
+	subject         TEXT    NOT NULL,
+	failures        INTEGER NOT NULL,
+	last_failure_at INTEGER NOT NULL,
+	locked_until    INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (kind, subject)
+);
+`,
+	},
+	{
+		Version: 12,
+		Name:    "create second factors",
+		Up: `
+CREATE TABLE totps (
+	user_id              TEXT PRIMARY KEY,
+	secret               BLOB    NOT NULL,
+	confirmed_at         INTEGER NOT NULL DEFAULT 0,
+	recovery_code_hashes TEXT    NOT NULL DEFAULT '[]',
+	last_used_step       INTEGER NOT NULL DEFAULT 0,
+	created_at           INTEGER NOT NULL
+);
+CREATE TABLE totp_required_roles (
+	role TEXT PRIMARY KEY
+);
+`,
+	},
+	{
+		Version: 13,
+		Name:    "create roles and let users hold several",
+		// the roles are the ones of the fixed roles before, see DefaultRoles
+		Up: `
+CREATE TABLE roles (
+	name        TEXT PRIMARY KEY,
+	permissions TEXT    NOT NULL DEFAULT '[]',
+	registrable INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO roles (name, permissions, registrable) VALUES
+	('admin', '["audit:read","earnings:read:any","lockout:manage","machine:manage","payout:write","product:write:any","purchase:read:any","purchase:refund","role:manage","totp:manage","user:read:any","user:write:any"]', 0),
+	('buyer', '["machine:buy","machine:deposit"]', 1),
+	('seller', '["earnings:read:own","product:write:own"]', 1);
+ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT '[]';
+UPDATE users SET roles = json_array(role);
+ALTER TABLE users DROP COLUMN role;
+`,
+	},
+	{
+		Version: 14,
+		Name:    "create event outbox",
+		Up: `
+CREATE TABLE event_outbox (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT    NOT NULL,
+	data TEXT    NOT NULL
+);
+`,
+	},
+}
+
+// migrate applies all pending migrations and returns the resulting schema version
+func migrate(db *sql.DB) (version int, err error) {
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	applied_at INTEGER NOT NULL
+)`)
+	if err != nil {
+		return
+	}
+
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		err = applyMigration(db, m)
+		if err != nil {
+			return
+		}
+		version = m.Version
+	}
+	return
+}
+
+func applyMigration(db *sql.DB, m migration) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(m.Up)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UnixMilli())
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+	"sort"
+	"time"
+)
+
+// Order is the persisted record of a purchase, products are snapshotted as they were at purchase time
+type Order struct {
+	ID        types.Id     `json:"id" example:"xxx"`
+	BuyerId   types.Id     `json:"buyerId" example:"xxx"`
+	Lines     []*OrderLine `json:"lines"`
+	Total     int          `json:"total" example:"5"`
+	Change    []*Coin      `json:"change"`
+	CreatedAt time.Time    `json:"createdAt"`
+	// RefundId links to the refund of the order, if any
+	RefundId types.Id `json:"refundId,omitempty" example:"xxx"`
+}
+
+// OrderLine is one product of an order
+type OrderLine struct {
+	ProductId        types.Id `json:"productId" example:"xxx"`
+	ProductName      string   `json:"productName" example:"product_name"`
+	SellerId         types.Id `json:"sellerId" example:"xxx"`
+	Cost             int      `json:"cost" example:"5"`
+	AmountOfProducts int      `json:"amountOfProducts" example:"1"`
+	Total            int      `json:"total" example:"5"`
+}
+
+// Paid is the deposit the order was paid with
+func (a *Order) Paid() int {
+	res := a.Total
+	for _, coin := range a.Change {
+		res = res + coin.Value
+	}
+	return res
+}
+
+// newOrder records the prepared purchase as a new order
+func newOrder(purchase *Purchase, createdAt time.Time) (res *Order) {
+	res = &Order{
+		ID:        types.Id(xid.New().String()),
+		BuyerId:   purchase.BuyerId,
+		Lines:     make([]*OrderLine, 0, len(purchase.Lines)),
+		Total:     purchase.Total,
+		Change:    purchase.Change,
+		CreatedAt: createdAt,
+	}
+	for _, line := range purchase.Lines {
+		res.Lines = append(res.Lines, &OrderLine{
+			ProductId:        line.Product.ID,
+			ProductName:      line.Product.ProductName,
+			SellerId:         line.Product.SellerId,
+			Cost:             line.Product.Cost,
+			AmountOfProducts: line.Amount,
+			Total:            line.Total,
+		})
+	}
+	return
+}
+
+func (a *Order) copy() *Order {
+	res := *a
+	res.Lines = make([]*OrderLine, len(a.Lines))
+	for i, line := range a.Lines {
+		l := *line
+		res.Lines[i] = &l
+	}
+	res.Change = make([]*Coin, len(a.Change))
+	for i, coin := range a.Change {
+		c := *coin
+		res.Change[i] = &c
+	}
+	return &res
+}
+
+// sortOrdersNewestFirst sorts by creation time, IDs break ties as they are time ordered as well
+func sortOrdersNewestFirst(orders []*Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt)
+		}
+		return orders[i].ID > orders[j].ID
+	})
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+	"time"
+)
+
+const (
+	// RefundMethodDeposit credits the refund to the buyer deposit
+	RefundMethodDeposit = "deposit"
+	// RefundMethodCoins pays the refund out of the coin tubes
+	RefundMethodCoins = "coins"
+)
+
+// OrderRefund is the reversal of a whole order by an admin
+type OrderRefund struct {
+	ID      types.Id `json:"id" example:"xxx"`
+	OrderId types.Id `json:"orderId" example:"xxx"`
+	BuyerId types.Id `json:"buyerId" example:"xxx"`
+	Amount  int      `json:"amount" example:"5"`
+	Method  string   `json:"method" example:"deposit"`
+	// Coins are paid out with the coins method
+	Coins      []*Coin   `json:"coins"`
+	Reason     string    `json:"reason" example:"not dispensed"`
+	RefundedBy types.Id  `json:"refundedBy" example:"xxx"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (a *OrderRefund) copy() *OrderRefund {
+	res := *a
+	res.Coins = make([]*Coin, len(a.Coins))
+	for i, coin := range a.Coins {
+		c := *coin
+		res.Coins[i] = &c
+	}
+	return &res
+}
+
+// IsValidRefundMethod tells if the refund method is supported
+func IsValidRefundMethod(method string) bool {
+	return method == RefundMethodDeposit || method == RefundMethodCoins
+}
+
+// prepareOrderRefund validates the refund of the order and prepares it with the ledger entries debiting the sellers,
+// the coins are made out of the available ones for the coins method. It does not mutate anything.
+func prepareOrderRefund(order *Order, method string, reason string, refundedBy types.Id, available map[int]int, makeChange ChangeMaker) (res *OrderRefund, entries []*LedgerEntry, err error) {
+	if !IsValidRefundMethod(method) {
+		err = ErrInvalidRefundMethod
+		return
+	}
+	if order.RefundId != "" {
+		err = ErrOrderAlreadyRefunded
+		return
+	}
+
+	res = &OrderRefund{
+		ID:         types.Id(xid.New().String()),
+		OrderId:    order.ID,
+		BuyerId:    order.BuyerId,
+		Amount:     order.Total,
+		Method:     method,
+		Reason:     reason,
+		RefundedBy: refundedBy,
+		CreatedAt:  time.Now(),
+	}
+	if method == RefundMethodCoins {
+		res.Coins, err = prepareChange(order.Total, available, makeChange)
+		if err != nil {
+			return
+		}
+	}
+
+	entries = make([]*LedgerEntry, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		entries = append(entries, &LedgerEntry{
+			ID:            types.Id(xid.New().String()),
+			Kind:          LedgerKindRefund,
+			DebitAccount:  SellerAccount(line.SellerId),
+			CreditAccount: LedgerAccountMachine,
+			Amount:        line.Total,
+			OrderId:       order.ID,
+			ProductId:     line.ProductId,
+			RefundId:      res.ID,
+			CreatedAt:     res.CreatedAt,
+		})
+	}
+	return
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+	// passwordHashSha256 is the unsalted hash of the first versions, only verified and replaced on login
+	passwordHashSha256 = "sha256"
+)
+
+const (
+	// Argon2idDefaultTime, Argon2idMemory and Argon2idThreads are the OWASP recommended parameters,
+	// the time is the configurable cost
+	Argon2idDefaultTime = 2
+	Argon2idMemory      = 19 * 1024
+	Argon2idThreads     = 1
+	argon2idSaltLength  = 16
+	argon2idKeyLength   = 32
+)
+
+var (
+	ErrInvalidPasswordHash     = errors.New("unsupported password hashing")
+	ErrInvalidPasswordHashCost = errors.New("invalid password hashing cost")
+	ErrPasswordTooLong         = errors.New("the password is longer than 72 bytes")
+)
+
+// PasswordHasher makes the stored hashes of the passwords
+type PasswordHasher interface {
+	Name() string
+	Hash(password string) (res string, err error)
+	// Verify checks the password against a hash made by any of the supported algorithms, the old SHA-256 hashes
+	// included. rehash tells that the hash is not the one the hasher makes, so it should be replaced.
+	Verify(hash string, password string) (ok bool, rehash bool)
+}
+
+// PasswordHasherByName returns the hasher of the given algorithm, a zero cost is the default of the algorithm
+func PasswordHasherByName(name string, cost int) (res PasswordHasher, err error) {
+	switch name {
+	case PasswordHashBcrypt:
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			err = ErrInvalidPasswordHashCost
+			return
+		}
+		res = BcryptPasswordHasher{Cost: cost}
+	case PasswordHashArgon2id:
+		if cost == 0 {
+			cost = Argon2idDefaultTime
+		}
+		if cost < 1 {
+			err = ErrInvalidPasswordHashCost
+			return
+		}
+		res = Argon2idPasswordHasher{Time: uint32(cost)}
+	default:
+		err = ErrInvalidPasswordHash
+	}
+	return
+}
+
+// BcryptPasswordHasher hashes with bcrypt, the cost is the log2 of the rounds
+type BcryptPasswordHasher struct {
+	Cost int
+}
+
+func (a BcryptPasswordHasher) Name() string {
+	return PasswordHashBcrypt
+}
+
+func (a BcryptPasswordHasher) Hash(password string) (res string, err error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), a.Cost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		err = ErrPasswordTooLong
+		return
+	}
+	if err != nil {
+		return
+	}
+	res = string(b)
+	return
+}
+
+func (a BcryptPasswordHasher) Verify(hash string, password string) (ok bool, rehash bool) {
+	ok, params := verifyPassword(hash, password)
+	rehash = ok && params != (passwordHashParams{algorithm: PasswordHashBcrypt, cost: a.Cost})
+	return
+}
+
+// Argon2idPasswordHasher hashes with argon2id, the time is the number of passes over the memory
+type Argon2idPasswordHasher struct {
+	Time uint32
+}
+
+func (a Argon2idPasswordHasher) Name() string {
+	return PasswordHashArgon2id
+}
+
+func (a Argon2idPasswordHasher) Hash(password string) (res string, err error) {
+	salt := make([]byte, argon2idSaltLength)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, Argon2idMemory, Argon2idThreads, argon2idKeyLength)
+	res = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, Argon2idMemory, a.Time, Argon2idThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return
+}
+
+func (a Argon2idPasswordHasher) Verify(hash string, password string) (ok bool, rehash bool) {
+	ok, params := verifyPassword(hash, password)
+	rehash = ok && params != (passwordHashParams{algorithm: PasswordHashArgon2id, cost: int(a.Time),
+		memory: Argon2idMemory, threads: Argon2idThreads})
+	return
+}
+
+// dummyPasswordHashes are hashes of no password of a user by hasher, see verifyUnknownUser
+var dummyPasswordHashes sync.Map
+
+// verifyUnknownUser verifies the password against a dummy hash made by the hasher, so a login of an unknown user name
+// takes as long as a login with a wrong password and the response time does not tell which user names exist
+func verifyUnknownUser(hasher PasswordHasher, password string) {
+	hash, ok := dummyPasswordHashes.Load(hasher)
+	if !ok {
+		dummy, err := hasher.Hash("no password of a user")
+		if err != nil {
+			return
+		}
+		hash, _ = dummyPasswordHashes.LoadOrStore(hasher, dummy)
+	}
+	hasher.Verify(hash.(string), password)
+}
+
+// passwordHashParams tell how a hash was made
+type passwordHashParams struct {
+	algorithm string
+	cost      int
+	memory    uint32
+	threads   uint8
+}
+
+// verifyPassword checks the password against a hash of any supported algorithm and returns how it was made
+func verifyPassword(hash string, password string) (ok bool, res passwordHashParams) {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return
+		}
+		res = passwordHashParams{algorithm: PasswordHashBcrypt, cost: cost}
+		ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		var version int
+		var salt, key string
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 {
+			return
+		}
+		_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+		if err != nil || version != argon2.Version {
+			return
+		}
+		res.algorithm = PasswordHashArgon2id
+		_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &res.memory, &res.cost, &res.threads)
+		if err != nil || res.cost < 1 || res.threads < 1 {
+			return
+		}
+		salt, key = parts[4], parts[5]
+		saltBytes, err := base64.RawStdEncoding.DecodeString(salt)
+		if err != nil {
+			return
+		}
+		keyBytes, err := base64.RawStdEncoding.DecodeString(key)
+		if err != nil {
+			return
+		}
+		actual := argon2.IDKey([]byte(password), saltBytes, uint32(res.cost), res.memory, res.threads, uint32(len(keyBytes)))
+		ok = subtle.ConstantTimeCompare(actual, keyBytes) == 1
+	case len(hash) == sha256.Size*2:
+		res = passwordHashParams{algorithm: passwordHashSha256}
+		actual := sha256.Sum256([]byte(password))
+		ok = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(actual[:])), []byte(hash)) == 1
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ValidationRuleRequired = "required"
+	ValidationRuleAllowed  = "allowed"
+
+	PasswordRuleMinLength = "min-length"
+	PasswordRuleMaxLength = "max-length"
+	PasswordRuleClasses   = "character-classes"
+	PasswordRuleUserName  = "user-name"
+	PasswordRuleCommon    = "common"
+)
+
+// PasswordMaxLength is the bcrypt limit in bytes, applied with any hashing, so the hashing can be switched
+const PasswordMaxLength = 72
+
+// passwordClasses is the number of character classes: lower case and upper case letters, digits and the others
+const passwordClasses = 4
+
+var (
+	ErrInvalidPasswordPolicy = errors.New("password policy should have a min length of 1 to 72 and 0 to 4 character classes")
+	ErrPasswordIsUserName    = errors.New("the password should not be the user name")
+	ErrPasswordCommon        = errors.New("the password is too common")
+	ErrPasswordTooShort      = errors.New("the password is too short")
+	ErrPasswordTooFewClasses = errors.New("the password has too few character classes")
+)
+
+//go:embed commonPasswords.txt
+var commonPasswordsText string
+
+// commonPasswords are the bundled common passwords, in lower case
+var commonPasswords = func() (res map[string]struct{}) {
+	res = make(map[string]struct{})
+	for _, line := range strings.Split(commonPasswordsText, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			res[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return
+}()
+
+// PasswordPolicy decides which passwords users can set
+type PasswordPolicy struct {
+	// MinLength is in characters
+	MinLength int
+	// MinClasses is how many of lower case letters, upper case letters, digits and other characters are required
+	MinClasses int
+	// RejectCommon rejects the bundled common passwords, ignoring the case
+	RejectCommon bool
+}
+
+// Validation checks the policy itself
+func (a *PasswordPolicy) Validation() (err error) {
+	if a.MinLength < 1 || a.MinLength > PasswordMaxLength || a.MinClasses < 0 || a.MinClasses > passwordClasses {
+		err = ErrInvalidPasswordPolicy
+		return
+	}
+	return
+}
+
+// check adds every rule the password of the user violates to res
+func (a *PasswordPolicy) check(password string, userName string, res *ValidationError) {
+	const field = "password"
+	if utf8.RuneCountInString(password) < a.MinLength {
+		res.add(field, PasswordRuleMinLength,
+			fmt.Errorf("%w, it should be at least %d characters long", ErrPasswordTooShort, a.MinLength))
+	}
+	if len(password) > PasswordMaxLength {
+		res.add(field, PasswordRuleMaxLength, ErrPasswordTooLong)
+	}
+	if classes := countPasswordClasses(password); classes < a.MinClasses {
+		res.add(field, PasswordRuleClasses, fmt.Errorf("%w, it should have at least %d of lower case letters, "+
+			"upper case letters, digits and other characters", ErrPasswordTooFewClasses, a.MinClasses))
+	}
+	if userName != "" && strings.EqualFold(password, userName) {
+		res.add(field, PasswordRuleUserName, ErrPasswordIsUserName)
+	}
+	if a.RejectCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			res.add(field, PasswordRuleCommon, ErrPasswordCommon)
+		}
+	}
+}
+
+func countPasswordClasses(password string) (res int) {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			res++
+		}
+	}
+	return
+}
@@ -0,0 +1,8 @@
+package model
+
+import "github.com/oltur/mvp-match/types"
+
+type PayoutRequest struct {
+	// SellerId selects the seller to settle, all sellers are settled if it is empty
+	SellerId types.Id `json:"sellerId" example:"xxx"`
+}
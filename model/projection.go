@@ -0,0 +1,300 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/oltur/mvp-match/types"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DiscrepancyDeposit = "deposit"
+	DiscrepancyStock   = "stock"
+	DiscrepancyCoins   = "coins"
+)
+
+// MachineState is the projection of the events: the user deposits, the product stock and the coin tubes
+type MachineState struct {
+	// Seq is the last applied event, At its time
+	Seq      int64            `json:"seq" example:"1"`
+	At       time.Time        `json:"at"`
+	Deposits map[types.Id]int `json:"deposits"`
+	Stock    map[types.Id]int `json:"stock"`
+	// Coins are the coin counts by value
+	Coins map[int]int `json:"coins"`
+}
+
+// Discrepancy is a difference between the state replayed from the events and the stored one
+type Discrepancy struct {
+	Kind string `json:"kind" example:"stock"`
+	// Id is the user, the product or the coin value
+	Id       string `json:"id" example:"xxx"`
+	Expected int    `json:"expected" example:"5"`
+	Actual   int    `json:"actual" example:"5"`
+}
+
+// NewMachineState creates an empty state
+func NewMachineState() *MachineState {
+	return &MachineState{
+		Deposits: make(map[types.Id]int),
+		Stock:    make(map[types.Id]int),
+		Coins:    make(map[int]int),
+	}
+}
+
+// Replay rebuilds the state from the events up to the given time, inclusive, or from all of them for the zero time
+func Replay(events []*Event, at time.Time) (res *MachineState, err error) {
+	res = NewMachineState()
+	for _, event := range events {
+		if !at.IsZero() && event.At.After(at) {
+			break
+		}
+		err = res.Apply(event)
+		if err != nil {
+			res = nil
+			return
+		}
+	}
+	return
+}
+
+// Apply updates the state with the event, a snapshot replaces it
+func (a *MachineState) Apply(event *Event) (err error) {
+	switch event.Type {
+	case EventSnapshot:
+		snapshot := NewMachineState()
+		err = json.Unmarshal(event.Data, snapshot)
+		if err != nil {
+			break
+		}
+		a.Deposits, a.Stock, a.Coins = snapshot.Deposits, snapshot.Stock, snapshot.Coins
+	case EventDeposit:
+		data := &DepositEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		a.Deposits[data.BuyerId] = a.Deposits[data.BuyerId] + data.Coin
+	case EventPurchase:
+		data := &PurchaseEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		delete(a.Deposits, data.BuyerId)
+		for _, item := range data.Items {
+			a.Stock[item.ProductId] = a.Stock[item.ProductId] - item.AmountOfProducts
+		}
+		a.moveCoins(data.Inserted, data.Change)
+	case EventReset:
+		data := &ResetEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		delete(a.Deposits, data.BuyerId)
+		a.moveCoins(data.Inserted, data.Paid)
+	case EventOrderRefund:
+		data := &OrderRefundEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		for _, item := range data.Items {
+			// deleted products are not restored
+			if stock, ok := a.Stock[item.ProductId]; ok {
+				a.Stock[item.ProductId] = stock + item.AmountOfProducts
+			}
+		}
+		if data.Method == RefundMethodDeposit {
+			a.Deposits[data.BuyerId] = a.Deposits[data.BuyerId] + data.Amount
+		}
+		a.moveCoins(nil, data.Coins)
+	case EventStock:
+		data := &StockEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		a.Stock[data.ProductId] = data.AmountAvailable
+	case EventProductDeleted:
+		data := &ProductDeletedEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		delete(a.Stock, data.ProductId)
+	case EventCoinsLoaded:
+		data := &CoinsLoadedEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		a.moveCoins(data.Coins, nil)
+	case EventCoinsEmptied:
+		data := &CoinsEmptiedEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		for value := range a.Coins {
+			if data.Value == 0 || data.Value == value {
+				delete(a.Coins, value)
+			}
+		}
+	case EventDepositCleared, EventUserDeleted:
+		data := &UserEvent{}
+		err = json.Unmarshal(event.Data, data)
+		if err != nil {
+			break
+		}
+		delete(a.Deposits, data.UserId)
+	default:
+		err = fmt.Errorf("%w: unknown event type %q", ErrCorruptEventLog, event.Type)
+	}
+	if err != nil {
+		return
+	}
+	a.Seq = event.Seq
+	a.At = event.At
+	return
+}
+
+// Reconcile compares the state with the stored deposits, stock and coins and returns the differences
+func Reconcile(state *MachineState, store Store) (res []*Discrepancy, err error) {
+	users, err := store.UsersAll("")
+	if err != nil {
+		return
+	}
+	deposits := make(map[types.Id]int, len(users))
+	for _, user := range users {
+		deposits[user.ID] = user.Deposit
+	}
+	products, err := store.ProductsAll("")
+	if err != nil {
+		return
+	}
+	stock := make(map[types.Id]int, len(products))
+	for _, product := range products {
+		stock[product.ID] = product.AmountAvailable
+	}
+	tubes, err := store.CoinsAll()
+	if err != nil {
+		return
+	}
+
+	res = []*Discrepancy{}
+	res = append(res, discrepancies(DiscrepancyDeposit, state.Deposits, deposits)...)
+	res = append(res, discrepancies(DiscrepancyStock, state.Stock, stock)...)
+	res = append(res, discrepancies(DiscrepancyCoins, coinCountsById(state.Coins), coinCountsById(CoinCountsFromTubes(tubes)))...)
+	return
+}
+
+// snapshotState builds the state of the stored deposits, stock and coins
+func snapshotState(store Store) (res *MachineState, err error) {
+	users, err := store.UsersAll("")
+	if err != nil {
+		return
+	}
+	products, err := store.ProductsAll("")
+	if err != nil {
+		return
+	}
+	tubes, err := store.CoinsAll()
+	if err != nil {
+		return
+	}
+
+	res = NewMachineState()
+	for _, user := range users {
+		if user.Deposit != 0 {
+			res.Deposits[user.ID] = user.Deposit
+		}
+	}
+	for _, product := range products {
+		res.Stock[product.ID] = product.AmountAvailable
+	}
+	res.Coins = CoinCountsFromTubes(tubes)
+	return
+}
+
+// restoreState sets the deposits, the stock and the coins of the store to the ones of the state. The deposits are
+// restored as amounts, without the inserted coins, users of the state missing in the store are left out and products
+// missing in the state are deleted.
+func restoreState(state *MachineState, store Store) (err error) {
+	users, err := store.UsersAll("")
+	if err != nil {
+		return
+	}
+	for _, user := range users {
+		deposit := state.Deposits[user.ID]
+		if user.Deposit == deposit {
+			continue
+		}
+		user.Deposit = deposit
+		user.DepositCoins = nil
+		err = store.UserSave(user)
+		if err != nil {
+			return
+		}
+	}
+	products, err := store.ProductsAll("")
+	if err != nil {
+		return
+	}
+	for _, product := range products {
+		stock, ok := state.Stock[product.ID]
+		if !ok {
+			err = store.ProductDelete(product.ID)
+		} else if product.AmountAvailable != stock {
+			product.AmountAvailable = stock
+			err = store.ProductSave(product)
+		}
+		if err != nil {
+			return
+		}
+	}
+	_, err = store.CoinsEmpty(0)
+	if err != nil {
+		return
+	}
+	_, err = store.CoinsLoad(CoinTubesFromCounts(state.Coins))
+	return
+}
+
+// moveCoins adds the coins put into the tubes and removes the ones taken out of them
+func (a *MachineState) moveCoins(put []*CoinTube, take []*Coin) {
+	for _, tube := range put {
+		a.Coins[tube.Value] = a.Coins[tube.Value] + tube.Count
+	}
+	for _, coin := range take {
+		a.Coins[coin.Value]--
+	}
+}
+
+// discrepancies compares the expected and the actual values by id, missing values count as 0
+func discrepancies(kind string, expected map[types.Id]int, actual map[types.Id]int) (res []*Discrepancy) {
+	res = []*Discrepancy{}
+	for id, value := range expected {
+		if actual[id] != value {
+			res = append(res, &Discrepancy{Kind: kind, Id: string(id), Expected: value, Actual: actual[id]})
+		}
+	}
+	for id, value := range actual {
+		if _, ok := expected[id]; !ok && value != 0 {
+			res = append(res, &Discrepancy{Kind: kind, Id: string(id), Expected: 0, Actual: value})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return
+}
+
+func coinCountsById(counts map[int]int) (res map[types.Id]int) {
+	res = make(map[types.Id]int, len(counts))
+	for value, count := range counts {
+		res[types.Id(strconv.Itoa(value))] = count
+	}
+	return
+}
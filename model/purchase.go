@@ -0,0 +1,139 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+// ChangeMaker splits the change amount into coins taken from the available ones, which are given as counts by
+// coin value. ErrCannotMakeChange is returned if the available coins do not allow it.
+type ChangeMaker func(totalChange int, available map[int]int) (res []*Coin, err error)
+
+// CartItem is one product line of a purchase
+type CartItem struct {
+	ProductId        types.Id `json:"productId" example:"xxx"`
+	AmountOfProducts int      `json:"amountOfProducts" example:"1"`
+}
+
+// PurchaseLine is one bought product line
+type PurchaseLine struct {
+	// Product is the product state right after the purchase
+	Product *Product
+	Amount  int
+	Total   int
+}
+
+// Purchase is the outcome of a successful Buy or Checkout
+type Purchase struct {
+	BuyerId types.Id
+	Lines   []*PurchaseLine
+	Total   int
+	Change  []*Coin
+	// Order is the record of the purchase, persisted with it
+	Order *Order
+}
+
+// validateCart checks the cart is not empty and every amount is positive
+func validateCart(items []*CartItem) (err error) {
+	if len(items) == 0 {
+		err = ErrEmptyCart
+		return
+	}
+	for _, item := range items {
+		if item.AmountOfProducts <= 0 {
+			err = ErrInvalidAmountOfProducts
+			return
+		}
+	}
+	return
+}
+
+// cartAmounts sums the amounts by product, as a product may appear in several items
+func cartAmounts(items []*CartItem) (res map[types.Id]int) {
+	res = make(map[types.Id]int, len(items))
+	for _, item := range items {
+		res[item.ProductId] = res[item.ProductId] + item.AmountOfProducts
+	}
+	return
+}
+
+// prepareRefund prepares the coins returned for the whole user deposit. With returnInserted the inserted coins
+// themselves are returned, and only the part of the deposit not backed by known coins is paid out of the tubes.
+// Otherwise the inserted coins join the tubes and the whole deposit is paid as change.
+// It returns the coins to take out of the tubes, already including the inserted ones.
+func prepareRefund(user *User, tubes map[int]int, returnInserted bool, makeChange ChangeMaker) (res []*Coin, take []*Coin, err error) {
+	inserted := CoinCountsFromTubes(user.DepositCoins)
+	if returnInserted {
+		var change []*Coin
+		change, err = prepareChange(user.Deposit-SumCoinCounts(inserted), tubes, makeChange)
+		if err != nil {
+			return
+		}
+		res = append(CoinsFromCounts(inserted), change...)
+		take = change
+		return
+	}
+
+	res, err = prepareChange(user.Deposit, AddCoinCounts(tubes, inserted), makeChange)
+	if err != nil {
+		return
+	}
+	take = res
+	return
+}
+
+// preparePurchase validates the purchase against the current user and product state and prepares the change
+// out of the available tube coins and the inserted ones, it does not mutate anything.
+// products must hold every product of the cart.
+func preparePurchase(user *User, items []*CartItem, products map[types.Id]*Product, available map[int]int, makeChange ChangeMaker) (res *Purchase, err error) {
+	amounts := cartAmounts(items)
+	res = &Purchase{BuyerId: user.ID, Lines: make([]*PurchaseLine, 0, len(items))}
+	for _, item := range items {
+		product := products[item.ProductId].copy()
+		product.AmountAvailable = product.AmountAvailable - amounts[item.ProductId]
+		line := &PurchaseLine{Product: product, Amount: item.AmountOfProducts, Total: product.Cost * item.AmountOfProducts}
+		res.Lines = append(res.Lines, line)
+		res.Total = res.Total + line.Total
+	}
+
+	if user.Deposit < res.Total {
+		err = ErrNotEnoughDeposit
+		return
+	}
+
+	for id, amount := range amounts {
+		if products[id].AmountAvailable < amount {
+			err = ErrNotEnoughAmount
+			return
+		}
+	}
+
+	// the inserted coins can be paid back as change right away
+	res.Change, err = prepareChange(user.Deposit-res.Total, AddCoinCounts(available, CoinCountsFromTubes(user.DepositCoins)), makeChange)
+	if err != nil {
+		return
+	}
+	res.Order = newOrder(res, time.Now())
+	return
+}
+
+// prepareChange makes the change and double-checks that it is covered by the available coins
+func prepareChange(totalChange int, available map[int]int, makeChange ChangeMaker) (res []*Coin, err error) {
+	res, err = makeChange(totalChange, available)
+	if err != nil {
+		return
+	}
+	sum := 0
+	for value, count := range CountCoins(res) {
+		if available[value] < count {
+			err = ErrCannotMakeChange
+			return
+		}
+		sum = sum + value*count
+	}
+	if sum != totalChange {
+		err = ErrCannotMakeChange
+		return
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"fmt"
+	"github.com/oltur/mvp-match/types"
+	"strings"
+	"time"
+)
+
+const (
+	ReceiptFormatText = "text"
+	ReceiptFormatJson = "json"
+)
+
+const (
+	receiptLineFormat = "%-24s %12s %8d\n"
+	receiptWidth      = 24 + 1 + 12 + 1 + 8
+)
+
+// Receipt is the printable rendering of an order
+type Receipt struct {
+	OrderId   types.Id     `json:"orderId" example:"xxx"`
+	BuyerId   types.Id     `json:"buyerId" example:"xxx"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Lines     []*OrderLine `json:"lines"`
+	Total     int          `json:"total" example:"5"`
+	Paid      int          `json:"paid" example:"5"`
+	Change    []*Coin      `json:"change"`
+	Currency  string       `json:"currency" example:"EUR"`
+	RefundId  types.Id     `json:"refundId,omitempty" example:"xxx"`
+}
+
+// NewReceipt renders the order in the given currency
+func NewReceipt(order *Order, currencyCode string) *Receipt {
+	return &Receipt{
+		OrderId:   order.ID,
+		BuyerId:   order.BuyerId,
+		CreatedAt: order.CreatedAt,
+		Lines:     order.Lines,
+		Total:     order.Total,
+		Paid:      order.Paid(),
+		Change:    order.Change,
+		Currency:  currencyCode,
+		RefundId:  order.RefundId,
+	}
+}
+
+// Text renders the receipt as plain text, amounts are in the smallest currency unit
+func (a *Receipt) Text() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Receipt %s\n", a.OrderId)
+	fmt.Fprintf(sb, "Date: %s\n", a.CreatedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(sb, "Buyer: %s\n\n", a.BuyerId)
+
+	for _, line := range a.Lines {
+		fmt.Fprintf(sb, receiptLineFormat, line.ProductName, fmt.Sprintf("%d x %d", line.AmountOfProducts, line.Cost), line.Total)
+	}
+	fmt.Fprintf(sb, "%s\n", strings.Repeat("-", receiptWidth))
+	fmt.Fprintf(sb, receiptLineFormat, "Total", "", a.Total)
+	fmt.Fprintf(sb, receiptLineFormat, "Paid", "", a.Paid)
+	fmt.Fprintf(sb, receiptLineFormat, "Change", formatCoins(a.Change), a.Paid-a.Total)
+	if a.RefundId != "" {
+		fmt.Fprintf(sb, "\nRefunded, refund %s\n", a.RefundId)
+	}
+	fmt.Fprintf(sb, "\nAmounts in %s, smallest unit\n", a.Currency)
+	return sb.String()
+}
+
+// formatCoins lists the coin values, e.g. "50+20"
+func formatCoins(coins []*Coin) string {
+	values := make([]string, 0, len(coins))
+	for _, coin := range coins {
+		values = append(values, fmt.Sprint(coin.Value))
+	}
+	return strings.Join(values, "+")
+}
@@ -0,0 +1,6 @@
+package model
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes are shown once, each one replaces a one-time password once
+	RecoveryCodes []string `json:"recoveryCodes" example:"abcde-fghij"`
+}
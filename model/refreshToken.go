@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+// RefreshToken is a single-use token exchanging for new tokens of its session. Every exchange gives a new refresh
+// token, so the tokens of a session form a family, and the reuse of an exchanged one revokes the whole session.
+type RefreshToken struct {
+	// Hash is the hash of the token, the token itself is never stored
+	Hash      string
+	SessionId types.Id
+	CreatedAt time.Time
+	// UsedAt is the time of the exchange, zero if the token was not exchanged yet
+	UsedAt time.Time
+}
+
+func (a *RefreshToken) copy() *RefreshToken {
+	res := *a
+	return &res
+}
+
+// HashRefreshToken is the stored hash of the refresh token
+func HashRefreshToken(token string) string {
+	return tools.Hash(token)
+}
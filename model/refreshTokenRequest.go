@@ -0,0 +1,13 @@
+package model
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (a RefreshTokenRequest) Validation() (err error) {
+	if a.RefreshToken == "" {
+		err = ErrInvalidRefreshToken
+		return
+	}
+	return
+}
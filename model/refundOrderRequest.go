@@ -0,0 +1,15 @@
+package model
+
+type RefundOrderRequest struct {
+	// Method is "deposit" or "coins"
+	Method string `json:"method" example:"deposit"`
+	Reason string `json:"reason" example:"not dispensed"`
+}
+
+func (a RefundOrderRequest) Validation() (err error) {
+	if !IsValidRefundMethod(a.Method) {
+		err = ErrInvalidRefundMethod
+		return
+	}
+	return
+}
@@ -0,0 +1,25 @@
+package model
+
+import "errors"
+
+const (
+	// RefundPolicyChange pays refunds as change, with the machine change strategy
+	RefundPolicyChange = "change"
+	// RefundPolicyExactCoins gives back the coins inserted by the user
+	RefundPolicyExactCoins = "exact-coins"
+)
+
+var ErrInvalidRefundPolicy = errors.New("unsupported refund policy")
+
+// ReturnsInsertedCoins tells if refunds give back the inserted coins under the given policy
+func ReturnsInsertedCoins(refundPolicy string) (res bool, err error) {
+	switch refundPolicy {
+	case RefundPolicyChange:
+		res = false
+	case RefundPolicyExactCoins:
+		res = true
+	default:
+		err = ErrInvalidRefundPolicy
+	}
+	return
+}
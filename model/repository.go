@@ -0,0 +1,215 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"time"
+)
+
+// UserRepository is a storage backend for users, implementations must be safe for concurrent use
+type UserRepository interface {
+	UsersAll(q string) (res []*User, err error)
+	UserOne(id types.Id) (res *User, err error)
+	UserInsert(req *User) (res *User, err error)
+	// UserUpdate sets the password hashed by the hasher
+	UserUpdate(req *UpdateUserRequest, hasher PasswordHasher) (err error)
+	// UserSave Internal use only
+	UserSave(req *User) (err error)
+	// UserDelete removes the user, ErrNoRoleManager if no other user is allowed to manage the roles
+	UserDelete(id types.Id) (err error)
+	UserResetDeposit(id types.Id) (err error)
+	IsUserNameFree(userName string) (res bool, err error)
+	// GetUserByCredentials returns the user if the password matches, ErrNotFound otherwise. A password hash the
+	// hasher would not make, e.g. an old SHA-256 one, is replaced by a new one.
+	GetUserByCredentials(userName string, password string, hasher PasswordHasher) (res *User, err error)
+	// UserLogout ends all sessions of the user
+	UserLogout(id types.Id) (err error)
+	// UserSetRoles replaces the roles of the user, ErrNotFound if there is no such user, ErrNoRoleManager if the
+	// change takes the role:manage permission from the last users having it
+	UserSetRoles(id types.Id, roles []string) (err error)
+}
+
+// SessionRepository is a storage backend for the login sessions and their refresh tokens,
+// implementations must be safe for concurrent use
+type SessionRepository interface {
+	// SessionInsert stores a new session with its first refresh token and drops the expired sessions of the user
+	SessionInsert(req *Session, refreshToken *RefreshToken) (err error)
+	// SessionsByUser returns the unexpired sessions of the user, the newest first
+	SessionsByUser(userId types.Id) (res []*Session, err error)
+	// SessionTouch sets the last seen time of an unexpired session and returns it, ErrNotFound if there is none
+	SessionTouch(id types.Id, now time.Time) (res *Session, err error)
+	// SessionDelete ends the session, its refresh tokens are dropped
+	SessionDelete(id types.Id) (err error)
+	// SessionRefresh exchanges the refresh token of the given hash for the next one, which is put into the same
+	// session, and touches the session, as one all-or-nothing operation. ErrNotFound is returned for an unknown token
+	// or an ended session.
+	// An exchanged token is a reuse: the session is ended and returned with ErrRefreshTokenReused.
+	SessionRefresh(hash string, next *RefreshToken, now time.Time) (res *Session, err error)
+}
+
+// LockoutRepository tracks the failed logins of the accounts and the client IPs,
+// implementations must be safe for concurrent use
+type LockoutRepository interface {
+	// LockoutOne returns the failed logins of the account or IP, ErrNotFound if there are none
+	LockoutOne(kind string, subject string) (res *LoginLockout, err error)
+	// LockoutAttempt counts a login attempt of the account or IP as failed, before the password or code is verified,
+	// locking it out as the policy says, and drops the stale records. A locked out account or IP is returned with
+	// ErrLoginLocked and the attempt is not counted.
+	LockoutAttempt(kind string, subject string, now time.Time, policy *LockoutPolicy) (res *LoginLockout, err error)
+	// LockoutTakeBack undoes the failure counted by the attempt at the given time, for an attempt which did not fail,
+	// ErrNotFound if there are no failed logins
+	LockoutTakeBack(kind string, subject string, at time.Time) (err error)
+	// LockoutsAll returns the records, the latest failure first
+	LockoutsAll() (res []*LoginLockout, err error)
+	// LockoutClear forgets the failed logins of the account or IP, ErrNotFound if there are none
+	LockoutClear(kind string, subject string) (err error)
+}
+
+// TotpRepository stores the second factors of the users and the roles required to use one,
+// implementations must be safe for concurrent use
+type TotpRepository interface {
+	// TotpOne returns the second factor of the user, ErrNotFound if there is none
+	TotpOne(userId types.Id) (res *Totp, err error)
+	// TotpSave stores the second factor of the user, replacing the one before
+	TotpSave(req *Totp) (err error)
+	// TotpDelete removes the second factor of the user, ErrNotFound if there is none
+	TotpDelete(userId types.Id) (err error)
+	// TotpUseStep records the use of a code of the time step, ErrTotpCodeUsed if the step or a later one was used,
+	// so a code works once
+	TotpUseStep(userId types.Id, step int64) (err error)
+	// TotpUseRecoveryCode removes the recovery code of the hash, ErrNotFound if the user has no such code
+	TotpUseRecoveryCode(userId types.Id, hash string) (err error)
+	// TotpRequiredRoles returns the roles whose users must use a second factor, sorted
+	TotpRequiredRoles() (res []string, err error)
+	// TotpSetRequiredRoles replaces the roles whose users must use a second factor
+	TotpSetRequiredRoles(roles []string) (err error)
+}
+
+// RoleRepository stores the roles and their permissions, implementations must be safe for concurrent use
+type RoleRepository interface {
+	// RolesAll returns all roles, sorted by name
+	RolesAll() (res []*Role, err error)
+	// RoleOne returns the role of the name, ErrNotFound if there is none
+	RoleOne(name string) (res *Role, err error)
+	// RoleSave adds the role or replaces the one of its name, ErrNoRoleManager if the change takes the role:manage
+	// permission from the last users having it
+	RoleSave(req *Role) (err error)
+	// RoleDelete removes the role, also from the roles requiring a second factor. ErrNotFound if there is no such
+	// role, ErrRoleInUse if a user holds it.
+	RoleDelete(name string) (err error)
+}
+
+// ProductRepository is a storage backend for products, implementations must be safe for concurrent use
+type ProductRepository interface {
+	ProductsAll(q string) (res []*Product, err error)
+	ProductOne(id types.Id) (res *Product, err error)
+	ProductInsert(req *Product) (res *Product, err error)
+	ProductUpdate(req *UpdateProductRequest) (err error)
+	// ProductSave Internal use only
+	ProductSave(req *Product) (err error)
+	ProductDelete(id types.Id) (err error)
+}
+
+// CoinRepository is a storage backend for the machine coin inventory, implementations must be safe for concurrent use
+type CoinRepository interface {
+	// CoinsAll returns the coin tubes ordered by descending coin value
+	CoinsAll() (res []*CoinTube, err error)
+	// CoinsLoad adds the given coins to the tubes and returns the resulting inventory
+	CoinsLoad(req []*CoinTube) (res []*CoinTube, err error)
+	// CoinsEmpty empties the tube of the given coin value, or all tubes if value is 0, and returns the removed coins
+	CoinsEmpty(value int) (res []*CoinTube, err error)
+}
+
+// VendingRepository is a storage backend for operations spanning users, products and coins,
+// implementations must apply each operation atomically
+type VendingRepository interface {
+	// Deposit adds the coin to the user deposit, where it is held until a purchase or refund,
+	// it returns the updated user
+	Deposit(buyerId types.Id, coin *Coin) (res *User, err error)
+	// Purchase checks the buyer deposit, the stock of every cart product and the change available, decrements
+	// the stock, moves the inserted coins into the tubes, pays the change out of them and empties the deposit
+	// and records the order and the seller earnings as one all-or-nothing operation.
+	// ErrPurchaseConflict is returned if a concurrent purchase changed the state in between.
+	Purchase(buyerId types.Id, items []*CartItem, makeChange ChangeMaker) (res *Purchase, err error)
+	// Refund returns the whole user deposit and empties it. With returnInserted the coins inserted by the user are
+	// given back as they are, otherwise they join the tubes and the deposit is paid as change.
+	Refund(buyerId types.Id, returnInserted bool, makeChange ChangeMaker) (res []*Coin, err error)
+	// RefundOrder reverses a whole order: it restores the stock of the products still existing, returns the total
+	// to the buyer as deposit or as coins out of the tubes, debits the sellers and links the refund to the order,
+	// as one all-or-nothing operation. ErrOrderAlreadyRefunded is returned for an order refunded before.
+	RefundOrder(orderId types.Id, method string, reason string, refundedBy types.Id, makeChange ChangeMaker) (res *OrderRefund, err error)
+}
+
+// OrderRepository reads the orders recorded by purchases
+type OrderRepository interface {
+	// OrdersByBuyer returns the orders of the buyer, the newest first
+	OrdersByBuyer(buyerId types.Id) (res []*Order, err error)
+	OrderOne(id types.Id) (res *Order, err error)
+	OrderRefundOne(id types.Id) (res *OrderRefund, err error)
+}
+
+// LedgerRepository keeps the seller earnings ledger, entries are recorded by purchases, refunds and payouts and never changed
+type LedgerRepository interface {
+	// LedgerEntries returns the entries of the account, the oldest first
+	LedgerEntries(account string) (res []*LedgerEntry, err error)
+	// LedgerBalance returns the credits minus the debits of the account
+	LedgerBalance(account string) (res int, err error)
+	// Payout settles the balance of the seller, or of all sellers if sellerId is empty, and records the payouts.
+	// Sellers without a positive balance are skipped.
+	Payout(sellerId types.Id, paidBy types.Id) (res []*Payout, err error)
+	// PayoutsAll returns the payouts of the seller, or of all sellers if sellerId is empty, the newest first
+	PayoutsAll(sellerId types.Id) (res []*Payout, err error)
+}
+
+// AuditRepository keeps the append-only audit log, there is no way to change or remove an entry
+type AuditRepository interface {
+	AuditAppend(req *AuditEntry) (err error)
+	// AuditQuery returns the matching entries, the oldest first
+	AuditQuery(q *AuditQuery) (res []*AuditEntry, err error)
+}
+
+// IdempotencyRepository stores the responses of requests sent with an Idempotency-Key
+type IdempotencyRepository interface {
+	// IdempotencyBegin reserves the key of the record for the user, unless a record for the key created
+	// after notBefore exists already, which is returned then. Older records are discarded.
+	IdempotencyBegin(req *IdempotencyRecord, notBefore time.Time) (res *IdempotencyRecord, err error)
+	// IdempotencyComplete stores the response of a reserved key
+	IdempotencyComplete(userId types.Id, key string, status int, body []byte) (err error)
+	// IdempotencyRelease drops a reserved key, so the request can be retried
+	IdempotencyRelease(userId types.Id, key string) (err error)
+}
+
+// EventRepository reads the events recorded by the operations changing the deposits, the stock and the coins
+type EventRepository interface {
+	// EventsAll returns the events between from, inclusive, and to, exclusive, the oldest first
+	EventsAll(from time.Time, to time.Time) (res []*Event, err error)
+	// MachineStateAt replays the events up to the given time, or all of them for the zero time
+	MachineStateAt(at time.Time) (res *MachineState, err error)
+	// Reconcile compares the state replayed from all events with the stored one and returns the differences
+	Reconcile() (res []*Discrepancy, err error)
+}
+
+// EventSource is a storage backend recording the events of the operations changing the deposits, the stock and
+// the coins: UserResetDeposit, UserDelete, the product and coin changes and the vending operations
+type EventSource interface {
+	// SetEventRecorder sets the recorder of the events, nil records none. It is set once, before the store is used.
+	SetEventRecorder(record EventRecorder)
+	// FlushEvents records the events of committed operations which are not recorded yet, see SqlStore.withEventTx
+	FlushEvents() (err error)
+}
+
+// Store is a storage backend providing all repositories
+type Store interface {
+	EventSource
+	UserRepository
+	SessionRepository
+	LockoutRepository
+	TotpRepository
+	RoleRepository
+	ProductRepository
+	CoinRepository
+	VendingRepository
+	OrderRepository
+	LedgerRepository
+	AuditRepository
+	IdempotencyRepository
+}
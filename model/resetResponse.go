@@ -1,5 +1,6 @@
 package model
 
 type ResetResponse struct {
-	Change []*Coin `json:"change"`
+	Change   []*Coin `json:"change"`
+	Currency string  `json:"currency" example:"EUR"`
 }
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+)
+
+// The permissions checked by the routes. An :own permission covers the entities of the user, an :any one those of
+// everybody. Every user can read, change and delete their own account, sessions and second factor.
+const (
+	PermissionProductWriteOwn = "product:write:own"
+	PermissionProductWriteAny = "product:write:any"
+	PermissionUserReadAny     = "user:read:any"
+	PermissionUserWriteAny    = "user:write:any"
+	PermissionMachineDeposit  = "machine:deposit"
+	PermissionMachineBuy      = "machine:buy"
+	PermissionMachineManage   = "machine:manage"
+	PermissionPurchaseReadAny = "purchase:read:any"
+	PermissionPurchaseRefund  = "purchase:refund"
+	PermissionEarningsReadOwn = "earnings:read:own"
+	PermissionEarningsReadAny = "earnings:read:any"
+	PermissionPayoutWrite     = "payout:write"
+	PermissionAuditRead       = "audit:read"
+	PermissionLockoutManage   = "lockout:manage"
+	PermissionTotpManage      = "totp:manage"
+	PermissionRoleManage      = "role:manage"
+	maxRoleNameLength         = 32
+	maxRolePermissionsPerRole = 64
+)
+
+// Permissions are all known permissions, in the order they are listed
+var Permissions = []string{
+	PermissionProductWriteOwn, PermissionProductWriteAny,
+	PermissionUserReadAny, PermissionUserWriteAny,
+	PermissionMachineDeposit, PermissionMachineBuy, PermissionMachineManage,
+	PermissionPurchaseReadAny, PermissionPurchaseRefund,
+	PermissionEarningsReadOwn, PermissionEarningsReadAny, PermissionPayoutWrite,
+	PermissionAuditRead, PermissionLockoutManage, PermissionTotpManage, PermissionRoleManage,
+}
+
+// permissionErrors are the errors of a missing permission, the ones of the roles which used to be checked
+var permissionErrors = map[string]error{
+	PermissionProductWriteOwn: ErrInvalidSeller,
+	PermissionMachineDeposit:  ErrInvalidBuyer,
+	PermissionMachineBuy:      ErrInvalidBuyer,
+	PermissionMachineManage:   ErrInvalidAdmin,
+	PermissionPurchaseRefund:  ErrInvalidAdmin,
+	PermissionEarningsReadOwn: ErrInvalidSeller,
+	PermissionPayoutWrite:     ErrInvalidAdmin,
+	PermissionAuditRead:       ErrInvalidAdmin,
+	PermissionLockoutManage:   ErrInvalidAdmin,
+	PermissionTotpManage:      ErrInvalidAdmin,
+	PermissionRoleManage:      ErrInvalidAdmin,
+}
+
+var (
+	ErrInvalidRoleName    = errors.New("role names should be 1 to 32 lower case letters, digits, dashes or underscores")
+	ErrInvalidPermission  = errors.New("unknown permission")
+	ErrRoleInUse          = errors.New("the role is held by users")
+	ErrRoleNotRegistrable = errors.New("the role cannot be chosen at sign-up")
+	ErrNoRoleManager      = errors.New("the change would leave no user allowed to manage roles")
+	ErrTooManyPermissions = errors.New("a role can have at most 64 permissions")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Role is a named set of permissions, users hold one or more roles
+type Role struct {
+	Name        string   `json:"name" example:"seller"`
+	Permissions []string `json:"permissions" example:"product:write:own"`
+	// Registrable roles can be chosen by new users at sign-up
+	Registrable bool `json:"registrable"`
+}
+
+// DefaultRoles are the roles of a new store, the ones of the fixed roles before
+func DefaultRoles() []*Role {
+	return []*Role{
+		{
+			Name: UserRoleAdmin,
+			Permissions: []string{
+				PermissionAuditRead, PermissionEarningsReadAny, PermissionLockoutManage, PermissionMachineManage,
+				PermissionPayoutWrite, PermissionProductWriteAny, PermissionPurchaseReadAny, PermissionPurchaseRefund,
+				PermissionRoleManage, PermissionTotpManage, PermissionUserReadAny, PermissionUserWriteAny,
+			},
+		},
+		{
+			Name:        UserRoleBuyer,
+			Permissions: []string{PermissionMachineBuy, PermissionMachineDeposit},
+			Registrable: true,
+		},
+		{
+			Name:        UserRoleSeller,
+			Permissions: []string{PermissionEarningsReadOwn, PermissionProductWriteOwn},
+			Registrable: true,
+		},
+	}
+}
+
+// Validation checks the name and the permissions, the error lists every violation
+func (a *Role) Validation() (err error) {
+	res := &ValidationError{}
+	if len(a.Name) > maxRoleNameLength || !roleNamePattern.MatchString(a.Name) {
+		res.add("name", ValidationRuleAllowed, ErrInvalidRoleName)
+	}
+	if len(a.Permissions) > maxRolePermissionsPerRole {
+		res.add("permissions", ValidationRuleAllowed, ErrTooManyPermissions)
+	}
+	for _, permission := range a.Permissions {
+		if !isPermission(permission) {
+			res.add("permissions", ValidationRuleAllowed, ErrInvalidPermission)
+		}
+	}
+	return res.errorOrNil()
+}
+
+// normalize sorts the permissions and drops the duplicates
+func (a *Role) normalize() {
+	seen := map[string]bool{}
+	permissions := make([]string, 0, len(a.Permissions))
+	for _, permission := range a.Permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Strings(permissions)
+	a.Permissions = permissions
+}
+
+func (a *Role) copy() *Role {
+	res := *a
+	res.Permissions = append([]string{}, a.Permissions...)
+	return &res
+}
+
+// PermissionSet is a set of permissions
+type PermissionSet map[string]struct{}
+
+// UserPermissions returns the permissions of all roles of the user, roles not among the given ones grant nothing
+func UserPermissions(user *User, roles []*Role) (res PermissionSet) {
+	res = PermissionSet{}
+	for _, role := range roles {
+		if !user.HasRole(role.Name) {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			res[permission] = struct{}{}
+		}
+	}
+	return
+}
+
+// HasAny tells if any of the permissions is in the set
+func (a PermissionSet) HasAny(permissions ...string) bool {
+	for _, permission := range permissions {
+		if _, ok := a[permission]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionError is the error of a user missing the permission
+func PermissionError(permission string) error {
+	if err, ok := permissionErrors[permission]; ok {
+		return err
+	}
+	return ErrAccessDenied
+}
+
+// FindRole returns the role of the name, nil if there is none
+func FindRole(roles []*Role, name string) *Role {
+	for _, role := range roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// validateRoleNames adds a violation of the field for every name which is not a role
+func validateRoleNames(field string, names []string, roles []*Role, res *ValidationError) {
+	for _, name := range names {
+		if FindRole(roles, name) == nil {
+			res.add(field, ValidationRuleAllowed, ErrInvalidUserRole)
+		}
+	}
+}
+
+// checkRoleManagers returns ErrNoRoleManager if a change of the users or the roles takes the role:manage permission
+// from the last users having it, before and after are the users and the roles on both sides of the change
+func checkRoleManagers(usersBefore []*User, rolesBefore []*Role, usersAfter []*User, rolesAfter []*Role) (err error) {
+	if hasRoleManager(usersBefore, rolesBefore) && !hasRoleManager(usersAfter, rolesAfter) {
+		err = ErrNoRoleManager
+	}
+	return
+}
+
+func hasRoleManager(users []*User, roles []*Role) bool {
+	for _, user := range users {
+		if UserPermissions(user, roles).HasAny(PermissionRoleManage) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+package model
+
+// RoleRequest defines the permissions of a role
+type RoleRequest struct {
+	Permissions []string `json:"permissions" example:"product:write:own"`
+	// Registrable roles can be chosen by new users at sign-up
+	Registrable bool `json:"registrable"`
+}
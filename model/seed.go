@@ -0,0 +1,99 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+)
+
+// SeedIfEmpty applies the demo fixture only to a store without users, so a persistent store is seeded once
+func SeedIfEmpty(store Store, currency *Currency, hasher PasswordHasher) (err error) {
+	all, err := store.UsersAll("")
+	if err != nil {
+		return
+	}
+	if len(all) > 0 {
+		return
+	}
+	return Seed(store, currency, hasher)
+}
+
+// Seed fills the given store with the demo users, whose passwords are their IDs, products and a float of 10 coins
+// of each currency coin value
+func Seed(store Store, currency *Currency, hasher PasswordHasher) (err error) {
+	var id types.Id
+
+	id = "1" // types.Id(xid.New().String())
+	user1 := &User{
+		ID:       id,
+		UserName: "User #1, Seller",
+		Roles:    []string{UserRoleSeller},
+	}
+	id = "2" // types.Id(xid.New().String())
+	user2 := &User{
+		ID:       id,
+		UserName: "User #2, Seller",
+		Roles:    []string{UserRoleSeller},
+	}
+	id = "3" // types.Id(xid.New().String())
+	user3 := &User{
+		ID:       id,
+		UserName: "User #3, Buyer",
+		Roles:    []string{UserRoleBuyer},
+	}
+	id = "4" // types.Id(xid.New().String())
+	user4 := &User{
+		ID:       id,
+		UserName: "User #4, Admin",
+		Roles:    []string{UserRoleAdmin},
+	}
+	for _, user := range []*User{user1, user2, user3, user4} {
+		user.PasswordHash, err = hasher.Hash(string(user.ID))
+		if err != nil {
+			return
+		}
+		err = store.UserSave(user)
+		if err != nil {
+			return
+		}
+	}
+
+	id = "1" // types.Id(xid.New().String())
+	product1 := &Product{
+		ID:              id,
+		ProductName:     "Product #1",
+		SellerId:        user1.ID,
+		AmountAvailable: 1000,
+		Cost:            20,
+	}
+	id = "2" // types.Id(xid.New().String())
+	product2 := &Product{
+		ID:              id,
+		ProductName:     "Product #2",
+		SellerId:        user1.ID,
+		AmountAvailable: 1,
+		Cost:            30,
+	}
+	id = "3" // types.Id(xid.New().String())
+	product3 := &Product{
+		ID:              id,
+		ProductName:     "Product #3",
+		SellerId:        user2.ID,
+		AmountAvailable: 3000,
+		Cost:            40,
+	}
+	for _, product := range []*Product{product1, product2, product3} {
+		err = store.ProductSave(product)
+		if err != nil {
+			return
+		}
+	}
+
+	float := make([]*CoinTube, 0, len(currency.CoinValues))
+	for _, value := range currency.CoinValues {
+		float = append(float, &CoinTube{Value: value, Count: 10})
+	}
+	_, err = store.CoinsLoad(float)
+	if err != nil {
+		return
+	}
+	return
+}
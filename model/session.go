@@ -0,0 +1,41 @@
+package model
+
+import (
+	"github.com/oltur/mvp-match/types"
+	"sort"
+	"time"
+)
+
+// SessionDeviceMaxLength is the maximal length of the device label of a session
+const SessionDeviceMaxLength = 100
+
+// Session is a login of a user, a user may have many of them, e.g. on the machine screen and on a phone
+type Session struct {
+	// ID is the jti claim of the tokens of the session
+	ID     types.Id `json:"id" example:"xxx"`
+	UserId types.Id `json:"userId" example:"xxx"`
+	// Device is a label given at login
+	Device     string    `json:"device" example:"phone"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current tells if the session is the one of the request, it is not stored
+	Current bool `json:"current"`
+}
+
+func (a *Session) copy() *Session {
+	res := *a
+	return &res
+}
+
+// Expired tells if the session is expired at the given time
+func (a *Session) Expired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
+
+// sortSessionsNewestFirst orders the sessions by descending creation time
+func sortSessionsNewestFirst(sessions []*Session) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+}
@@ -0,0 +1,1719 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/oltur/mvp-match/types"
+	"github.com/rs/xid"
+	"time"
+
+	// pure Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SqlStore keeps users, products and coins in an embedded SQLite database
+type SqlStore struct {
+	db *sql.DB
+	// record records the events of the operations, which are staged in the event outbox by their transactions and
+	// recorded once they are committed
+	record EventRecorder
+}
+
+// NewSqlStore opens the SQLite database at the given path and applies pending migrations
+func NewSqlStore(path string) (res *SqlStore, err error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return
+	}
+	// SQLite allows a single writer anyway, and ":memory:" databases are per connection
+	db.SetMaxOpenConns(1)
+
+	_, err = migrate(db)
+	if err != nil {
+		_ = db.Close()
+		return
+	}
+	res = &SqlStore{db: db}
+	return
+}
+
+// Close releases the database
+func (s *SqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SqlStore) SetEventRecorder(record EventRecorder) {
+	s.record = record
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ------- users ---------------
+
+const userColumns = `id, user_name, password_hash, deposit, deposit_coins, roles`
+
+func scanUser(row rowScanner) (res *User, err error) {
+	res = &User{}
+	var depositCoins, roles string
+	err = row.Scan(&res.ID, &res.UserName, &res.PasswordHash, &res.Deposit, &depositCoins, &roles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(depositCoins), &res.DepositCoins)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(roles), &res.Roles)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// encodeCoinTubes serializes coin tubes for a JSON column
+func encodeCoinTubes(tubes []*CoinTube) string {
+	if len(tubes) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(tubes)
+	return string(b)
+}
+
+func queryUsers(db querier, query string, args ...interface{}) (res []*User, err error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*User{}
+	for rows.Next() {
+		var user *User
+		user, err = scanUser(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, user)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) UsersAll(q string) (res []*User, err error) {
+	if q == "" {
+		return queryUsers(s.db, `SELECT `+userColumns+` FROM users`)
+	}
+	return queryUsers(s.db, `SELECT `+userColumns+` FROM users WHERE user_name = ?`, q)
+}
+
+func (s *SqlStore) UserOne(id types.Id) (res *User, err error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+func (s *SqlStore) UserInsert(req *User) (res *User, err error) {
+	req.ID = types.Id(xid.New().String())
+
+	isFree, err := s.IsUserNameFree(req.UserName)
+	if err != nil {
+		return
+	}
+	if !isFree {
+		err = ErrUserNameExists
+		return
+	}
+
+	_, err = s.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		req.ID, req.UserName, req.PasswordHash, req.Deposit, encodeCoinTubes(req.DepositCoins), encodeStrings(req.Roles))
+	if err != nil {
+		return
+	}
+	res = req
+	return
+}
+
+func (s *SqlStore) UserResetDeposit(id types.Id) (err error) {
+	return s.withEventTx(func(tx *sql.Tx) (err error) {
+		err = execOne(tx, `UPDATE users SET deposit = 0, deposit_coins = '[]' WHERE id = ?`, id)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventDepositCleared, &UserEvent{UserId: id})
+		return
+	})
+}
+
+// UserUpdate part of CRUD
+func (s *SqlStore) UserUpdate(req *UpdateUserRequest, hasher PasswordHasher) (err error) {
+	passwordHash, err := hasher.Hash(req.Password)
+	if err != nil {
+		return
+	}
+	return s.execOne(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, req.ID)
+}
+
+// UserSave Internal use only
+func (s *SqlStore) UserSave(req *User) (err error) {
+	_, err = s.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	user_name = excluded.user_name,
+	password_hash = excluded.password_hash,
+	deposit = excluded.deposit,
+	deposit_coins = excluded.deposit_coins,
+	roles = excluded.roles`,
+		req.ID, req.UserName, req.PasswordHash, req.Deposit, encodeCoinTubes(req.DepositCoins), encodeStrings(req.Roles))
+	return
+}
+
+func (s *SqlStore) UserDelete(id types.Id) (err error) {
+	return s.withEventTx(func(tx *sql.Tx) (err error) {
+		check, err := beginRoleManagersCheck(tx)
+		if err != nil {
+			return
+		}
+		err = execOne(tx, `DELETE FROM users WHERE id = ?`, id)
+		if err != nil {
+			return
+		}
+		err = check()
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`DELETE FROM totps WHERE user_id = ?`, id)
+		if err != nil {
+			return
+		}
+		err = deleteSessions(tx, `user_id = ?`, id)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventUserDeleted, &UserEvent{UserId: id})
+		return
+	})
+}
+
+func (s *SqlStore) IsUserNameFree(userName string) (res bool, err error) {
+	var count int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE user_name = ?`, userName).Scan(&count)
+	if err != nil {
+		return
+	}
+	res = count == 0
+	return
+}
+
+func (s *SqlStore) GetUserByCredentials(userName string, password string, hasher PasswordHasher) (res *User, err error) {
+	res, err = scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_name = ?`, userName))
+	if errors.Is(err, ErrNotFound) {
+		verifyUnknownUser(hasher, password)
+	}
+	if err != nil {
+		return
+	}
+
+	ok, rehash := hasher.Verify(res.PasswordHash, password)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !rehash {
+		return
+	}
+	// the login does not depend on the rehash, e.g. an old password too long for bcrypt keeps its hash
+	passwordHash, hashErr := hasher.Hash(password)
+	if hashErr != nil {
+		return
+	}
+	// the password could have been changed meanwhile
+	_, err = s.db.Exec(`UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?`,
+		passwordHash, res.ID, res.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	res.PasswordHash = passwordHash
+	return
+}
+
+func (s *SqlStore) UserLogout(id types.Id) (err error) {
+	_, err = s.UserOne(id)
+	if err != nil {
+		return
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		return deleteSessions(tx, `user_id = ?`, id)
+	})
+}
+
+func (s *SqlStore) UserSetRoles(id types.Id, roles []string) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		check, err := beginRoleManagersCheck(tx)
+		if err != nil {
+			return
+		}
+		err = execOne(tx, `UPDATE users SET roles = ? WHERE id = ?`, encodeStrings(roles), id)
+		if err != nil {
+			return
+		}
+		err = check()
+		return
+	})
+}
+
+// ------- sessions ---------------
+
+const sessionColumns = `id, user_id, device, created_at, last_seen_at, expires_at`
+
+func scanSession(row rowScanner) (res *Session, err error) {
+	res = &Session{}
+	var createdAt, lastSeenAt, expiresAt int64
+	err = row.Scan(&res.ID, &res.UserId, &res.Device, &createdAt, &lastSeenAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.CreatedAt = time.Unix(0, createdAt)
+	res.LastSeenAt = time.Unix(0, lastSeenAt)
+	res.ExpiresAt = time.Unix(0, expiresAt)
+	return
+}
+
+func (s *SqlStore) SessionInsert(req *Session, refreshToken *RefreshToken) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		err = deleteSessions(tx, `user_id = ? AND expires_at <= ?`, req.UserId, time.Now().UnixNano())
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+			req.ID, req.UserId, req.Device, req.CreatedAt.UnixNano(), req.LastSeenAt.UnixNano(), req.ExpiresAt.UnixNano())
+		if err != nil {
+			return
+		}
+		return insertRefreshToken(tx, refreshToken)
+	})
+}
+
+func (s *SqlStore) SessionsByUser(userId types.Id) (res []*Session, err error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC`,
+		userId, time.Now().UnixNano())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*Session{}
+	for rows.Next() {
+		var session *Session
+		session, err = scanSession(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, session)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) SessionTouch(id types.Id, now time.Time) (res *Session, err error) {
+	err = s.execOne(`UPDATE sessions SET last_seen_at = ? WHERE id = ? AND expires_at > ?`, now.UnixNano(), id, now.UnixNano())
+	if err != nil {
+		return
+	}
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+func (s *SqlStore) SessionDelete(id types.Id) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		err = execOne(tx, `DELETE FROM sessions WHERE id = ?`, id)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE session_id = ?`, id)
+		return
+	})
+}
+
+func (s *SqlStore) SessionRefresh(hash string, next *RefreshToken, now time.Time) (res *Session, err error) {
+	// the session of a reused token is deleted, so the transaction commits and the error is reported after it
+	reused := false
+	err = s.withTx(func(tx *sql.Tx) (err error) {
+		var sessionId types.Id
+		var usedAt int64
+		err = tx.QueryRow(`SELECT session_id, used_at FROM refresh_tokens WHERE hash = ?`, hash).Scan(&sessionId, &usedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return
+		}
+		res, err = scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ? AND expires_at > ?`,
+			sessionId, now.UnixNano()))
+		if err != nil {
+			return
+		}
+		if usedAt != 0 {
+			reused = true
+			return deleteSessions(tx, `id = ?`, sessionId)
+		}
+
+		err = execOne(tx, `UPDATE refresh_tokens SET used_at = ? WHERE hash = ? AND used_at = 0`, now.UnixNano(), hash)
+		if err != nil {
+			return
+		}
+		next.SessionId = sessionId
+		err = insertRefreshToken(tx, next)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, now.UnixNano(), sessionId)
+		res.LastSeenAt = now
+		return
+	})
+	if err != nil {
+		res = nil
+		return
+	}
+	if reused {
+		err = ErrRefreshTokenReused
+	}
+	return
+}
+
+// deleteSessions drops the sessions matching the condition with their refresh tokens
+func deleteSessions(db execer, where string, args ...interface{}) (err error) {
+	_, err = db.Exec(`DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE `+where+`)`, args...)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec(`DELETE FROM sessions WHERE `+where, args...)
+	return
+}
+
+func insertRefreshToken(db execer, token *RefreshToken) (err error) {
+	var usedAt int64
+	if !token.UsedAt.IsZero() {
+		usedAt = token.UsedAt.UnixNano()
+	}
+	_, err = db.Exec(`INSERT INTO refresh_tokens (hash, session_id, created_at, used_at) VALUES (?, ?, ?, ?)`,
+		token.Hash, token.SessionId, token.CreatedAt.UnixNano(), usedAt)
+	return
+}
+
+// ------- lockouts ---------------
+
+const lockoutColumns = `kind, subject, failures, last_failure_at, locked_until`
+
+func scanLockout(row rowScanner) (res *LoginLockout, err error) {
+	res = &LoginLockout{}
+	var lastFailureAt, lockedUntil int64
+	err = row.Scan(&res.Kind, &res.Subject, &res.Failures, &lastFailureAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.LastFailureAt = time.Unix(0, lastFailureAt)
+	if lockedUntil != 0 {
+		res.LockedUntil = time.Unix(0, lockedUntil)
+	}
+	return
+}
+
+func (s *SqlStore) LockoutOne(kind string, subject string) (res *LoginLockout, err error) {
+	return scanLockout(s.db.QueryRow(`SELECT `+lockoutColumns+` FROM login_lockouts WHERE kind = ? AND subject = ?`,
+		kind, subject))
+}
+
+func (s *SqlStore) LockoutAttempt(kind string, subject string, now time.Time, policy *LockoutPolicy) (res *LoginLockout, err error) {
+	var locked *LoginLockout
+	err = s.withTx(func(tx *sql.Tx) (err error) {
+		res, err = scanLockout(tx.QueryRow(`SELECT `+lockoutColumns+` FROM login_lockouts WHERE kind = ? AND subject = ?`,
+			kind, subject))
+		if errors.Is(err, ErrNotFound) {
+			res, err = &LoginLockout{Kind: kind, Subject: subject}, nil
+		}
+		if err != nil {
+			return
+		}
+		err = res.attempt(now, policy)
+		if err != nil {
+			locked = res
+			return
+		}
+		err = saveLockout(tx, res)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`DELETE FROM login_lockouts WHERE locked_until <= ? AND last_failure_at <= ?`,
+			now.UnixNano(), now.Add(-policy.FailureWindow).UnixNano())
+		return
+	})
+	if err != nil {
+		res = locked
+	}
+	return
+}
+
+func (s *SqlStore) LockoutTakeBack(kind string, subject string, at time.Time) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		lockout, err := scanLockout(tx.QueryRow(`SELECT `+lockoutColumns+` FROM login_lockouts WHERE kind = ? AND subject = ?`,
+			kind, subject))
+		if err != nil {
+			return
+		}
+		lockout.takeBack(at)
+		if lockout.Failures == 0 {
+			_, err = tx.Exec(`DELETE FROM login_lockouts WHERE kind = ? AND subject = ?`, kind, subject)
+			return
+		}
+		err = saveLockout(tx, lockout)
+		return
+	})
+}
+
+func saveLockout(db execer, lockout *LoginLockout) (err error) {
+	var lockedUntil int64
+	if !lockout.LockedUntil.IsZero() {
+		lockedUntil = lockout.LockedUntil.UnixNano()
+	}
+	_, err = db.Exec(`INSERT INTO login_lockouts (`+lockoutColumns+`) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (kind, subject) DO UPDATE SET
+	failures = excluded.failures,
+	last_failure_at = excluded.last_failure_at,
+	locked_until = excluded.locked_until`,
+		lockout.Kind, lockout.Subject, lockout.Failures, lockout.LastFailureAt.UnixNano(), lockedUntil)
+	return
+}
+
+func (s *SqlStore) LockoutsAll() (res []*LoginLockout, err error) {
+	rows, err := s.db.Query(`SELECT ` + lockoutColumns + ` FROM login_lockouts ORDER BY last_failure_at DESC`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*LoginLockout{}
+	for rows.Next() {
+		var lockout *LoginLockout
+		lockout, err = scanLockout(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, lockout)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) LockoutClear(kind string, subject string) (err error) {
+	return s.execOne(`DELETE FROM login_lockouts WHERE kind = ? AND subject = ?`, kind, subject)
+}
+
+// ------- second factors ---------------
+
+const totpColumns = `user_id, secret, confirmed_at, recovery_code_hashes, last_used_step, created_at`
+
+func scanTotp(row rowScanner) (res *Totp, err error) {
+	res = &Totp{}
+	var confirmedAt, createdAt int64
+	var recoveryCodeHashes string
+	err = row.Scan(&res.UserId, &res.Secret, &confirmedAt, &recoveryCodeHashes, &res.LastUsedStep, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmedAt != 0 {
+		res.ConfirmedAt = time.Unix(0, confirmedAt)
+	}
+	res.CreatedAt = time.Unix(0, createdAt)
+	err = json.Unmarshal([]byte(recoveryCodeHashes), &res.RecoveryCodeHashes)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// encodeStrings serializes strings for a JSON column
+func encodeStrings(values []string) string {
+	if len(values) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+func (s *SqlStore) TotpOne(userId types.Id) (res *Totp, err error) {
+	return scanTotp(s.db.QueryRow(`SELECT `+totpColumns+` FROM totps WHERE user_id = ?`, userId))
+}
+
+func (s *SqlStore) TotpSave(req *Totp) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		_, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, req.UserId))
+		if err != nil {
+			return
+		}
+		var confirmedAt int64
+		if req.Confirmed() {
+			confirmedAt = req.ConfirmedAt.UnixNano()
+		}
+		_, err = tx.Exec(`INSERT INTO totps (`+totpColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (user_id) DO UPDATE SET
+	secret = excluded.secret,
+	confirmed_at = excluded.confirmed_at,
+	recovery_code_hashes = excluded.recovery_code_hashes,
+	last_used_step = excluded.last_used_step,
+	created_at = excluded.created_at`,
+			req.UserId, req.Secret, confirmedAt, encodeStrings(req.RecoveryCodeHashes), req.LastUsedStep,
+			req.CreatedAt.UnixNano())
+		return
+	})
+}
+
+func (s *SqlStore) TotpDelete(userId types.Id) (err error) {
+	return s.execOne(`DELETE FROM totps WHERE user_id = ?`, userId)
+}
+
+func (s *SqlStore) TotpUseStep(userId types.Id, step int64) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		totp, err := scanTotp(tx.QueryRow(`SELECT `+totpColumns+` FROM totps WHERE user_id = ?`, userId))
+		if err != nil {
+			return
+		}
+		if step <= totp.LastUsedStep {
+			return ErrTotpCodeUsed
+		}
+		_, err = tx.Exec(`UPDATE totps SET last_used_step = ? WHERE user_id = ?`, step, userId)
+		return
+	})
+}
+
+func (s *SqlStore) TotpUseRecoveryCode(userId types.Id, hash string) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		totp, err := scanTotp(tx.QueryRow(`SELECT `+totpColumns+` FROM totps WHERE user_id = ?`, userId))
+		if err != nil {
+			return
+		}
+		for i, h := range totp.RecoveryCodeHashes {
+			if h == hash {
+				hashes := append(totp.RecoveryCodeHashes[:i:i], totp.RecoveryCodeHashes[i+1:]...)
+				_, err = tx.Exec(`UPDATE totps SET recovery_code_hashes = ? WHERE user_id = ?`,
+					encodeStrings(hashes), userId)
+				return
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *SqlStore) TotpRequiredRoles() (res []string, err error) {
+	rows, err := s.db.Query(`SELECT role FROM totp_required_roles ORDER BY role`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []string{}
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return
+		}
+		res = append(res, role)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) TotpSetRequiredRoles(roles []string) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`DELETE FROM totp_required_roles`)
+		if err != nil {
+			return
+		}
+		for _, role := range roles {
+			_, err = tx.Exec(`INSERT INTO totp_required_roles (role) VALUES (?)`, role)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// ------- roles ---------------
+
+const roleColumns = `name, permissions, registrable`
+
+func scanRole(row rowScanner) (res *Role, err error) {
+	res = &Role{}
+	var permissions string
+	err = row.Scan(&res.Name, &permissions, &res.Registrable)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(permissions), &res.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *SqlStore) RolesAll() (res []*Role, err error) {
+	return queryRoles(s.db)
+}
+
+func queryRoles(db querier) (res []*Role, err error) {
+	rows, err := db.Query(`SELECT ` + roleColumns + ` FROM roles ORDER BY name`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*Role{}
+	for rows.Next() {
+		var role *Role
+		role, err = scanRole(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, role)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) RoleOne(name string) (res *Role, err error) {
+	return scanRole(s.db.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = ?`, name))
+}
+
+func (s *SqlStore) RoleSave(req *Role) (err error) {
+	role := req.copy()
+	role.normalize()
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		check, err := beginRoleManagersCheck(tx)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`INSERT INTO roles (`+roleColumns+`) VALUES (?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET
+	permissions = excluded.permissions,
+	registrable = excluded.registrable`,
+			role.Name, encodeStrings(role.Permissions), role.Registrable)
+		if err != nil {
+			return
+		}
+		err = check()
+		return
+	})
+}
+
+func (s *SqlStore) RoleDelete(name string) (err error) {
+	return s.withTx(func(tx *sql.Tx) (err error) {
+		var holders int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM users, json_each(users.roles) WHERE json_each.value = ?`, name).
+			Scan(&holders)
+		if err != nil {
+			return
+		}
+		if holders > 0 {
+			return ErrRoleInUse
+		}
+		err = execOne(tx, `DELETE FROM roles WHERE name = ?`, name)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`DELETE FROM totp_required_roles WHERE role = ?`, name)
+		return
+	})
+}
+
+// ------- products ---------------
+
+const productColumns = `id, product_name, seller_id, amount_available, cost`
+
+func scanProduct(row rowScanner) (res *Product, err error) {
+	res = &Product{}
+	err = row.Scan(&res.ID, &res.ProductName, &res.SellerId, &res.AmountAvailable, &res.Cost)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *SqlStore) queryProducts(query string, args ...interface{}) (res []*Product, err error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*Product{}
+	for rows.Next() {
+		var product *Product
+		product, err = scanProduct(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, product)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) ProductsAll(q string) (res []*Product, err error) {
+	if q == "" {
+		return s.queryProducts(`SELECT ` + productColumns + ` FROM products`)
+	}
+	return s.queryProducts(`SELECT `+productColumns+` FROM products WHERE product_name = ?`, q)
+}
+
+func (s *SqlStore) ProductOne(id types.Id) (res *Product, err error) {
+	return scanProduct(s.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ?`, id))
+}
+
+func (s *SqlStore) ProductUpdate(req *UpdateProductRequest) (err error) {
+	return s.withEventTx(func(tx *sql.Tx) (err error) {
+		err = execOne(tx, `UPDATE products SET product_name = ?, cost = ?, amount_available = ? WHERE id = ?`,
+			req.ProductName, req.Cost, req.AmountAvailable, req.ID)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+		return
+	})
+}
+
+// ProductSave Internal use only
+func (s *SqlStore) ProductSave(req *Product) (err error) {
+	return s.withEventTx(func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`INSERT INTO products (`+productColumns+`) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	product_name = excluded.product_name,
+	seller_id = excluded.seller_id,
+	amount_available = excluded.amount_available,
+	cost = excluded.cost`,
+			req.ID, req.ProductName, req.SellerId, req.AmountAvailable, req.Cost)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+		return
+	})
+}
+
+func (s *SqlStore) ProductDelete(id types.Id) (err error) {
+	return s.withEventTx(func(tx *sql.Tx) (err error) {
+		err = execOne(tx, `DELETE FROM products WHERE id = ?`, id)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventProductDeleted, &ProductDeletedEvent{ProductId: id})
+		return
+	})
+}
+
+func (s *SqlStore) ProductInsert(req *Product) (res *Product, err error) {
+	req.ID = types.Id(xid.New().String())
+
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`INSERT INTO products (`+productColumns+`) VALUES (?, ?, ?, ?, ?)`,
+			req.ID, req.ProductName, req.SellerId, req.AmountAvailable, req.Cost)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventStock, &StockEvent{ProductId: req.ID, AmountAvailable: req.AmountAvailable})
+		return
+	})
+	if err != nil {
+		return
+	}
+	res = req
+	return
+}
+
+// ------- coins ---------------
+
+func (s *SqlStore) CoinsAll() (res []*CoinTube, err error) {
+	counts, err := queryCoins(s.db)
+	if err != nil {
+		return
+	}
+	res = CoinTubesFromCounts(counts)
+	return
+}
+
+func (s *SqlStore) CoinsLoad(req []*CoinTube) (res []*CoinTube, err error) {
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		for _, tube := range req {
+			err = addCoins(tx, tube.Value, tube.Count)
+			if err != nil {
+				return
+			}
+		}
+		counts, err := queryCoins(tx)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventCoinsLoaded, &CoinsLoadedEvent{Coins: req})
+		if err != nil {
+			return
+		}
+		res = CoinTubesFromCounts(counts)
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *SqlStore) CoinsEmpty(value int) (res []*CoinTube, err error) {
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		counts, err := queryCoins(tx)
+		if err != nil {
+			return
+		}
+		removed := make(map[int]int)
+		for k, count := range counts {
+			if value == 0 || value == k {
+				removed[k] = count
+			}
+		}
+		if value == 0 {
+			_, err = tx.Exec(`UPDATE coins SET count = 0`)
+		} else {
+			_, err = tx.Exec(`UPDATE coins SET count = 0 WHERE value = ?`, value)
+		}
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventCoinsEmptied, &CoinsEmptiedEvent{Value: value})
+		if err != nil {
+			return
+		}
+		res = CoinTubesFromCounts(removed)
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// ------- vending ---------------
+
+func (s *SqlStore) Deposit(buyerId types.Id, coin *Coin) (res *User, err error) {
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, buyerId))
+		if err != nil {
+			return
+		}
+		user.Deposit = user.Deposit + coin.Value
+		user.DepositCoins = CoinTubesFromCounts(AddCoinCounts(CoinCountsFromTubes(user.DepositCoins), map[int]int{coin.Value: 1}))
+		err = execOne(tx, `UPDATE users SET deposit = ?, deposit_coins = ? WHERE id = ?`,
+			user.Deposit, encodeCoinTubes(user.DepositCoins), user.ID)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventDeposit, &DepositEvent{BuyerId: buyerId, Coin: coin.Value})
+		if err != nil {
+			return
+		}
+		res = user
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *SqlStore) Purchase(buyerId types.Id, items []*CartItem, makeChange ChangeMaker) (res *Purchase, err error) {
+	err = validateCart(items)
+	if err != nil {
+		return
+	}
+
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, buyerId))
+		if err != nil {
+			return
+		}
+		amounts := cartAmounts(items)
+		products := make(map[types.Id]*Product, len(amounts))
+		for id := range amounts {
+			products[id], err = scanProduct(tx.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ?`, id))
+			if err != nil {
+				return
+			}
+		}
+		available, err := queryCoins(tx)
+		if err != nil {
+			return
+		}
+
+		res, err = preparePurchase(user, items, products, available, makeChange)
+		if err != nil {
+			return
+		}
+
+		// the conditions guard against writers outside of this connection, e.g. another process
+		for id, amount := range amounts {
+			err = execOne(tx, `UPDATE products SET amount_available = amount_available - ? WHERE id = ? AND amount_available = ?`,
+				amount, id, products[id].AmountAvailable)
+			if errors.Is(err, ErrNotFound) {
+				err = ErrPurchaseConflict
+			}
+			if err != nil {
+				return
+			}
+		}
+		err = emptyDeposit(tx, user)
+		if err != nil {
+			return
+		}
+		err = putCoins(tx, user.DepositCoins)
+		if err != nil {
+			return
+		}
+		err = takeCoins(tx, res.Change)
+		if err != nil {
+			return
+		}
+		err = insertOrder(tx, res.Order)
+		if err != nil {
+			return
+		}
+		err = insertLedgerEntries(tx, saleEntries(res.Order))
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventPurchase, purchaseEvent(user, items, res))
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *SqlStore) Refund(buyerId types.Id, returnInserted bool, makeChange ChangeMaker) (res []*Coin, err error) {
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, buyerId))
+		if err != nil {
+			return
+		}
+		available, err := queryCoins(tx)
+		if err != nil {
+			return
+		}
+
+		var take []*Coin
+		res, take, err = prepareRefund(user, available, returnInserted, makeChange)
+		if err != nil {
+			return
+		}
+
+		err = emptyDeposit(tx, user)
+		if err != nil {
+			return
+		}
+		if !returnInserted {
+			err = putCoins(tx, user.DepositCoins)
+			if err != nil {
+				return
+			}
+		}
+		err = takeCoins(tx, take)
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventReset, resetEvent(user, returnInserted, take))
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *SqlStore) RefundOrder(orderId types.Id, method string, reason string, refundedBy types.Id, makeChange ChangeMaker) (res *OrderRefund, err error) {
+	err = s.withEventTx(func(tx *sql.Tx) (err error) {
+		order, err := scanOrder(tx.QueryRow(`SELECT `+orderColumns+` FROM orders WHERE id = ?`, orderId))
+		if err != nil {
+			return
+		}
+		err = loadOrderLines(tx, order)
+		if err != nil {
+			return
+		}
+		var buyer *User
+		if method == RefundMethodDeposit {
+			buyer, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, order.BuyerId))
+			if err != nil {
+				return
+			}
+		}
+		available, err := queryCoins(tx)
+		if err != nil {
+			return
+		}
+
+		var entries []*LedgerEntry
+		res, entries, err = prepareOrderRefund(order, method, reason, refundedBy, available, makeChange)
+		if err != nil {
+			return
+		}
+
+		// the condition guards against a concurrent refund of the same order
+		err = execOne(tx, `UPDATE orders SET refund_id = ? WHERE id = ? AND refund_id = ''`, res.ID, order.ID)
+		if errors.Is(err, ErrNotFound) {
+			err = ErrOrderAlreadyRefunded
+		}
+		if err != nil {
+			return
+		}
+		for _, line := range order.Lines {
+			// deleted products are not restored
+			_, err = tx.Exec(`UPDATE products SET amount_available = amount_available + ? WHERE id = ?`, line.AmountOfProducts, line.ProductId)
+			if err != nil {
+				return
+			}
+		}
+		if buyer != nil {
+			err = execOne(tx, `UPDATE users SET deposit = deposit + ? WHERE id = ?`, res.Amount, buyer.ID)
+			if err != nil {
+				return
+			}
+		}
+		err = takeCoins(tx, res.Coins)
+		if err != nil {
+			return
+		}
+		err = insertLedgerEntries(tx, entries)
+		if err != nil {
+			return
+		}
+		coins, err := json.Marshal(res.Coins)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`INSERT INTO order_refunds (`+orderRefundColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			res.ID, res.OrderId, res.BuyerId, res.Amount, res.Method, string(coins), res.Reason, res.RefundedBy, res.CreatedAt.UnixNano())
+		if err != nil {
+			return
+		}
+		err = s.stageEvent(tx, EventOrderRefund, orderRefundEvent(order, res))
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// ------- orders ---------------
+
+const orderColumns = `id, buyer_id, total, change_coins, created_at, refund_id`
+
+func scanOrder(row rowScanner) (res *Order, err error) {
+	res = &Order{}
+	var changeCoins string
+	var createdAt int64
+	err = row.Scan(&res.ID, &res.BuyerId, &res.Total, &changeCoins, &createdAt, &res.RefundId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(changeCoins), &res.Change)
+	if err != nil {
+		return nil, err
+	}
+	res.CreatedAt = time.Unix(0, createdAt)
+	return
+}
+
+func (s *SqlStore) OrdersByBuyer(buyerId types.Id) (res []*Order, err error) {
+	rows, err := s.db.Query(`SELECT `+orderColumns+` FROM orders WHERE buyer_id = ? ORDER BY created_at DESC, id DESC`, buyerId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*Order{}
+	for rows.Next() {
+		var order *Order
+		order, err = scanOrder(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, order)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	for _, order := range res {
+		err = s.loadOrderLines(order)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *SqlStore) OrderOne(id types.Id) (res *Order, err error) {
+	res, err = scanOrder(s.db.QueryRow(`SELECT `+orderColumns+` FROM orders WHERE id = ?`, id))
+	if err != nil {
+		return
+	}
+	err = s.loadOrderLines(res)
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+func (s *SqlStore) loadOrderLines(order *Order) (err error) {
+	return loadOrderLines(s.db, order)
+}
+
+func loadOrderLines(db querier, order *Order) (err error) {
+	rows, err := db.Query(`SELECT product_id, product_name, seller_id, cost, amount, total FROM order_lines
+WHERE order_id = ? ORDER BY line_no`, order.ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	order.Lines = []*OrderLine{}
+	for rows.Next() {
+		line := &OrderLine{}
+		err = rows.Scan(&line.ProductId, &line.ProductName, &line.SellerId, &line.Cost, &line.AmountOfProducts, &line.Total)
+		if err != nil {
+			return
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	err = rows.Err()
+	return
+}
+
+const orderRefundColumns = `id, order_id, buyer_id, amount, method, coins, reason, refunded_by, created_at`
+
+func (s *SqlStore) OrderRefundOne(id types.Id) (res *OrderRefund, err error) {
+	res = &OrderRefund{}
+	var coins string
+	var createdAt int64
+	err = s.db.QueryRow(`SELECT `+orderRefundColumns+` FROM order_refunds WHERE id = ?`, id).Scan(
+		&res.ID, &res.OrderId, &res.BuyerId, &res.Amount, &res.Method, &coins, &res.Reason, &res.RefundedBy, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(coins), &res.Coins)
+	if err != nil {
+		return nil, err
+	}
+	res.CreatedAt = time.Unix(0, createdAt)
+	return
+}
+
+// ------- ledger ---------------
+
+const ledgerEntryColumns = `id, kind, debit_account, credit_account, amount, order_id, product_id, payout_id, refund_id, created_at`
+
+func scanLedgerEntry(row rowScanner) (res *LedgerEntry, err error) {
+	res = &LedgerEntry{}
+	var createdAt int64
+	err = row.Scan(&res.ID, &res.Kind, &res.DebitAccount, &res.CreditAccount, &res.Amount, &res.OrderId, &res.ProductId, &res.PayoutId, &res.RefundId, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	res.CreatedAt = time.Unix(0, createdAt)
+	return
+}
+
+func (s *SqlStore) LedgerEntries(account string) (res []*LedgerEntry, err error) {
+	rows, err := s.db.Query(`SELECT `+ledgerEntryColumns+` FROM ledger_entries
+WHERE debit_account = ? OR credit_account = ? ORDER BY created_at, id`, account, account)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*LedgerEntry{}
+	for rows.Next() {
+		var entry *LedgerEntry
+		entry, err = scanLedgerEntry(rows)
+		if err != nil {
+			return
+		}
+		res = append(res, entry)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SqlStore) LedgerBalance(account string) (res int, err error) {
+	err = s.db.QueryRow(`SELECT
+	COALESCE((SELECT SUM(amount) FROM ledger_entries WHERE credit_account = ?), 0) -
+	COALESCE((SELECT SUM(amount) FROM ledger_entries WHERE debit_account = ?), 0)`, account, account).Scan(&res)
+	return
+}
+
+func (s *SqlStore) Payout(sellerId types.Id, paidBy types.Id) (res []*Payout, err error) {
+	err = s.withTx(func(tx *sql.Tx) (err error) {
+		rows, err := tx.Query(`SELECT account, SUM(amount) FROM (
+	SELECT credit_account AS account, amount FROM ledger_entries
+	UNION ALL
+	SELECT debit_account AS account, -amount FROM ledger_entries
+) WHERE account LIKE ? || '%' AND (? = '' OR account = ?)
+GROUP BY account HAVING SUM(amount) > 0 ORDER BY account`, ledgerAccountSellerPrefix, sellerId, SellerAccount(sellerId))
+		if err != nil {
+			return
+		}
+		balances := map[types.Id]int{}
+		var ids []types.Id
+		for rows.Next() {
+			var account string
+			var balance int
+			err = rows.Scan(&account, &balance)
+			if err != nil {
+				_ = rows.Close()
+				return
+			}
+			id, _ := sellerIdFromAccount(account)
+			balances[id] = balance
+			ids = append(ids, id)
+		}
+		_ = rows.Close()
+		err = rows.Err()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+		res = []*Payout{}
+		for _, id := range ids {
+			payout, entry := newPayout(id, balances[id], paidBy, now)
+			_, err = tx.Exec(`INSERT INTO payouts (`+payoutColumns+`) VALUES (?, ?, ?, ?, ?)`,
+				payout.ID, payout.SellerId, payout.Amount, payout.PaidBy, payout.CreatedAt.UnixNano())
+			if err != nil {
+				return
+			}
+			err = insertLedgerEntries(tx, []*LedgerEntry{entry})
+			if err != nil {
+				return
+			}
+			res = append(res, payout)
+		}
+		return
+	})
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+const payoutColumns = `id, seller_id, amount, paid_by, created_at`
+
+func (s *SqlStore) PayoutsAll(sellerId types.Id) (res []*Payout, err error) {
+	rows, err := s.db.Query(`SELECT `+payoutColumns+` FROM payouts
+WHERE ? = '' OR seller_id = ? ORDER BY created_at DESC, id DESC`, sellerId, sellerId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*Payout{}
+	for rows.Next() {
+		payout := &Payout{}
+		var createdAt int64
+		err = rows.Scan(&payout.ID, &payout.SellerId, &payout.Amount, &payout.PaidBy, &createdAt)
+		if err != nil {
+			return
+		}
+		payout.CreatedAt = time.Unix(0, createdAt)
+		res = append(res, payout)
+	}
+	err = rows.Err()
+	return
+}
+
+// ------- audit ---------------
+
+const auditColumns = `id, actor_id, actor_role, action, entity_type, entity_id, before, after, created_at`
+
+func (s *SqlStore) AuditAppend(req *AuditEntry) (err error) {
+	_, err = s.db.Exec(`INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.ID, req.ActorId, req.ActorRole, req.Action, req.EntityType, req.EntityId,
+		nullableJson(req.Before), nullableJson(req.After), req.CreatedAt.UnixNano())
+	return
+}
+
+func (s *SqlStore) AuditQuery(q *AuditQuery) (res []*AuditEntry, err error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if q.ActorId != "" {
+		query = query + ` AND actor_id = ?`
+		args = append(args, q.ActorId)
+	}
+	if q.EntityType != "" {
+		query = query + ` AND entity_type = ?`
+		args = append(args, q.EntityType)
+	}
+	if q.EntityId != "" {
+		query = query + ` AND entity_id = ?`
+		args = append(args, q.EntityId)
+	}
+	if !q.From.IsZero() {
+		query = query + ` AND created_at >= ?`
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		query = query + ` AND created_at < ?`
+		args = append(args, q.To.UnixNano())
+	}
+	rows, err := s.db.Query(query+` ORDER BY seq`, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*AuditEntry{}
+	for rows.Next() {
+		entry := &AuditEntry{}
+		var before, after sql.NullString
+		var createdAt int64
+		err = rows.Scan(&entry.ID, &entry.ActorId, &entry.ActorRole, &entry.Action, &entry.EntityType, &entry.EntityId,
+			&before, &after, &createdAt)
+		if err != nil {
+			return
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entry.CreatedAt = time.Unix(0, createdAt)
+		res = append(res, entry)
+	}
+	err = rows.Err()
+	return
+}
+
+// nullableJson stores an empty JSON value as NULL
+func nullableJson(value json.RawMessage) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	return string(value)
+}
+
+// ------- idempotency ---------------
+
+func (s *SqlStore) IdempotencyBegin(req *IdempotencyRecord, notBefore time.Time) (res *IdempotencyRecord, err error) {
+	err = s.withTx(func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, notBefore.UnixNano())
+		if err != nil {
+			return
+		}
+
+		record := &IdempotencyRecord{UserId: req.UserId, Key: req.Key}
+		var createdAt int64
+		err = tx.QueryRow(`SELECT fingerprint, status, body, created_at FROM idempotency_keys WHERE user_id = ? AND key = ?`,
+			req.UserId, req.Key).Scan(&record.Fingerprint, &record.Status, &record.Body, &createdAt)
+		if err == nil {
+			record.CreatedAt = time.Unix(0, createdAt)
+			res = record
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+
+		_, err = tx.Exec(`INSERT INTO idempotency_keys (user_id, key, fingerprint, status, body, created_at) VALUES (?, ?, ?, 0, ?, ?)`,
+			req.UserId, req.Key, req.Fingerprint, []byte{}, req.CreatedAt.UnixNano())
+		return
+	})
+	return
+}
+
+func (s *SqlStore) IdempotencyComplete(userId types.Id, key string, status int, body []byte) (err error) {
+	return s.execOne(`UPDATE idempotency_keys SET status = ?, body = ? WHERE user_id = ? AND key = ?`, status, body, userId, key)
+}
+
+func (s *SqlStore) IdempotencyRelease(userId types.Id, key string) (err error) {
+	_, err = s.db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`, userId, key)
+	return
+}
+
+// ------- implementation details ---------------
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// beginRoleManagersCheck reads the users and the roles before a change in the transaction, check then returns
+// ErrNoRoleManager if the change took the role:manage permission from the last users having it
+func beginRoleManagersCheck(tx *sql.Tx) (check func() error, err error) {
+	usersBefore, err := queryUsers(tx, `SELECT `+userColumns+` FROM users`)
+	if err != nil {
+		return
+	}
+	rolesBefore, err := queryRoles(tx)
+	if err != nil {
+		return
+	}
+	check = func() (err error) {
+		usersAfter, err := queryUsers(tx, `SELECT `+userColumns+` FROM users`)
+		if err != nil {
+			return
+		}
+		rolesAfter, err := queryRoles(tx)
+		if err != nil {
+			return
+		}
+		err = checkRoleManagers(usersBefore, rolesBefore, usersAfter, rolesAfter)
+		return
+	}
+	return
+}
+
+// queryCoins returns the coin inventory as counts by coin value
+func queryCoins(db querier) (res map[int]int, err error) {
+	rows, err := db.Query(`SELECT value, count FROM coins`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = make(map[int]int)
+	for rows.Next() {
+		var value, count int
+		err = rows.Scan(&value, &count)
+		if err != nil {
+			return
+		}
+		res[value] = count
+	}
+	err = rows.Err()
+	return
+}
+
+func addCoins(db execer, value int, count int) (err error) {
+	_, err = db.Exec(`INSERT INTO coins (value, count) VALUES (?, ?) ON CONFLICT (value) DO UPDATE SET count = count + excluded.count`,
+		value, count)
+	return
+}
+
+func putCoins(db execer, tubes []*CoinTube) (err error) {
+	for _, tube := range tubes {
+		err = addCoins(db, tube.Value, tube.Count)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// emptyDeposit empties the user deposit, ErrPurchaseConflict is returned if it changed since the user was read
+func emptyDeposit(db execer, user *User) (err error) {
+	err = execOne(db, `UPDATE users SET deposit = 0, deposit_coins = '[]' WHERE id = ? AND deposit = ?`, user.ID, user.Deposit)
+	if errors.Is(err, ErrNotFound) {
+		err = ErrPurchaseConflict
+	}
+	return
+}
+
+// takeCoins removes the coins from the inventory, ErrPurchaseConflict is returned if they are not there anymore
+func takeCoins(db execer, coins []*Coin) (err error) {
+	for value, count := range CountCoins(coins) {
+		err = execOne(db, `UPDATE coins SET count = count - ? WHERE value = ? AND count >= ?`, count, value, count)
+		if errors.Is(err, ErrNotFound) {
+			err = ErrPurchaseConflict
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func insertOrder(db execer, order *Order) (err error) {
+	changeCoins, err := json.Marshal(order.Change)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec(`INSERT INTO orders (`+orderColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		order.ID, order.BuyerId, order.Total, string(changeCoins), order.CreatedAt.UnixNano(), order.RefundId)
+	if err != nil {
+		return
+	}
+	for i, line := range order.Lines {
+		_, err = db.Exec(`INSERT INTO order_lines (order_id, line_no, product_id, product_name, seller_id, cost, amount, total)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, i, line.ProductId, line.ProductName, line.SellerId, line.Cost, line.AmountOfProducts, line.Total)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func insertLedgerEntries(db execer, entries []*LedgerEntry) (err error) {
+	for _, entry := range entries {
+		_, err = db.Exec(`INSERT INTO ledger_entries (`+ledgerEntryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.ID, entry.Kind, entry.DebitAccount, entry.CreditAccount, entry.Amount,
+			entry.OrderId, entry.ProductId, entry.PayoutId, entry.RefundId, entry.CreatedAt.UnixNano())
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// withTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func (s *SqlStore) withTx(fn func(tx *sql.Tx) error) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// withEventTx runs an operation changing the deposits, the stock or the coins in a transaction. The pending events
+// are recorded first, so the operation fails without a change while the events cannot be recorded, and the events
+// the operation staged are recorded after the commit. An operation whose events cannot be recorded then fails, but
+// stays committed, its events stay in the outbox until they are recorded by the next operation or FlushEvents.
+func (s *SqlStore) withEventTx(fn func(tx *sql.Tx) error) (err error) {
+	err = s.FlushEvents()
+	if err != nil {
+		return
+	}
+	err = s.withTx(fn)
+	if err != nil {
+		return
+	}
+	err = s.FlushEvents()
+	return
+}
+
+// stageEvent puts the event of an operation into the outbox, in the transaction of the operation
+func (s *SqlStore) stageEvent(tx *sql.Tx, eventType string, data interface{}) (err error) {
+	if s.record == nil {
+		return
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO event_outbox (type, data) VALUES (?, ?)`, eventType, string(b))
+	return
+}
+
+// FlushEvents records the events of the outbox in order and removes them, an event whose removal fails is recorded
+// again by the next flush
+func (s *SqlStore) FlushEvents() (err error) {
+	if s.record == nil {
+		return
+	}
+	pending, err := queryPendingEvents(s.db)
+	if err != nil {
+		return
+	}
+	for _, event := range pending {
+		err = s.record(event.eventType, event.data)
+		if err != nil {
+			return
+		}
+		_, err = s.db.Exec(`DELETE FROM event_outbox WHERE id = ?`, event.id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// pendingEvent is an event of a committed operation waiting in the outbox
+type pendingEvent struct {
+	id        int64
+	eventType string
+	data      json.RawMessage
+}
+
+func queryPendingEvents(db querier) (res []*pendingEvent, err error) {
+	rows, err := db.Query(`SELECT id, type, data FROM event_outbox ORDER BY id`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	res = []*pendingEvent{}
+	for rows.Next() {
+		event := &pendingEvent{}
+		var data string
+		err = rows.Scan(&event.id, &event.eventType, &data)
+		if err != nil {
+			return
+		}
+		event.data = json.RawMessage(data)
+		res = append(res, event)
+	}
+	err = rows.Err()
+	return
+}
+
+// execOne runs a statement that must affect exactly one row, otherwise ErrNotFound is returned
+func (s *SqlStore) execOne(query string, args ...interface{}) (err error) {
+	return execOne(s.db, query, args...)
+}
+
+func execOne(db execer, query string, args ...interface{}) (err error) {
+	r, err := db.Exec(query, args...)
+	if err != nil {
+		return
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n == 0 {
+		err = ErrNotFound
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+)
+
+const (
+	// TotpIssuer names the service in the authenticator apps
+	TotpIssuer = "MVP Match"
+	// TotpPeriod, TotpDigits and the SHA-1 HMAC are the RFC 6238 defaults, which every authenticator app supports
+	TotpPeriod = 30 * time.Second
+	TotpDigits = 6
+	// totpModulo is 10 to the power of TotpDigits
+	totpModulo = 1000000
+	// TotpSkew is how many steps before and after the current one are accepted, for clock differences
+	TotpSkew = 1
+	// TotpRecoveryCodes is the number of recovery codes given on enrollment
+	TotpRecoveryCodes  = 10
+	totpSecretLength   = 20
+	recoveryCodeLength = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Totp is the time-based one-time password second factor of a user
+type Totp struct {
+	UserId types.Id `json:"userId" example:"xxx"`
+	// Secret is shared with the authenticator app
+	Secret []byte `json:"-"`
+	// ConfirmedAt is zero until a first code is verified, only confirmed second factors are required at login
+	ConfirmedAt time.Time `json:"confirmedAt"`
+	// RecoveryCodeHashes are the hashes of the unused recovery codes, each one replaces a code once
+	RecoveryCodeHashes []string `json:"-"`
+	// LastUsedStep is the time step of the last accepted code, a code of it or an earlier step is not accepted again
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// NewTotp creates an unconfirmed second factor of the user with a random secret
+func NewTotp(userId types.Id, now time.Time) (res *Totp, err error) {
+	secret := make([]byte, totpSecretLength)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return
+	}
+	res = &Totp{UserId: userId, Secret: secret, CreatedAt: now}
+	return
+}
+
+// Confirmed tells if the second factor is in use
+func (a *Totp) Confirmed() bool {
+	return !a.ConfirmedAt.IsZero()
+}
+
+// EncodedSecret is the secret in base32, as the authenticator apps take it
+func (a *Totp) EncodedSecret() string {
+	return totpEncoding.EncodeToString(a.Secret)
+}
+
+// ProvisioningUri is the otpauth URI for the QR code scanned by the authenticator apps
+func (a *Totp) ProvisioningUri(userName string) string {
+	q := url.Values{}
+	q.Set("secret", a.EncodedSecret())
+	q.Set("issuer", TotpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TotpDigits))
+	q.Set("period", fmt.Sprint(int(TotpPeriod.Seconds())))
+	label := url.PathEscape(TotpIssuer + ":" + userName)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Verify returns the time step of the code if it is valid at the given time, false otherwise.
+// The step is not checked against the last used one.
+func (a *Totp) Verify(code string, now time.Time) (step int64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return
+	}
+	current := TotpStep(now)
+	for i := current - TotpSkew; i <= current+TotpSkew; i++ {
+		if hmac.Equal([]byte(totpCode(a.Secret, i)), []byte(code)) {
+			return i, true
+		}
+	}
+	return
+}
+
+// NewRecoveryCodes replaces the recovery codes with new ones, which are returned once and stored only hashed
+func (a *Totp) NewRecoveryCodes() (res []string, err error) {
+	res = make([]string, TotpRecoveryCodes)
+	a.RecoveryCodeHashes = make([]string, TotpRecoveryCodes)
+	for i := range res {
+		b := make([]byte, recoveryCodeLength*5/8)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		res[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		a.RecoveryCodeHashes[i] = HashRecoveryCode(res[i])
+	}
+	return
+}
+
+func (a *Totp) copy() *Totp {
+	res := *a
+	res.Secret = append([]byte(nil), a.Secret...)
+	res.RecoveryCodeHashes = append([]string(nil), a.RecoveryCodeHashes...)
+	return &res
+}
+
+// HashRecoveryCode is the stored hash of the recovery code, ignoring the case, spaces and dashes
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return tools.Hash(code)
+}
+
+// TotpStep is the time step at the given time
+func TotpStep(now time.Time) int64 {
+	return now.Unix() / int64(TotpPeriod.Seconds())
+}
+
+// TotpCode is the code of the secret at the given time, as an authenticator app shows it
+func TotpCode(secret []byte, now time.Time) string {
+	return totpCode(secret, TotpStep(now))
+}
+
+// totpCode is the RFC 4226 HOTP code of the secret for the counter
+func totpCode(secret []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TotpDigits, value%totpModulo)
+}
@@ -0,0 +1,15 @@
+package model
+
+// TotpCodeRequest proves the second factor with a one-time password or a recovery code
+type TotpCodeRequest struct {
+	Code         string `json:"code" example:"123456"`
+	RecoveryCode string `json:"recoveryCode" example:"abcde-fghij"`
+}
+
+func (a TotpCodeRequest) Validation() (err error) {
+	if (a.Code == "") == (a.RecoveryCode == "") {
+		err = ErrTotpRequired
+		return
+	}
+	return
+}
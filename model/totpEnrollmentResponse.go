@@ -0,0 +1,7 @@
+package model
+
+type TotpEnrollmentResponse struct {
+	// Secret is for typing into an authenticator app, ProvisioningUri for showing as a QR code to scan
+	Secret          string `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	ProvisioningUri string `json:"provisioningUri" example:"otpauth://totp/MVP%20Match:user_name?algorithm=SHA1&digits=6&issuer=MVP+Match&period=30&secret=JBSWY3DPEHPK3PXP"`
+}
@@ -0,0 +1,13 @@
+package model
+
+// TotpRequiredRolesRequest lists the roles whose users must use a second factor
+type TotpRequiredRolesRequest struct {
+	Roles []string `json:"roles" example:"admin"`
+}
+
+// Validation checks the roles against the existing ones, the error lists every unknown one
+func (a TotpRequiredRolesRequest) Validation(roles []*Role) (err error) {
+	res := &ValidationError{}
+	validateRoleNames("roles", a.Roles, roles, res)
+	return res.errorOrNil()
+}
@@ -9,7 +9,7 @@ type UpdateProductRequest struct {
 	Cost            int      `json:"cost" example:"5"`
 }
 
-func (a UpdateProductRequest) Validation() (err error) {
+func (a UpdateProductRequest) Validation(currency *Currency) (err error) {
 	if a.ID == "" {
 		err = ErrInvalidID
 		return
@@ -22,7 +22,7 @@ func (a UpdateProductRequest) Validation() (err error) {
 		err = ErrInvalidAmount
 		return
 	}
-	if a.Cost%5 != 0 || a.Cost <= 0 {
+	if !currency.IsValidCost(a.Cost) {
 		err = ErrInvalidCost
 		return
 	}
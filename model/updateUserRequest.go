@@ -7,16 +7,19 @@ type UpdateUserRequest struct {
 	Password string   `json:"password"`
 }
 
-func (a UpdateUserRequest) Validation() (err error) {
+// Validation checks the request and the password of the user with the given name against the policy, the error
+// lists every violated rule
+func (a UpdateUserRequest) Validation(policy *PasswordPolicy, userName string) (err error) {
+	res := &ValidationError{}
 	if a.ID == "" {
-		err = ErrInvalidID
-		return
+		res.add("id", ValidationRuleRequired, ErrInvalidID)
 	}
 	if a.Password == "" {
-		err = ErrInvalidPassword
-		return
+		res.add("password", ValidationRuleRequired, ErrInvalidPassword)
+	} else {
+		policy.check(a.Password, userName, res)
 	}
 	// TODO: Add separate API for changing other fields?
 
-	return
+	return res.errorOrNil()
 }
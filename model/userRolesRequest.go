@@ -0,0 +1,16 @@
+package model
+
+// UserRolesRequest lists the roles a user holds
+type UserRolesRequest struct {
+	Roles []string `json:"roles" example:"seller"`
+}
+
+// Validation checks the roles against the existing ones, the error lists every unknown one
+func (a UserRolesRequest) Validation(roles []*Role) (err error) {
+	res := &ValidationError{}
+	if len(a.Roles) == 0 {
+		res.add("roles", ValidationRuleRequired, ErrInvalidUserRole)
+	}
+	validateRoleNames("roles", a.Roles, roles, res)
+	return res.errorOrNil()
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// Violation is a validation rule broken by a request field
+type Violation struct {
+	Field   string `json:"field" example:"password"`
+	Rule    string `json:"rule" example:"min-length"`
+	Message string `json:"message" example:"the password should be at least 8 characters long"`
+	err     error
+}
+
+// ValidationError lists every validation rule broken by a request
+type ValidationError struct {
+	Violations []*Violation
+}
+
+func (a *ValidationError) Error() string {
+	messages := make([]string, len(a.Violations))
+	for i, violation := range a.Violations {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ErrorDetails are the violations, put into the error response
+func (a *ValidationError) ErrorDetails() interface{} {
+	return a.Violations
+}
+
+// Is tells if any of the violations is the target error
+func (a *ValidationError) Is(target error) bool {
+	for _, violation := range a.Violations {
+		if errors.Is(violation.err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// add records a violation of the rule by the field
+func (a *ValidationError) add(field string, rule string, err error) {
+	a.Violations = append(a.Violations, &Violation{Field: field, Rule: rule, Message: err.Error(), err: err})
+}
+
+// errorOrNil is the validation error if any rule is violated, nil otherwise
+func (a *ValidationError) errorOrNil() error {
+	if len(a.Violations) == 0 {
+		return nil
+	}
+	return a
+}
@@ -1 +1 @@
-go test  ./test/...  -v -count=1
\ No newline at end of file
+go test  ./test/...  -v -count=1 -race
\ No newline at end of file
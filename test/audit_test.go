@@ -0,0 +1,181 @@
+package test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogMemory(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	doTestAuditLog(t, router, c)
+}
+
+func TestAuditLogSql(t *testing.T) {
+	router, c, _ := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestAuditLog(t, router, c)
+}
+
+func TestAuditLogFailedNotAdmin(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/audit", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+}
+
+func TestAuditLogIsAppendOnlySql(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	router, c, _ := setupSqlTestRouter(t, path)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`UPDATE audit_log SET actor_id = 'someone'`)
+	assert.NotEqual(t, err, nil)
+	_, err = db.Exec(`DELETE FROM audit_log`)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestAuditLogNotRecordedAnswersChangeSql(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	router, c, store := setupSqlTestRouter(t, path)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE TRIGGER audit_log_full BEFORE INSERT ON audit_log BEGIN SELECT RAISE(ABORT, 'full'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deposit is made when it cannot be recorded, so it is answered as done and a retry is not made again
+	w := doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "deposit-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "deposit-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get(controller.IdempotentReplayedHeader), "true")
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Deposit, 50)
+}
+
+// ------- implementation details ---------------
+
+func doTestAuditLog(t *testing.T, router *gin.Engine, c *controller.Controller) {
+	start := time.Now()
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestBuyOk("1", 1, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sellerToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("PATCH", "/api/v1/product/2", `{"id":"2","productName":"Renamed","amountAvailable":5,"cost":30}`, sellerToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	// the admin logs in with the API, so the login is recorded
+	w = doTestRequest("POST", "/api/v1/user/login", `{"userName":"User #4, Admin","password":"4"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var login model.LoginResponse
+	err = json.Unmarshal(w.Body.Bytes(), &login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken := login.Token
+	w = doTestRequest("DELETE", "/api/v1/user/2", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+
+	entries := doTestAudit(t, "actorId=3", adminToken, router)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].Action, model.AuditActionDeposit)
+	assert.Equal(t, entries[0].ActorRole, model.UserRoleBuyer)
+	assert.Equal(t, entries[1].Action, model.AuditActionBuy)
+	assert.Equal(t, entries[1].EntityId, data.OrderId)
+
+	entries = doTestAudit(t, "entityType=product&entityId=2", adminToken, router)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].ActorId, types.Id("1"))
+	var before, after model.Product
+	err = json.Unmarshal(entries[0].Before, &before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(entries[0].After, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.ProductName, "Product #2")
+	assert.Equal(t, after.ProductName, "Renamed")
+
+	// users are recorded without their secrets
+	entries = doTestAudit(t, "entityType=user&entityId=2", adminToken, router)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Action, model.AuditActionUserDelete)
+	assert.Equal(t, strings.Contains(string(entries[0].Before), "User #2, Seller"), true)
+	assert.Equal(t, strings.Contains(string(entries[0].Before), "d4735e3a265e16eee03f59718b9b5d03019c07d8b6c51f90da3a666eec13ab35"), false)
+	assert.Equal(t, len(entries[0].After), 0)
+
+	entries = doTestAudit(t, "from="+url.QueryEscape(start.Add(-time.Minute).Format(time.RFC3339))+"&to="+url.QueryEscape(start.Add(-time.Second).Format(time.RFC3339)), adminToken, router)
+	assert.Equal(t, len(entries), 0)
+	entries = doTestAudit(t, "from="+url.QueryEscape(start.Add(-time.Minute).Format(time.RFC3339)), adminToken, router)
+	// deposit, buy, product update, admin login and user delete
+	assert.Equal(t, len(entries), 5)
+	assert.Equal(t, entries[3].Action, model.AuditActionLogin)
+	assert.Equal(t, entries[3].ActorId, types.Id("4"))
+
+	w = doTestRequest("GET", "/api/v1/audit?from=yesterday", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	// there is no way to change the log
+	w = doTestRequest("DELETE", "/api/v1/audit", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func doTestAudit(t *testing.T, query string, gwtToken string, router *gin.Engine) (res []*model.AuditEntry) {
+	w := doTestRequest("GET", "/api/v1/audit?"+query, "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
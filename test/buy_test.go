@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/assert/v2"
-	"github.com/oltur/mvp-match/controller"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"github.com/oltur/mvp-match/types"
@@ -18,18 +17,18 @@ func TestBuyOk(t *testing.T) {
 	userId := types.Id("3")
 	productId := types.Id("1")
 	amountOfProducts := 2
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	p, err := model.ProductOne(productId)
+	p, err := store.ProductOne(productId)
 	if err != nil {
 		t.Fatal(err)
 	}
 	amountBefore := p.AmountAvailable
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 100
 	_, err = doTestDeposit(coinValue, gwtToken, router)
 	if err != nil {
@@ -48,7 +47,7 @@ func TestBuyOk(t *testing.T) {
 	if len(data.Change) != 2 || data.Change[0].Value != 50 || data.Change[1].Value != 10 {
 		t.Fatal("not the right change")
 	}
-	p, err = model.ProductOne(productId)
+	p, err = store.ProductOne(productId)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,13 +60,13 @@ func TestBuyFailedTwice(t *testing.T) {
 	userId := types.Id("3")
 	productId := types.Id("1")
 	amountOfProducts := 2
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 100
 	_, err = doTestDeposit(coinValue, gwtToken, router)
 	if err != nil {
@@ -89,13 +88,13 @@ func TestBuyFailedNotEnoughDeposit(t *testing.T) {
 	userId := types.Id("3")
 	productId := types.Id("1")
 	amountOfProducts := 2
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 5
 	_, err = doTestDeposit(coinValue, gwtToken, router)
 	if err != nil {
@@ -111,8 +110,8 @@ func TestBuyFailedWrongRole(t *testing.T) {
 	userId := types.Id("2")
 	productId := types.Id("1")
 	amountOfProducts := 2
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #2, Seller", "2")
 	if err != nil {
 		t.Fatal(err)
@@ -127,8 +126,8 @@ func TestBuyFailedWrongProduct(t *testing.T) {
 	userId := types.Id("3")
 	productId := types.Id("999")
 	amountOfProducts := 1
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
@@ -143,13 +142,13 @@ func TestBuyFailedNotEnoughInventory(t *testing.T) {
 	userId := types.Id("3")
 	productId := types.Id("2")
 	amountOfProducts := 2
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 100
 	_, err = doTestDeposit(coinValue, gwtToken, router)
 	if err != nil {
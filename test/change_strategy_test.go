@@ -0,0 +1,184 @@
+package test
+
+import (
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/model"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+var testCoinValues = []int{100, 50, 20, 10, 5}
+
+// changeCase is a random change-making problem small enough for brute force
+type changeCase struct {
+	TotalChange int
+	Available   map[int]int
+}
+
+func (changeCase) Generate(r *rand.Rand, size int) reflect.Value {
+	res := changeCase{
+		TotalChange: 5 * r.Intn(60),
+		Available:   make(map[int]int),
+	}
+	for _, value := range testCoinValues {
+		res.Available[value] = r.Intn(5)
+	}
+	return reflect.ValueOf(res)
+}
+
+func TestMinCoinsChangeStrategyMatchesBruteForce(t *testing.T) {
+	strategy := model.MinCoinsChangeStrategy{}
+	coinCost := func(value int, count int) float64 { return 1 }
+	err := quick.Check(func(cc changeCase) bool {
+		return checkChangeAgainstBruteForce(t, strategy, coinCost, cc)
+	}, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreserveScarceChangeStrategyMatchesBruteForce(t *testing.T) {
+	strategy := model.PreserveScarceChangeStrategy{}
+	err := quick.Check(func(cc changeCase) bool {
+		return checkChangeAgainstBruteForce(t, strategy, model.PreserveScarceCoinCost, cc)
+	}, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGreedyChangeStrategyIsValid(t *testing.T) {
+	strategy := model.GreedyChangeStrategy{}
+	err := quick.Check(func(cc changeCase) bool {
+		change, err := strategy.MakeChange(cc.TotalChange, cc.Available)
+		if err != nil {
+			// greedy may miss solutions, but never invents one
+			return err == model.ErrCannotMakeChange
+		}
+		return isValidChange(change, cc)
+	}, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangeStrategiesWithScarceCoins(t *testing.T) {
+	// greedy takes the 50 and gets stuck
+	_, err := model.GreedyChangeStrategy{}.MakeChange(60, map[int]int{50: 1, 20: 3})
+	assert.Equal(t, err, model.ErrCannotMakeChange)
+	change, err := model.MinCoinsChangeStrategy{}.MakeChange(60, map[int]int{50: 1, 20: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, change, []*model.Coin{{Value: 20}, {Value: 20}, {Value: 20}})
+
+	available := map[int]int{50: 1, 20: 3, 10: 10}
+	change, err = model.MinCoinsChangeStrategy{}.MakeChange(60, available)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, change, []*model.Coin{{Value: 50}, {Value: 10}})
+
+	// the last 50 is kept, the well-filled 10s are used
+	change, err = model.PreserveScarceChangeStrategy{}.MakeChange(60, available)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, change, []*model.Coin{{Value: 10}, {Value: 10}, {Value: 10}, {Value: 10}, {Value: 10}, {Value: 10}})
+}
+
+func TestChangeStrategiesWithLargeAmounts(t *testing.T) {
+	// the work grows with the log of the coin counts, a large change from full tubes is fast
+	available := map[int]int{100: 1000000, 50: 1000000, 20: 1000000, 10: 1000000, 5: 1000000}
+	for _, strategy := range []model.ChangeStrategy{model.MinCoinsChangeStrategy{}, model.PreserveScarceChangeStrategy{}} {
+		change, err := strategy.MakeChange(123455, available)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, isValidChange(change, changeCase{TotalChange: 123455, Available: available}), true)
+	}
+	change, err := model.MinCoinsChangeStrategy{}.MakeChange(123455, available)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(change), 1234+2)
+}
+
+func TestChangeStrategyByName(t *testing.T) {
+	for _, name := range []string{model.ChangeStrategyGreedy, model.ChangeStrategyMinCoins, model.ChangeStrategyPreserveScarce} {
+		strategy, err := model.ChangeStrategyByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, strategy.Name(), name)
+	}
+	_, err := model.ChangeStrategyByName("random")
+	assert.Equal(t, err, model.ErrInvalidChangeStrategy)
+}
+
+// ------- implementation details ---------------
+
+func checkChangeAgainstBruteForce(t *testing.T, strategy model.ChangeStrategy, coinCost func(value int, count int) float64, cc changeCase) bool {
+	bestCost, found := bruteForceChange(cc, coinCost)
+	change, err := strategy.MakeChange(cc.TotalChange, cc.Available)
+	if !found {
+		if err != model.ErrCannotMakeChange {
+			t.Logf("%+v: expected no change, got %v %v", cc, change, err)
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		t.Logf("%+v: expected change, got %v", cc, err)
+		return false
+	}
+	if !isValidChange(change, cc) {
+		t.Logf("%+v: invalid change %v", cc, change)
+		return false
+	}
+	cost := 0.0
+	for _, coin := range change {
+		cost = cost + coinCost(coin.Value, cc.Available[coin.Value])
+	}
+	if math.Abs(cost-bestCost) > 1e-9 {
+		t.Logf("%+v: expected cost %v, got %v", cc, bestCost, cost)
+		return false
+	}
+	return true
+}
+
+// bruteForceChange tries every combination of available coins and returns the lowest cost of an exact change
+func bruteForceChange(cc changeCase, coinCost func(value int, count int) float64) (bestCost float64, found bool) {
+	bestCost = math.Inf(1)
+	var try func(i int, rest int, cost float64)
+	try = func(i int, rest int, cost float64) {
+		if i == len(testCoinValues) {
+			if rest == 0 && cost < bestCost {
+				bestCost = cost
+				found = true
+			}
+			return
+		}
+		value := testCoinValues[i]
+		try(i+1, rest, cost)
+		for k := 1; k <= cc.Available[value] && k*value <= rest; k++ {
+			try(i+1, rest-k*value, cost+float64(k)*coinCost(value, cc.Available[value]))
+		}
+	}
+	try(0, cc.TotalChange, 0)
+	return
+}
+
+func isValidChange(change []*model.Coin, cc changeCase) bool {
+	sum := 0
+	for value, count := range model.CountCoins(change) {
+		if count > cc.Available[value] {
+			return false
+		}
+		sum = sum + value*count
+	}
+	return sum == cc.TotalChange
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckoutOkMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestCheckoutOk(t, router, c, store)
+}
+
+func TestCheckoutOkSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestCheckoutOk(t, router, c, store)
+}
+
+func TestCheckoutFailedNotEnoughInventoryMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestCheckoutFailedNotEnoughInventory(t, router, c, store)
+}
+
+func TestCheckoutFailedNotEnoughInventorySql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestCheckoutFailedNotEnoughInventory(t, router, c, store)
+}
+
+func TestCheckoutFailedEmptyCart(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	w = doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":0}]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func TestCheckoutFailedNotEnoughDeposit(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":1},{"productId":"3","amountOfProducts":1}]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+// ------- implementation details ---------------
+
+func doTestCheckoutOk(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	userId := types.Id("3")
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, coinValue := range []int{50, 50} {
+		_, err = doTestDeposit(coinValue, gwtToken, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":2},{"productId":"3","amountOfProducts":1}]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var data model.CheckoutResponse
+	err = json.Unmarshal(w.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Total, 80)
+	assert.Equal(t, data.Change, []*model.Coin{{Value: 20}})
+	assert.Equal(t, len(data.Lines), 2)
+	assert.Equal(t, *data.Lines[0], model.CheckoutResponseLine{ProductId: "1", ProductName: "Product #1", AmountOfProducts: 2, Cost: 20, Total: 40})
+	assert.Equal(t, *data.Lines[1], model.CheckoutResponseLine{ProductId: "3", ProductName: "Product #3", AmountOfProducts: 1, Cost: 40, Total: 40})
+
+	p, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 998)
+	p, err = store.ProductOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 2999)
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 0)
+}
+
+// doTestCheckoutFailedNotEnoughInventory asks for product #2 twice in separate items, only one is in stock,
+// nothing of the cart must be bought
+func doTestCheckoutFailedNotEnoughInventory(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	userId := types.Id("3")
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":1},{"productId":"2","amountOfProducts":1},{"productId":"2","amountOfProducts":1}]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	p, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 1000)
+	p, err = store.ProductOne("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 1)
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 100)
+}
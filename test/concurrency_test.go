@@ -0,0 +1,205 @@
+package test
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with -race
+
+const (
+	stormWorkers  = 8
+	stormRequests = 25
+)
+
+func TestDepositStormMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestDepositStorm(t, router, c, store)
+}
+
+func TestDepositStormSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestDepositStorm(t, router, c, store)
+}
+
+func TestBuyStormMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestBuyStorm(t, router, c, store)
+}
+
+func TestBuyStormSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestBuyStorm(t, router, c, store)
+}
+
+func TestBuyLastItemStormMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestBuyLastItemStorm(t, router, c, store)
+}
+
+func TestBuyLastItemStormSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestBuyLastItemStorm(t, router, c, store)
+}
+
+// ------- implementation details ---------------
+
+// doTestBuyLastItemStorm lets several buyers race for the single "Product #2" item, exactly one must win
+func doTestBuyLastItemStorm(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	productId := types.Id("2")
+	gwtTokens := make([]string, stormWorkers)
+	for i := range gwtTokens {
+		userName := fmt.Sprintf("Storm buyer #%d", i)
+		_, err := store.UserInsert(&model.User{UserName: userName, PasswordHash: tools.Hash("pw"), Roles: []string{model.UserRoleBuyer}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gwtTokens[i], _, err = c.DoLogin(userName, "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = doTestDeposit(50, gwtTokens[i], router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, stormWorkers)
+	for i := range gwtTokens {
+		wg.Add(1)
+		go func(gwtToken string) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			url := fmt.Sprintf("/api/v1/buy?productId=%s&amountOfProducts=1", productId)
+			req, _ := http.NewRequest("POST", url, nil)
+			req.Header.Add("Authorization", "Bearer "+gwtToken)
+			router.ServeHTTP(w, req)
+			codes <- w.Code
+		}(gwtTokens[i])
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusBadRequest, http.StatusConflict:
+		default:
+			t.Fatalf("unexpected http code %d", code)
+		}
+	}
+	assert.Equal(t, succeeded, 1)
+
+	p, err := store.ProductOne(productId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 0)
+
+	// only the winner has spent the deposit
+	users, err := store.UsersAll("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDeposit := 0
+	for _, u := range users {
+		if u.Deposit == 50 {
+			withDeposit++
+		}
+	}
+	assert.Equal(t, withDeposit, stormWorkers-1)
+}
+
+func doTestDepositStorm(t *testing.T, router *gin.Engine, c *controller.Controller, users model.UserRepository) {
+	userId := types.Id("3")
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, stormWorkers*stormRequests)
+	for i := 0; i < stormWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < stormRequests; j++ {
+				_, err := doTestDeposit(5, gwtToken, router)
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	user, err := users.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Deposit, stormWorkers*stormRequests*5)
+}
+
+func doTestBuyStorm(t *testing.T, router *gin.Engine, c *controller.Controller, products model.ProductRepository) {
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, stormWorkers*stormRequests*3)
+	for i := 0; i < stormWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < stormRequests; j++ {
+				for _, url := range []string{
+					"/api/v1/deposit?coinValue=50",
+					"/api/v1/buy?productId=1&amountOfProducts=1",
+					"/api/v1/buy?productId=2&amountOfProducts=1",
+				} {
+					w := httptest.NewRecorder()
+					req, _ := http.NewRequest("POST", url, nil)
+					req.Header.Add("Authorization", "Bearer "+gwtToken)
+					router.ServeHTTP(w, req)
+					codes <- w.Code
+				}
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v1/product", nil)
+				router.ServeHTTP(w, req)
+			}
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK && code != http.StatusBadRequest {
+			t.Fatalf("unexpected http code %d", code)
+		}
+	}
+
+	p, err := products.ProductOne("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AmountAvailable < 0 {
+		t.Fatal("negative amount available")
+	}
+}
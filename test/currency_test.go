@@ -0,0 +1,121 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDepositReportsCurrency(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestDeposit(5, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Currency, "EUR")
+}
+
+func TestCustomCurrencyBuyOk(t *testing.T) {
+	router, c, _ := setupTestRouterWithConfig(t, testChfConfig())
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := doTestDeposit(200, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, deposit.Currency, "CHF")
+
+	data, err := doTestBuyOk(types.Id("1"), 1, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Currency, "CHF")
+	assert.Equal(t, data.Change, []*model.Coin{{Value: 100}, {Value: 50}, {Value: 20}, {Value: 10}})
+}
+
+func TestCustomCurrencyDepositFailedWrongCoinValue(t *testing.T) {
+	router, c, _ := setupTestRouterWithConfig(t, testChfConfig())
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 5 is a default coin, but not a CHF one
+	w := doTestRequest("POST", "/api/v1/deposit?coinValue=5", "", gwtToken, router)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var res httputil.HTTPError
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Message, model.ErrInvalidCoinValue.Error())
+}
+
+func TestCustomCurrencyAddProductFailedWrongCost(t *testing.T) {
+	router, c, _ := setupTestRouterWithConfig(t, testChfConfig())
+	gwtToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/product", `{"productName": "Chocolate", "amountAvailable": 1, "cost": 25}`, gwtToken, router)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var res httputil.HTTPError
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Message, model.ErrInvalidCost.Error())
+
+	w = doTestRequest("POST", "/api/v1/product", `{"productName": "Chocolate", "amountAvailable": 1, "cost": 30}`, gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNewCurrencyFailedInvalid(t *testing.T) {
+	_, err := model.NewCurrency("", []int{5}, 5)
+	assert.Equal(t, err, model.ErrInvalidCurrency)
+	_, err = model.NewCurrency("EUR", []int{5, 12}, 5)
+	assert.Equal(t, err, model.ErrInvalidCurrency)
+	_, err = model.NewCurrency("EUR", nil, 5)
+	assert.Equal(t, err, model.ErrInvalidCurrency)
+}
+
+func TestConfigFromEnvFailedUnparsable(t *testing.T) {
+	t.Setenv("MVP_COIN_VALUES", "5,1O,20")
+	t.Setenv("MVP_LOGIN_LOCKOUT", "1 minute")
+	t.Setenv("MVP_SMALLEST_UNIT", "5")
+	_, err := config.FromEnv()
+	assert.Equal(t, errors.Is(err, model.ErrInvalidEnvValue), true)
+	assert.Equal(t, strings.Contains(err.Error(), "MVP_COIN_VALUES"), true)
+	assert.Equal(t, strings.Contains(err.Error(), "MVP_LOGIN_LOCKOUT"), true)
+	assert.Equal(t, strings.Contains(err.Error(), "MVP_SMALLEST_UNIT"), false)
+
+	t.Setenv("MVP_COIN_VALUES", "10, 20,50")
+	t.Setenv("MVP_LOGIN_LOCKOUT", "2m")
+	cfg, err := config.FromEnv()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cfg.CoinValues, []int{10, 20, 50})
+	assert.Equal(t, cfg.LoginLockout, 2*time.Minute)
+}
+
+// ------- implementation details ---------------
+
+func testChfConfig() *config.Config {
+	cfg := testConfig()
+	cfg.CurrencyCode = "CHF"
+	cfg.CoinValues = []int{10, 20, 50, 100, 200, 500}
+	cfg.SmallestUnit = 10
+	return cfg
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestDepositShowsCoins(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data model.DepositResponse
+	for _, coinValue := range []int{20, 5, 20} {
+		data, err = doTestDeposit(coinValue, gwtToken, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, data.Deposit, 45)
+	assert.Equal(t, data.Coins, []*model.CoinTube{{Value: 20, Count: 2}, {Value: 5, Count: 1}})
+}
+
+func TestResetChangePolicy(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		_, err = doTestDeposit(5, gwtToken, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	res := doTestReset(t, gwtToken, router)
+	assert.Equal(t, res.Change, []*model.Coin{{Value: 20}})
+}
+
+func TestResetExactCoinsPolicy(t *testing.T) {
+	router, c, store := setupTestRouterWithConfig(t, testExactCoinsConfig())
+	tubesBefore, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		_, err = doTestDeposit(5, gwtToken, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	res := doTestReset(t, gwtToken, router)
+	assert.Equal(t, res.Change, []*model.Coin{{Value: 5}, {Value: 5}, {Value: 5}, {Value: 5}})
+
+	// the inserted coins never reached the tubes
+	tubesAfter, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tubesAfter, tubesBefore)
+}
+
+func TestSqlStoreRefundExactCoins(t *testing.T) {
+	userId := types.Id("3")
+	_, _, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, coinValue := range []int{50, 10, 10} {
+		_, err := store.Deposit(userId, &model.Coin{Value: coinValue})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.DepositCoins, []*model.CoinTube{{Value: 50, Count: 1}, {Value: 10, Count: 2}})
+
+	change, err := store.Refund(userId, true, model.MinCoinsChangeStrategy{}.MakeChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, change, []*model.Coin{{Value: 50}, {Value: 10}, {Value: 10}})
+
+	u, err = store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 0)
+	assert.Equal(t, len(u.DepositCoins), 0)
+}
+
+// ------- implementation details ---------------
+
+func doTestReset(t *testing.T, gwtToken string, router *gin.Engine) (res model.ResetResponse) {
+	w := doTestRequest("POST", "/api/v1/reset", "", gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func testExactCoinsConfig() *config.Config {
+	cfg := testConfig()
+	cfg.RefundPolicy = model.RefundPolicyExactCoins
+	return cfg
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/oltur/mvp-match/controller"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"github.com/oltur/mvp-match/types"
@@ -15,13 +14,13 @@ import (
 
 func TestDepositOk(t *testing.T) {
 	userId := types.Id("3")
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 5
 	data, err := doTestDeposit(coinValue, gwtToken, router)
 	if err != nil {
@@ -34,13 +33,13 @@ func TestDepositOk(t *testing.T) {
 
 func TestDepositOkTwice(t *testing.T) {
 	userId := types.Id("3")
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue1 := 5
 	data, err := doTestDeposit(coinValue1, gwtToken, router)
 	if err != nil {
@@ -58,13 +57,13 @@ func TestDepositOkTwice(t *testing.T) {
 
 func TestDepositFailedWrongCoinValue(t *testing.T) {
 	userId := types.Id("3")
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 4
 	w := httptest.NewRecorder()
 	url := fmt.Sprintf("/api/v1/deposit?coinValue=%d", coinValue)
@@ -90,13 +89,13 @@ func TestDepositFailedWrongCoinValue(t *testing.T) {
 
 func TestDepositFailedWrongRole(t *testing.T) {
 	userId := types.Id("2")
-	router, c := controller.SetupRouter()
-	model.UserLogout(userId)
+	router, c, store := setupTestRouter(t)
+	store.UserLogout(userId)
 	gwtToken, _, err := c.DoLogin("User #2, Seller", "2")
 	if err != nil {
 		t.Fatal(err)
 	}
-	model.UserResetDeposit(userId)
+	store.UserResetDeposit(userId)
 	coinValue := 5
 	w := httptest.NewRecorder()
 	url := fmt.Sprintf("/api/v1/deposit?coinValue=%d", coinValue)
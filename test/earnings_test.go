@@ -0,0 +1,127 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestEarningsAndPayoutMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestEarningsAndPayout(t, router, c, store)
+}
+
+func TestEarningsAndPayoutSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestEarningsAndPayout(t, router, c, store)
+}
+
+func TestEarningsFailedAccessDenied(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	sellerToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/earnings?sellerId=2", "", sellerToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("POST", "/api/v1/payouts", `{}`, sellerToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doTestRequest("GET", "/api/v1/earnings", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+// ------- implementation details ---------------
+
+func doTestEarningsAndPayout(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 x 20 for seller #1 and 1 x 40 for seller #2
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":2},{"productId":"3","amountOfProducts":1}]}`, buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	sellerToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	earnings := doTestEarnings(t, "/api/v1/earnings", sellerToken, router)
+	assert.Equal(t, earnings.SellerId, types.Id("1"))
+	assert.Equal(t, earnings.Balance, 40)
+	assert.Equal(t, earnings.TotalSales, 40)
+	assert.Equal(t, len(earnings.Entries), 1)
+	assert.Equal(t, earnings.Entries[0].ProductId, types.Id("1"))
+
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doTestRequest("POST", "/api/v1/payouts", `{"sellerId":"1"}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var payouts []*model.Payout
+	err = json.Unmarshal(w.Body.Bytes(), &payouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(payouts), 1)
+	assert.Equal(t, payouts[0].SellerId, types.Id("1"))
+	assert.Equal(t, payouts[0].Amount, 40)
+	assert.Equal(t, payouts[0].PaidBy, types.Id("4"))
+
+	earnings = doTestEarnings(t, "/api/v1/earnings", sellerToken, router)
+	assert.Equal(t, earnings.Balance, 0)
+	assert.Equal(t, earnings.TotalPayouts, 40)
+	assert.Equal(t, len(earnings.Entries), 2)
+	assert.Equal(t, earnings.Entries[1].PayoutId, payouts[0].ID)
+
+	// settling all sellers pays seller #2 only, seller #1 has nothing left
+	w = doTestRequest("POST", "/api/v1/payouts", `{}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err = json.Unmarshal(w.Body.Bytes(), &payouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(payouts), 1)
+	assert.Equal(t, payouts[0].SellerId, types.Id("2"))
+	assert.Equal(t, payouts[0].Amount, 40)
+
+	all, err := store.PayoutsAll("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(all), 2)
+	earnings = doTestEarnings(t, "/api/v1/earnings?sellerId=2", adminToken, router)
+	assert.Equal(t, earnings.Balance, 0)
+
+	// the ledger is balanced
+	machine, err := store.LedgerBalance(model.LedgerAccountMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, machine, 0)
+}
+
+func doTestEarnings(t *testing.T, url string, gwtToken string, router *gin.Engine) (res model.EarningsResponse) {
+	w := doTestRequest("GET", url, "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
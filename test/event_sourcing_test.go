@@ -0,0 +1,447 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventReplayMemory(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	doTestEventReplay(t, router, c)
+}
+
+func TestEventReplaySql(t *testing.T) {
+	router, c, _ := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestEventReplay(t, router, c)
+}
+
+func TestEventLogFileSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	router, c, store := setupEventLogTestRouter(t, filepath.Join(dir, "test.db"), filepath.Join(dir, "events.jsonl"))
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, c, _ = setupEventLogTestRouter(t, filepath.Join(dir, "test.db"), filepath.Join(dir, "events.jsonl"))
+	_, err = doTestDeposit(20, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the persistent store is snapshotted once only, before it is seeded
+	events := doTestEvents(t, "", adminToken, router)
+	snapshots := 0
+	for _, event := range events {
+		if event.Type == model.EventSnapshot {
+			snapshots++
+		}
+	}
+	assert.Equal(t, snapshots, 1)
+	assert.Equal(t, events[0].Type, model.EventSnapshot)
+	assert.Equal(t, events[len(events)-1].Type, model.EventDeposit)
+	assert.Equal(t, events[len(events)-1].Seq, int64(len(events)))
+	state := doTestMachineState(t, "", adminToken, router)
+	assert.Equal(t, state.Deposits["3"], 70)
+	assert.Equal(t, len(doTestReconcile(t, adminToken, router)), 0)
+}
+
+func TestEventLogRestoresMemoryStoreOnRestart(t *testing.T) {
+	eventLogPath := filepath.Join(t.TempDir(), "events.jsonl")
+	router, c, _ := setupMemoryEventLogTestRouter(t, eventLogPath)
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/buy?productId=1&amountOfProducts=1", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	_, err = doTestDeposit(20, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted memory store is seeded again and gets the replayed deposits, stock and coins
+	router, c, store := setupMemoryEventLogTestRouter(t, eventLogPath)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Deposit, 20)
+	product, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, product.AmountAvailable, 999)
+
+	// the sessions are not kept
+	buyerToken, _, err = c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := doTestEvents(t, "", adminToken, router)
+	snapshots := 0
+	for _, event := range events {
+		if event.Type == model.EventSnapshot {
+			snapshots++
+		}
+	}
+	assert.Equal(t, snapshots, 1)
+	assert.Equal(t, doTestMachineState(t, "", adminToken, router).Deposits["3"], 70)
+	assert.Equal(t, len(doTestReconcile(t, adminToken, router)), 0)
+}
+
+func TestReconcileFindsChangesBypassingEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	router, c, _ := setupSqlTestRouter(t, path)
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doTestMachineState(t, "", adminToken, router)
+
+	// written to the database by another process, no event is recorded
+	other, err := model.NewSqlStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	p, err := other.ProductOne("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AmountAvailable = 5
+	err = other.ProductSave(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := doTestReconcile(t, adminToken, router)
+	assert.Equal(t, len(res), 1)
+	assert.Equal(t, *res[0], model.Discrepancy{Kind: model.DiscrepancyStock, Id: "2", Expected: 1, Actual: 5})
+}
+
+func TestEventNotRecordedFailsOperationMemory(t *testing.T) {
+	eventLogPath := filepath.Join(t.TempDir(), "events.jsonl")
+	cfg := testConfig()
+	cfg.EventLogPath = eventLogPath
+	router, c, store := setupTestRouterWithConfig(t, cfg)
+	doTestEventNotRecordedFailsOperation(t, router, c, store, eventLogPath)
+}
+
+func TestEventNotRecordedFailsOperationSql(t *testing.T) {
+	dir := t.TempDir()
+	eventLogPath := filepath.Join(dir, "events.jsonl")
+	router, c, store := setupEventLogTestRouter(t, filepath.Join(dir, "test.db"), eventLogPath)
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(eventLogPath, eventLogPath+".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(eventLogPath, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the event is recorded after the commit, the deposit fails but stays, with its event in the outbox
+	w := doTestRequest("POST", "/api/v1/deposit?coinValue=20", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	// the pending event is recorded first, so the purchase fails without a change
+	w = doTestRequest("POST", "/api/v1/buy?productId=1&amountOfProducts=1", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Deposit, 70)
+	product, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, product.AmountAvailable, 1000)
+
+	err = os.Remove(eventLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(eventLogPath+".bak", eventLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := doTestEvents(t, "", adminToken, router)
+	assert.Equal(t, events[len(events)-1].Type, model.EventDeposit)
+	assert.Equal(t, doTestMachineState(t, "", adminToken, router).Deposits["3"], 70)
+	assert.Equal(t, len(doTestReconcile(t, adminToken, router)), 0)
+}
+
+func TestEventsFailedNotAdmin(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/events", "/state", "/reconcile"} {
+		w := doTestRequest("GET", "/api/v1/machine"+path, "", gwtToken, router)
+		assert.Equal(t, w.Code, http.StatusForbidden)
+	}
+}
+
+func TestFileEventStoreFailedCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := model.NewFileEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = events.EventAppend(&model.Event{Type: model.EventCoinsEmptied, At: time.Now(), Data: json.RawMessage(`{"value":0}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = events.EventAppend(&model.Event{Seq: 7, Type: model.EventCoinsEmptied, At: time.Now(), Data: json.RawMessage(`{"value":5}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sequence numbers are assigned by the store, so the log reads back in order
+	events, err = model.NewFileEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := events.EventsCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, count, int64(2))
+
+	err = appendTestFile(path, "{not json\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = model.NewFileEventStore(path)
+	assert.NotEqual(t, err, nil)
+}
+
+// ------- implementation details ---------------
+
+func doTestEventReplay(t *testing.T, router *gin.Engine, c *controller.Controller) {
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(20, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterDeposits := doTestEvents(t, "", adminToken, router)
+	_, err = doTestBuyOk("1", 2, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doTestReset(t, buyerToken, router)
+	w := doTestRequest("POST", "/api/v1/machine/coins", `{"coins":[{"value":5,"count":3}]}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// the current state matches the store
+	assert.Equal(t, len(doTestReconcile(t, adminToken, router)), 0)
+	state := doTestMachineState(t, "", adminToken, router)
+	assert.Equal(t, state.Deposits["3"], 0)
+	assert.Equal(t, state.Stock["1"], 998)
+	assert.Equal(t, state.Coins[5], 13)
+
+	// the state right after the deposits
+	last := afterDeposits[len(afterDeposits)-1]
+	assert.Equal(t, last.Type, model.EventDeposit)
+	state = doTestMachineState(t, "at="+url.QueryEscape(last.At.Format(time.RFC3339Nano)), adminToken, router)
+	assert.Equal(t, state.Seq, last.Seq)
+	assert.Equal(t, state.Deposits["3"], 70)
+	assert.Equal(t, state.Stock["1"], 1000)
+	assert.Equal(t, state.Coins[5], 10)
+
+	events := doTestEvents(t, "from="+url.QueryEscape(last.At.Format(time.RFC3339Nano)), adminToken, router)
+	eventTypes := make([]string, len(events))
+	for i, event := range events {
+		eventTypes[i] = event.Type
+	}
+	assert.Equal(t, eventTypes, []string{model.EventDeposit, model.EventPurchase, model.EventDeposit, model.EventReset, model.EventCoinsLoaded})
+}
+
+// doTestEventNotRecordedFailsOperation makes the event log unwritable and checks that the operations fail without
+// changing the memory store
+func doTestEventNotRecordedFailsOperation(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store, eventLogPath string) {
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(eventLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(eventLogPath, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := doTestRequest("POST", "/api/v1/deposit?coinValue=20", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	w = doTestRequest("POST", "/api/v1/buy?productId=1&amountOfProducts=1", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Deposit, 50)
+	product, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, product.AmountAvailable, 1000)
+}
+
+func setupEventLogTestRouter(t *testing.T, path string, eventLogPath string) (*gin.Engine, *controller.Controller, *model.SqlStore) {
+	store, err := model.NewSqlStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	cfg := testConfig()
+	cfg.Storage = config.StorageSqlite
+	cfg.EventLogPath = eventLogPath
+	// the tokens outlive the restart
+	cfg.JwtKeys = "k1:" + testJwtSecret1
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.SeedIfEmpty(store, c.Currency(), c.PasswordHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return controller.SetupRouter(c), c, store
+}
+
+// setupMemoryEventLogTestRouter starts a memory store with a file event log the way main does, seeded before the log
+// is replayed into it
+func setupMemoryEventLogTestRouter(t *testing.T, eventLogPath string) (*gin.Engine, *controller.Controller, *model.MemoryStore) {
+	store := model.NewMemoryStore()
+	cfg := testConfig()
+	cfg.EventLogPath = eventLogPath
+	currency, err := cfg.Currency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := model.PasswordHasherByName(cfg.PasswordHash, cfg.PasswordHashCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.SeedIfEmpty(store, currency, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return controller.SetupRouter(c), c, store
+}
+
+func doTestEvents(t *testing.T, query string, gwtToken string, router *gin.Engine) (res []*model.Event) {
+	w := doTestRequest("GET", "/api/v1/machine/events?"+query, "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestMachineState(t *testing.T, query string, gwtToken string, router *gin.Engine) (res *model.MachineState) {
+	w := doTestRequest("GET", "/api/v1/machine/state?"+query, "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestReconcile(t *testing.T, gwtToken string, router *gin.Engine) (res []*model.Discrepancy) {
+	w := doTestRequest("GET", "/api/v1/machine/reconcile", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func appendTestFile(path string, s string) (err error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.WriteString(s)
+	return
+}
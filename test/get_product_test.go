@@ -3,7 +3,6 @@ package test
 import (
 	"encoding/json"
 	"github.com/go-playground/assert/v2"
-	"github.com/oltur/mvp-match/controller"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"net/http"
@@ -12,7 +11,7 @@ import (
 )
 
 func TestGetProductOk(t *testing.T) {
-	router, _ := controller.SetupRouter()
+	router, _, _ := setupTestRouter(t)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/product/1", nil)
 	router.ServeHTTP(w, req)
@@ -31,7 +30,7 @@ func TestGetProductOk(t *testing.T) {
 }
 
 func TestGetProductFailedDoesNotExist(t *testing.T) {
-	router, _ := controller.SetupRouter()
+	router, _, _ := setupTestRouter(t)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/product/999", nil)
 	router.ServeHTTP(w, req)
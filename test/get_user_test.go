@@ -3,7 +3,6 @@ package test
 import (
 	"encoding/json"
 	"github.com/go-playground/assert/v2"
-	"github.com/oltur/mvp-match/controller"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"net/http"
@@ -12,9 +11,9 @@ import (
 )
 
 func TestGetUserOk(t *testing.T) {
-	router, c := controller.SetupRouter()
+	router, c, store := setupTestRouter(t)
 	w := httptest.NewRecorder()
-	model.UserLogout("1")
+	store.UserLogout("1")
 	gwtToken, _, err := c.DoLogin("User #1, Seller", "1")
 	if err != nil {
 		t.Fatal(err)
@@ -37,9 +36,9 @@ func TestGetUserOk(t *testing.T) {
 }
 
 func TestGetUserFailedDoesNotExistNotAdmin(t *testing.T) {
-	router, c := controller.SetupRouter()
+	router, c, store := setupTestRouter(t)
 	w := httptest.NewRecorder()
-	model.UserLogout("1")
+	store.UserLogout("1")
 	gwtToken, _, err := c.DoLogin("User #1, Seller", "1")
 	if err != nil {
 		t.Fatal(err)
@@ -62,9 +61,9 @@ func TestGetUserFailedDoesNotExistNotAdmin(t *testing.T) {
 }
 
 func TestGetUserFailedAccessDeniedNotAdmin(t *testing.T) {
-	router, c := controller.SetupRouter()
+	router, c, store := setupTestRouter(t)
 	w := httptest.NewRecorder()
-	model.UserLogout("1")
+	store.UserLogout("1")
 	gwtToken, _, err := c.DoLogin("User #1, Seller", "1")
 	if err != nil {
 		t.Fatal(err)
@@ -87,9 +86,9 @@ func TestGetUserFailedAccessDeniedNotAdmin(t *testing.T) {
 }
 
 func TestGetUserFailedDoesNotExistAdmin(t *testing.T) {
-	router, c := controller.SetupRouter()
+	router, c, store := setupTestRouter(t)
 	w := httptest.NewRecorder()
-	model.UserLogout("4")
+	store.UserLogout("4")
 	gwtToken, _, err := c.DoLogin("User #4, Admin", "4")
 	if err != nil {
 		t.Fatal(err)
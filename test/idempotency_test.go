@@ -0,0 +1,190 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentDepositMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestIdempotentDeposit(t, router, c, store)
+}
+
+func TestIdempotentDepositSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestIdempotentDeposit(t, router, c, store)
+}
+
+func TestIdempotentBuy(t *testing.T) {
+	productId := types.Id("1")
+	router, c, store := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "/api/v1/buy?productId=" + string(productId) + "&amountOfProducts=2"
+	first := doTestIdempotentRequest("POST", url, "", gwtToken, "buy-1", router)
+	assert.Equal(t, first.Code, http.StatusOK)
+	second := doTestIdempotentRequest("POST", url, "", gwtToken, "buy-1", router)
+	assert.Equal(t, second.Code, http.StatusOK)
+	assert.Equal(t, second.Body.String(), first.Body.String())
+
+	p, err := store.ProductOne(productId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 998)
+}
+
+func TestIdempotencyKeyReusedWithDifferentRequest(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "key-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=20", "", gwtToken, "key-1", router)
+	assert.Equal(t, w.Code, http.StatusUnprocessableEntity)
+
+	w = doTestIdempotentRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":1}]}`, gwtToken, "key-2", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestIdempotentRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":2}]}`, gwtToken, "key-2", router)
+	assert.Equal(t, w.Code, http.StatusUnprocessableEntity)
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	cfg := testConfig()
+	cfg.IdempotencyRetention = time.Millisecond
+	router, c, store := setupTestRouterWithConfig(t, cfg)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "key-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	time.Sleep(5 * time.Millisecond)
+	w = doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "key-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get(controller.IdempotentReplayedHeader), "")
+
+	u, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 100)
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	_, _, sqlStore := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, store := range []model.Store{model.NewMemoryStore(), sqlStore} {
+		now := time.Now()
+		req := &model.IdempotencyRecord{UserId: "3", Key: "key-1", Fingerprint: "a", CreatedAt: now}
+		existing, err := store.IdempotencyBegin(req, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, existing == nil, true)
+
+		existing, err = store.IdempotencyBegin(req, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, existing.InProgress(), true)
+
+		err = store.IdempotencyComplete("3", "key-1", http.StatusOK, []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, err = store.IdempotencyBegin(req, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, existing.Status, http.StatusOK)
+		assert.Equal(t, string(existing.Body), `{}`)
+
+		// the same key of another user is free
+		other := &model.IdempotencyRecord{UserId: "4", Key: "key-1", Fingerprint: "a", CreatedAt: now}
+		existing, err = store.IdempotencyBegin(other, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, existing == nil, true)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	_, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.POST("/panic", c.Auth(), c.Idempotent(), func(ctx *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		ctx.JSON(http.StatusOK, "Ok")
+	})
+
+	w := doTestIdempotentRequest("POST", "/panic", "", gwtToken, "panic-1", router)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	// the retry is executed, not refused as in progress
+	w = doTestIdempotentRequest("POST", "/panic", "", gwtToken, "panic-1", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, calls, 2)
+}
+
+// ------- implementation details ---------------
+
+func doTestIdempotentDeposit(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "deposit-1", router)
+	assert.Equal(t, first.Code, http.StatusOK)
+	assert.Equal(t, first.Header().Get(controller.IdempotentReplayedHeader), "")
+	second := doTestIdempotentRequest("POST", "/api/v1/deposit?coinValue=50", "", gwtToken, "deposit-1", router)
+	assert.Equal(t, second.Code, http.StatusOK)
+	assert.Equal(t, second.Header().Get(controller.IdempotentReplayedHeader), "true")
+
+	var data model.DepositResponse
+	err = json.Unmarshal(second.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Deposit, 50)
+
+	u, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 50)
+}
+
+func doTestIdempotentRequest(method string, url string, body string, gwtToken string, key string, router *gin.Engine) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+gwtToken)
+	req.Header.Add(controller.IdempotencyKeyHeader, key)
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/go-playground/assert/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJwtRs256WithJwks(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := model.NewMemoryStore()
+	_, c := setupJwtKeysTestRouter(t, store, "k1:"+testJwtSecret1)
+	err = model.Seed(store, c.Currency(), c.PasswordHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the private key signs new tokens, the secret keeps verifying the tokens it signed before
+	cfg := testConfig()
+	cfg.JwtKeys = "k1:" + testJwtSecret1
+	cfg.JwtPemKeys = "rsa1:" + writeTestPem(t, t.TempDir(), "rsa1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	c, err = controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := controller.SetupRouter(c)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/user/3", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/user/1", "", hsToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// another service verifies the token with the published key, the secret is not published
+	jwks := doTestJwks(t, c)
+	assert.Equal(t, len(jwks.Keys), 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, jwk.Kid, "rsa1")
+	assert.Equal(t, jwk.Alg, "RS256")
+	assert.Equal(t, jwk.E, "AQAB")
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	token, err := jwt.Parse(gwtToken, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, token.Header["kid"], jwk.Kid)
+		return public, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, token.Method.Alg(), "RS256")
+}
+
+func TestJwtEs256WithJwks(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.JwtPemKeys = "ec1:" + writeTestPem(t, t.TempDir(), "ec1.pem", "PRIVATE KEY", b)
+	router, c, _ := setupTestRouterWithConfig(t, cfg)
+
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testTokenKeyId(t, gwtToken), "ec1")
+	w := doTestRequest("GET", "/api/v1/user/3", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	jwks := doTestJwks(t, c)
+	assert.Equal(t, len(jwks.Keys), 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, jwk.Kty, "EC")
+	assert.Equal(t, jwk.Crv, "P-256")
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	_, err = jwt.Parse(gwtToken, func(token *jwt.Token) (interface{}, error) { return public, nil })
+	assert.Equal(t, err, nil)
+}
+
+func TestJwtFailedAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.JwtPemKeys = "rsa1:" + writeTestPem(t, t.TempDir(), "rsa1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	router, c, _ := setupTestRouterWithConfig(t, cfg)
+	_, _, err = c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the published public key used as an HMAC secret
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userId": "4", "token": "x", "expires": "0"})
+	jwtToken.Header["kid"] = "rsa1"
+	forged, err := jwtToken.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/user/4", "", forged, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+}
+
+func TestJwtPemKeysFailedInvalid(t *testing.T) {
+	dir := t.TempDir()
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Der, err := x509.MarshalECPrivateKey(p384Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(&p384Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256PublicDer, err := x509.MarshalPKIXPublicKey(&p256Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]error{
+		"small:" + writeTestPem(t, dir, "small.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallKey)): model.ErrUnsupportedJwtKey,
+		"p384:" + writeTestPem(t, dir, "p384.pem", "EC PRIVATE KEY", p384Der):                                  model.ErrUnsupportedJwtKey,
+		"public384:" + writeTestPem(t, dir, "public384.pem", "PUBLIC KEY", publicDer):                          model.ErrUnsupportedJwtKey,
+		"public:" + writeTestPem(t, dir, "public.pem", "PUBLIC KEY", p256PublicDer):                            model.ErrNoJwtSigningKey,
+		"nopath": model.ErrInvalidJwtKeys,
+	}
+	for keys, expected := range cases {
+		cfg := testConfig()
+		cfg.JwtPemKeys = keys
+		_, err := controller.NewController(model.NewMemoryStore(), cfg)
+		assert.Equal(t, errors.Is(err, expected), true)
+	}
+}
+
+// ------- implementation details ---------------
+
+func writeTestPem(t *testing.T, dir string, name string, blockType string, der []byte) (path string) {
+	path = filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestJwks(t *testing.T, c *controller.Controller) (res model.JwksResponse) {
+	w := doTestRequest("GET", "/.well-known/jwks.json", "", "", controller.SetupRouter(c))
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/go-playground/assert/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJwtStandardClaims(t *testing.T) {
+	_, c := setupJwtKeysTestRouter(t, seededTestStore(t), "k1:"+testJwtSecret1)
+	gwtToken, tokenExpires, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &jwt.StandardClaims{}
+	_, err = jwt.ParseWithClaims(gwtToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(testJwtSecret1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, claims.Subject, "3")
+	assert.Equal(t, claims.Issuer, "mvp-match")
+	assert.Equal(t, claims.Audience, "mvp-match")
+	assert.Equal(t, claims.ExpiresAt*1000, tokenExpires)
+	assert.NotEqual(t, claims.Id, "")
+	if claims.IssuedAt > time.Now().Unix() || claims.IssuedAt < time.Now().Add(-time.Minute).Unix() {
+		t.Fatal("wrong iat")
+	}
+}
+
+func TestJwtFailedUnauthorized(t *testing.T) {
+	router, c := setupJwtKeysTestRouter(t, seededTestStore(t), "k1:"+testJwtSecret1)
+	gwtToken, tokenExpires, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.StandardClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(gwtToken, &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, claims.ExpiresAt*1000, tokenExpires)
+
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	wrongIssuer := claims
+	wrongIssuer.Issuer = "someone"
+	wrongAudience := claims
+	wrongAudience.Audience = "another-service"
+	noSubject := claims
+	noSubject.Subject = ""
+
+	cases := map[string]error{
+		"garbage":      model.ErrTokenMalformed,
+		gwtToken + "x": model.ErrTokenSignatureInvalid,
+		signTestToken(t, "k1", testJwtSecret2, claims):        model.ErrTokenSignatureInvalid,
+		signTestToken(t, "k9", testJwtSecret1, claims):        model.ErrTokenSignatureInvalid,
+		signTestToken(t, "k1", testJwtSecret1, expired):       model.ErrTokenExpired,
+		signTestToken(t, "k1", testJwtSecret1, wrongIssuer):   model.ErrTokenClaimsInvalid,
+		signTestToken(t, "k1", testJwtSecret1, wrongAudience): model.ErrTokenClaimsInvalid,
+		signTestToken(t, "k1", testJwtSecret1, noSubject):     model.ErrTokenClaimsInvalid,
+	}
+	for token, expected := range cases {
+		w := doTestRequest("GET", "/api/v1/user/3", "", token, router)
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+		var data httputil.HTTPError
+		err = json.Unmarshal(w.Body.Bytes(), &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, data.Message, expected.Error())
+	}
+
+	// a valid token of an ended session
+	w := doTestRequest("POST", "/api/v1/user/logout", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("GET", "/api/v1/user/3", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+}
+
+// ------- implementation details ---------------
+
+func seededTestStore(t *testing.T) (res *model.MemoryStore) {
+	res = model.NewMemoryStore()
+	currency, err := model.NewCurrency("EUR", []int{5, 10, 20, 50, 100}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.Seed(res, currency, model.BcryptPasswordHasher{Cost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func signTestToken(t *testing.T, kid string, secret string, claims jwt.StandardClaims) string {
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	jwtToken.Header["kid"] = kid
+	res, err := jwtToken.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
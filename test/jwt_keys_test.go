@@ -0,0 +1,119 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testJwtSecret1 = "0123456789abcdef0123456789abcdef"
+	testJwtSecret2 = "fedcba9876543210fedcba9876543210"
+)
+
+func TestJwtKeyRotation(t *testing.T) {
+	store := model.NewMemoryStore()
+	router, c := setupJwtKeysTestRouter(t, store, "k1:"+testJwtSecret1)
+	err := model.Seed(store, c.Currency(), c.PasswordHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testTokenKeyId(t, oldToken), "k1")
+	w := doTestRequest("GET", "/api/v1/user/3", "", oldToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// a new key signs new tokens, the old one is still accepted
+	router, c = setupJwtKeysTestRouter(t, store, "k2:"+testJwtSecret2+", k1:"+testJwtSecret1)
+	newToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testTokenKeyId(t, newToken), "k2")
+	w = doTestRequest("GET", "/api/v1/user/3", "", oldToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/user/1", "", newToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// the old key is retired
+	router, _ = setupJwtKeysTestRouter(t, store, "k2:"+testJwtSecret2)
+	w = doTestRequest("GET", "/api/v1/user/3", "", oldToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("GET", "/api/v1/user/1", "", newToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestJwtKeysFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt-keys")
+	err := os.WriteFile(path, []byte("# signing key first\nk2:"+testJwtSecret2+"\n\nk1:"+testJwtSecret1+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{JwtKeys: "ignored", JwtKeysFile: path}
+	res, err := cfg.SigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res, []*config.JwtKey{
+		{Id: "k2", Algorithm: config.JwtAlgorithmHS256, Secret: []byte(testJwtSecret2)},
+		{Id: "k1", Algorithm: config.JwtAlgorithmHS256, Secret: []byte(testJwtSecret1)},
+	})
+}
+
+func TestJwtKeysFailedInvalid(t *testing.T) {
+	cases := map[string]error{
+		"k1":                 model.ErrInvalidJwtKeys,
+		":" + testJwtSecret1: model.ErrInvalidJwtKeys,
+		"k1:" + testJwtSecret1 + ",k1:" + testJwtSecret2: model.ErrInvalidJwtKeys,
+		"k1:short": model.ErrJwtSecretTooShort,
+	}
+	for keys, expected := range cases {
+		cfg := testConfig()
+		cfg.JwtKeys = keys
+		_, err := controller.NewController(model.NewMemoryStore(), cfg)
+		assert.Equal(t, err, expected)
+	}
+}
+
+func TestJwtTokenFailedForgedWithOldDefaultKey(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userId": "4", "token": "x", "expires": "0"})
+	forged, err := jwtToken.SignedString([]byte("xxxxxxxxxxx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/user/4", "", forged, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+}
+
+// ------- implementation details ---------------
+
+// setupJwtKeysTestRouter creates a router over the given store with the given JWT keys
+func setupJwtKeysTestRouter(t *testing.T, store model.Store, jwtKeys string) (*gin.Engine, *controller.Controller) {
+	cfg := testConfig()
+	cfg.JwtKeys = jwtKeys
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return controller.SetupRouter(c), c
+}
+
+func testTokenKeyId(t *testing.T, gwtToken string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(gwtToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
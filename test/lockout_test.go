@@ -0,0 +1,210 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginLockoutMemory(t *testing.T) {
+	router, _, _ := setupTestRouterWithConfig(t, lockoutTestConfig())
+	doTestLoginLockout(t, router)
+}
+
+func TestLoginLockoutSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), lockoutTestConfig())
+	doTestLoginLockout(t, router)
+}
+
+func TestLoginLockoutParallelMemory(t *testing.T) {
+	router, _, _ := setupTestRouterWithConfig(t, lockoutTestConfig())
+	doTestLoginLockoutParallel(t, router)
+}
+
+func TestLoginLockoutParallelSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), lockoutTestConfig())
+	doTestLoginLockoutParallel(t, router)
+}
+
+func TestLoginLockoutByIp(t *testing.T) {
+	cfg := lockoutTestConfig()
+	cfg.LoginMaxIpFailures = 4
+	router, _, _ := setupTestRouterWithConfig(t, cfg)
+
+	// different accounts, none of them locked out, from one IP
+	for _, userName := range []string{"a", "b", "c", "d"} {
+		w := doTestLoginFrom(userName, "wrong", "198.51.100.1", router)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	}
+	w := doTestLoginFrom("User #3, Buyer", "3", "198.51.100.1", router)
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+
+	// other IPs are not affected
+	w = doTestLoginFrom("User #3, Buyer", "3", "198.51.100.2", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestLoginLockoutLogoutAll(t *testing.T) {
+	router, _, _ := setupTestRouterWithConfig(t, lockoutTestConfig())
+	for i := 0; i < 3; i++ {
+		w := doTestRequest("POST", "/api/v1/user/logout/all", `{"userName":"User #3, Buyer","password":"wrong"}`, "", router)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	}
+	w := doTestRequest("POST", "/api/v1/user/logout/all", `{"userName":"User #3, Buyer","password":"3"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	w = doTestLoginFrom("User #3, Buyer", "3", "198.51.100.2", router)
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+}
+
+func TestLoginLockoutResetOnSuccess(t *testing.T) {
+	router, _, _ := setupTestRouterWithConfig(t, lockoutTestConfig())
+	for i := 0; i < 2; i++ {
+		w := doTestLoginFrom("User #3, Buyer", "wrong", "198.51.100.1", router)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	}
+	doTestLogin(t, "User #3, Buyer", "3", "", router)
+	for i := 0; i < 2; i++ {
+		w := doTestLoginFrom("User #3, Buyer", "wrong", "198.51.100.1", router)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	}
+	doTestLogin(t, "User #3, Buyer", "3", "", router)
+}
+
+func TestLockoutPolicyBackoff(t *testing.T) {
+	policy := &model.LockoutPolicy{MaxFailures: 5, BaseLockout: time.Minute, MaxLockout: time.Hour, FailureWindow: time.Hour}
+	assert.Equal(t, policy.Lockout(4), time.Duration(0))
+	assert.Equal(t, policy.Lockout(5), time.Minute)
+	assert.Equal(t, policy.Lockout(6), 2*time.Minute)
+	assert.Equal(t, policy.Lockout(8), 8*time.Minute)
+	assert.Equal(t, policy.Lockout(11), time.Hour)
+	assert.Equal(t, policy.Lockout(1000), time.Hour)
+}
+
+func TestLockoutFailed(t *testing.T) {
+	router, _, _ := setupTestRouterWithConfig(t, lockoutTestConfig())
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+	buyer := doTestLogin(t, "User #3, Buyer", "3", "", router)
+
+	w := doTestRequest("GET", "/api/v1/lockouts", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("DELETE", "/api/v1/lockouts?kind=user&subject=x", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("DELETE", "/api/v1/lockouts?kind=device&subject=x", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("DELETE", "/api/v1/lockouts?kind=user&subject=x", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	cfg := lockoutTestConfig()
+	cfg.LoginMaxLockout = time.Second
+	_, err := controller.NewController(model.NewMemoryStore(), cfg)
+	assert.Equal(t, err, model.ErrInvalidLockoutPolicy)
+}
+
+// ------- implementation details ---------------
+
+// lockoutTestConfig locks an account out after 3 failed logins
+func lockoutTestConfig() (res *config.Config) {
+	res = testConfig()
+	res.LoginMaxFailures = 3
+	return
+}
+
+func doTestLoginLockout(t *testing.T, router *gin.Engine) {
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+
+	for i := 0; i < 3; i++ {
+		w := doTestLoginFrom("User #3, Buyer", "wrong", "198.51.100.1", router)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	}
+
+	// the right password is refused as well, from any IP
+	w := doTestLoginFrom("User #3, Buyer", "3", "198.51.100.2", router)
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter < 59 || retryAfter > 60 {
+		t.Fatalf("wrong Retry-After %d", retryAfter)
+	}
+	var httpErr httputil.HTTPError
+	err = json.Unmarshal(w.Body.Bytes(), &httpErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, httpErr.Message, model.ErrLoginLocked.Error())
+
+	// other accounts are not affected
+	doTestLogin(t, "User #1, Seller", "1", "", router)
+
+	w = doTestRequest("GET", "/api/v1/lockouts", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var lockouts []*model.LoginLockout
+	err = json.Unmarshal(w.Body.Bytes(), &lockouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userLockout, ipLockout *model.LoginLockout
+	for _, lockout := range lockouts {
+		switch {
+		case lockout.Kind == model.LockoutKindUser && lockout.Subject == "User #3, Buyer":
+			userLockout = lockout
+		case lockout.Kind == model.LockoutKindIp && lockout.Subject == "198.51.100.1":
+			ipLockout = lockout
+		}
+	}
+	if userLockout == nil || ipLockout == nil {
+		t.Fatalf("missing lockouts %s", w.Body.String())
+	}
+	assert.Equal(t, userLockout.Failures, 3)
+	assert.Equal(t, userLockout.Locked(time.Now()), true)
+	assert.Equal(t, ipLockout.Failures, 3)
+	assert.Equal(t, ipLockout.Locked(time.Now()), false)
+
+	w = doTestRequest("DELETE", "/api/v1/lockouts?kind=user&subject="+url.QueryEscape("User #3, Buyer"), "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	doTestLogin(t, "User #3, Buyer", "3", "", router)
+}
+
+func doTestLoginLockoutParallel(t *testing.T, router *gin.Engine) {
+	// the guesses sent at once get no more tries than those sent one by one
+	codes := make(chan int, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- doTestLoginFrom("User #3, Buyer", "wrong", "198.51.100.1", router).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, counts, map[int]int{http.StatusNotFound: 3, http.StatusTooManyRequests: 17})
+}
+
+// doTestLoginFrom sends a login from the given client IP
+func doTestLoginFrom(userName string, password string, ip string, router *gin.Engine) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(model.LoginRequest{UserName: userName, Password: password})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/user/login", strings.NewReader(string(body)))
+	req.RemoteAddr = ip + ":1234"
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -3,7 +3,6 @@ package test
 import (
 	"encoding/json"
 	"github.com/go-playground/assert/v2"
-	"github.com/oltur/mvp-match/controller"
 	"github.com/oltur/mvp-match/httputil"
 	"github.com/oltur/mvp-match/model"
 	"net/http"
@@ -14,9 +13,9 @@ import (
 )
 
 func TestLoginOk(t *testing.T) {
-	router, _ := controller.SetupRouter()
+	router, _, store := setupTestRouter(t)
 	w := httptest.NewRecorder()
-	model.UserLogout("1")
+	store.UserLogout("1")
 	reqBody := `{"userName":"User #1, Seller", "password": "1"}`
 	req, _ := http.NewRequest("POST", "/api/v1/user/login", strings.NewReader(reqBody))
 	router.ServeHTTP(w, req)
@@ -29,14 +28,22 @@ func TestLoginOk(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	in29Days := time.Now().Add(29 * 24 * time.Hour).UnixMilli()
-	if data.TokenExpires < in29Days {
+	in14Minutes := time.Now().Add(14 * time.Minute).UnixMilli()
+	in16Minutes := time.Now().Add(16 * time.Minute).UnixMilli()
+	if data.TokenExpires < in14Minutes || data.TokenExpires > in16Minutes {
 		t.Fatal("wrong tokenExpires")
 	}
+	in29Days := time.Now().Add(29 * 24 * time.Hour).UnixMilli()
+	if data.RefreshTokenExpires < in29Days {
+		t.Fatal("wrong refreshTokenExpires")
+	}
+	if data.RefreshToken == "" {
+		t.Fatal("no refreshToken")
+	}
 }
 
 func TestLoginFailed(t *testing.T) {
-	router, _ := controller.SetupRouter()
+	router, _, _ := setupTestRouter(t)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/product/999", nil)
 	router.ServeHTTP(w, req)
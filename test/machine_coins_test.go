@@ -0,0 +1,179 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"testing"
+)
+
+func TestLoadAndEmptyCoinsOk(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := doTestRequest("DELETE", "/api/v1/machine/coins", "", gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var removed []*model.CoinTube
+	err = json.Unmarshal(w.Body.Bytes(), &removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(removed), 5)
+
+	w = doTestRequest("POST", "/api/v1/machine/coins", `{"coins": [{"value": 10, "count": 3}, {"value": 50, "count": 1}]}`, gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doTestRequest("DELETE", "/api/v1/machine/coins?coinValue=50", "", gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doTestRequest("GET", "/api/v1/machine/coins", "", gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tubes []*model.CoinTube
+	err = json.Unmarshal(w.Body.Bytes(), &tubes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tubes, []*model.CoinTube{{Value: 10, Count: 3}})
+}
+
+func TestLoadCoinsFailedNotAdmin(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/machine/coins", `{"coins": [{"value": 10, "count": 3}]}`, gwtToken, router)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var res httputil.HTTPError
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Message, model.ErrInvalidAdmin.Error())
+}
+
+func TestLoadCoinsFailedWrongCoinValue(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/machine/coins", `{"coins": [{"value": 3, "count": 3}]}`, gwtToken, router)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBuyChangePaidFromInventory(t *testing.T) {
+	productId := types.Id("1")
+	router, c, store := setupTestRouter(t)
+	_, err := store.CoinsEmpty(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CoinsLoad([]*model.CoinTube{{Value: 20, Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 - 2 * 20 is paid with the 20s, as there is no 50 and 10
+	data, err := doTestBuyOk(productId, 2, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Change, []*model.Coin{{Value: 20}, {Value: 20}, {Value: 20}})
+
+	tubes, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tubes, []*model.CoinTube{{Value: 100, Count: 1}})
+}
+
+func TestBuyFailedCannotMakeChange(t *testing.T) {
+	userId := types.Id("3")
+	productId := types.Id("1")
+	router, c, store := setupTestRouter(t)
+	_, err := store.CoinsEmpty(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := doTestBuyFail(productId, 2, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Message, model.ErrCannotMakeChange.Error())
+
+	// nothing changed
+	p, err := store.ProductOne(productId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 1000)
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 100)
+
+	// exact amount is still accepted
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestBuyOk(productId, 10, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(data.Change), 0)
+}
+
+func TestResetReturnsCoinsFromInventory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	_, err := store.CoinsEmpty(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/reset", "", gwtToken, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res model.ResetResponse
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Change, []*model.Coin{{Value: 50}})
+
+	tubes, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(tubes), 0)
+}
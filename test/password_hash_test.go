@@ -0,0 +1,172 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/tools"
+	"github.com/oltur/mvp-match/types"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashMigrationMemory(t *testing.T) {
+	router, _, store := setupTestRouter(t)
+	doTestPasswordHashMigration(t, store, router)
+}
+
+func TestPasswordHashMigrationSql(t *testing.T) {
+	router, _, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestPasswordHashMigration(t, store, router)
+}
+
+func TestPasswordHashArgon2id(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordHash = model.PasswordHashArgon2id
+	cfg.PasswordHashCost = 1
+	router, _, store := setupTestRouterWithConfig(t, cfg)
+
+	w := doTestRequest("POST", "/api/v1/user", `{"userName":"argon","password":"argon secret","role":"buyer"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	users, err := store.UsersAll("argon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(users), 1)
+	if !strings.HasPrefix(users[0].PasswordHash, "$argon2id$v=19$m=19456,t=1,p=1$") {
+		t.Fatalf("wrong hash %s", users[0].PasswordHash)
+	}
+	doTestLogin(t, "argon", "argon secret", "", router)
+
+	// the seeded bcrypt hashes are replaced with argon2id ones as well
+	doTestLogin(t, "User #3, Buyer", "3", "", router)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$") {
+		t.Fatalf("wrong hash %s", user.PasswordHash)
+	}
+}
+
+func TestPasswordHashUpdate(t *testing.T) {
+	router, _, store := setupTestRouter(t)
+	login := doTestLogin(t, "User #3, Buyer", "3", "", router)
+
+	w := doTestRequest("PATCH", "/api/v1/user/3", `{"id":"3","password":"new secret"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$2a$04$") {
+		t.Fatalf("wrong hash %s", user.PasswordHash)
+	}
+	doTestLogin(t, "User #3, Buyer", "new secret", "", router)
+
+	// bcrypt takes 72 bytes at most
+	w = doTestRequest("PATCH", "/api/v1/user/3", `{"id":"3","password":"`+strings.Repeat("x", 73)+`"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func TestPasswordHashNotReturned(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+
+	responses := []string{
+		doTestRequest("POST", "/api/v1/user", `{"userName":"new buyer","password":"buyer secret","role":"buyer"}`, "", router).Body.String(),
+		doTestRequest("GET", "/api/v1/user/3", "", admin.Token, router).Body.String(),
+		doTestRequest("GET", "/api/v1/user", "", admin.Token, router).Body.String(),
+		doTestRequest("PUT", "/api/v1/user/3/roles", `{"roles":["buyer","seller"]}`, admin.Token, router).Body.String(),
+	}
+	for _, body := range responses {
+		assert.Equal(t, strings.Contains(body, `"userName"`), true)
+		assert.Equal(t, strings.Contains(body, "passwordHash"), false)
+		assert.Equal(t, strings.Contains(body, "$2a$"), false)
+	}
+}
+
+func TestPasswordHashUnknownUserVerified(t *testing.T) {
+	_, _, sqlStore := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	_, _, memoryStore := setupTestRouter(t)
+	for _, store := range []model.Store{memoryStore, sqlStore} {
+		// an unknown user name costs a verification as a wrong password does, not to tell the names apart by time
+		hasher := &countingPasswordHasher{PasswordHasher: model.BcryptPasswordHasher{Cost: bcrypt.MinCost}}
+		_, err := store.GetUserByCredentials("nobody", "secret", hasher)
+		assert.Equal(t, err, model.ErrNotFound)
+		assert.Equal(t, hasher.verified, 1)
+		_, err = store.GetUserByCredentials("User #3, Buyer", "wrong", hasher)
+		assert.Equal(t, err, model.ErrNotFound)
+		assert.Equal(t, hasher.verified, 2)
+	}
+}
+
+func TestPasswordHashFailedConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordHash = "md5"
+	_, err := controller.NewController(model.NewMemoryStore(), cfg)
+	assert.Equal(t, err, model.ErrInvalidPasswordHash)
+
+	cfg = testConfig()
+	cfg.PasswordHashCost = 99
+	_, err = controller.NewController(model.NewMemoryStore(), cfg)
+	assert.Equal(t, err, model.ErrInvalidPasswordHashCost)
+}
+
+// ------- implementation details ---------------
+
+// passwordTestStore is the part of the stores the password tests look into
+type passwordTestStore interface {
+	UserSave(req *model.User) (err error)
+	UserOne(id types.Id) (res *model.User, err error)
+}
+
+func doTestPasswordHashMigration(t *testing.T, store passwordTestStore, router *gin.Engine) {
+	// a user of the first versions, with an unsalted SHA-256 hash
+	legacy := &model.User{ID: "legacy", UserName: "legacy", PasswordHash: tools.Hash("secret"), Roles: []string{model.UserRoleBuyer}}
+	err := store.UserSave(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a wrong password does not touch the hash
+	w := doTestRequest("POST", "/api/v1/user/login", `{"userName":"legacy","password":"wrong"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	user, err := store.UserOne("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.PasswordHash, legacy.PasswordHash)
+
+	doTestLogin(t, "legacy", "secret", "", router)
+	user, err = store.UserOne("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$2a$04$") {
+		t.Fatalf("the hash is not migrated: %s", user.PasswordHash)
+	}
+
+	// the new hash works and stays
+	doTestLogin(t, "legacy", "secret", "", router)
+	again, err := store.UserOne("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, again.PasswordHash, user.PasswordHash)
+}
+
+// countingPasswordHasher counts the verifications of the hasher
+type countingPasswordHasher struct {
+	model.PasswordHasher
+	verified int
+}
+
+func (a *countingPasswordHasher) Verify(hash string, password string) (ok bool, rehash bool) {
+	a.verified++
+	return a.PasswordHasher.Verify(hash, password)
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"testing"
+)
+
+func TestAddUserPasswordPolicy(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	tests := []struct {
+		userName string
+		password string
+		rules    []string
+	}{
+		{"policy", "Vending-42", nil},
+		{"policy", "ab", []string{model.PasswordRuleMinLength, model.PasswordRuleClasses}},
+		{"policy", "abcdefghij", []string{model.PasswordRuleClasses}},
+		{"policy", "PASSWORD1", []string{model.PasswordRuleCommon}},
+		{"policy", "qwerty", []string{model.PasswordRuleMinLength, model.PasswordRuleClasses, model.PasswordRuleCommon}},
+		{"Buyer #99", "buyer #99", []string{model.PasswordRuleUserName}},
+		{"policy", string(make([]byte, 73)), []string{model.PasswordRuleMaxLength, model.PasswordRuleClasses}},
+	}
+	for _, test := range tests {
+		body, _ := json.Marshal(model.AddUserReq{UserName: test.userName, Password: test.password, Role: model.UserRoleBuyer})
+		w := doTestRequest("POST", "/api/v1/user", string(body), "", router)
+		if test.rules == nil {
+			assert.Equal(t, w.Code, http.StatusOK)
+			continue
+		}
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		violations := doTestViolations(t, w.Body.Bytes())
+		rules := make([]string, len(violations))
+		for i, violation := range violations {
+			assert.Equal(t, violation.Field, "password")
+			rules[i] = violation.Rule
+		}
+		assert.Equal(t, rules, test.rules)
+	}
+}
+
+func TestAddUserViolations(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	w := doTestRequest("POST", "/api/v1/user", `{"userName":"","password":"","role":"chief"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	violations := doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 3)
+	assert.Equal(t, *violations[0], model.Violation{Field: "userName", Rule: model.ValidationRuleRequired, Message: model.ErrInvalidUserName.Error()})
+	assert.Equal(t, *violations[1], model.Violation{Field: "password", Rule: model.ValidationRuleRequired, Message: model.ErrInvalidPassword.Error()})
+	assert.Equal(t, *violations[2], model.Violation{Field: "role", Rule: model.ValidationRuleAllowed, Message: model.ErrInvalidUserRole.Error()})
+}
+
+func TestUpdateUserPasswordPolicy(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	login := doTestLogin(t, "User #3, Buyer", "3", "", router)
+
+	w := doTestRequest("PATCH", "/api/v1/user/3", `{"id":"3","password":"user #3, buyer"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	violations := doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 1)
+	assert.Equal(t, violations[0].Rule, model.PasswordRuleUserName)
+
+	w = doTestRequest("PATCH", "/api/v1/user/3", `{"id":"3","password":""}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("PATCH", "/api/v1/user/3", `{"id":"3","password":"Vending-42"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestPasswordPolicyConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordMinLength = 1
+	cfg.PasswordMinClasses = 0
+	cfg.PasswordRejectCommon = false
+	router, _, _ := setupTestRouterWithConfig(t, cfg)
+	w := doTestRequest("POST", "/api/v1/user", `{"userName":"lax","password":"1","role":"buyer"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	for _, change := range []func(){
+		func() { cfg.PasswordMinLength = 0 },
+		func() { cfg.PasswordMinLength = model.PasswordMaxLength + 1 },
+		func() { cfg.PasswordMinClasses = 5 },
+	} {
+		cfg = testConfig()
+		change()
+		_, err := controller.NewController(model.NewMemoryStore(), cfg)
+		assert.Equal(t, err, model.ErrInvalidPasswordPolicy)
+	}
+}
+
+// ------- implementation details ---------------
+
+// testValidationError is the response of a validation error
+type testValidationError struct {
+	Details []*model.Violation `json:"details"`
+}
+
+func doTestViolations(t *testing.T, body []byte) []*model.Violation {
+	var res testValidationError
+	err := json.Unmarshal(body, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Details
+}
@@ -2,14 +2,13 @@ package test
 
 import (
 	"github.com/go-playground/assert/v2"
-	"github.com/oltur/mvp-match/controller"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 )
 
 func TestPing(t *testing.T) {
-	router, _ := controller.SetupRouter()
+	router, _, _ := setupTestRouter(t)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/tools/ping", nil)
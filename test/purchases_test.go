@@ -0,0 +1,167 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPurchaseHistoryMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestPurchaseHistory(t, router, c, store)
+}
+
+func TestPurchaseHistorySql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestPurchaseHistory(t, router, c, store)
+}
+
+func TestPurchaseSnapshotsProduct(t *testing.T) {
+	productId := types.Id("1")
+	router, c, store := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(20, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestBuyOk(productId, 1, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the product changes after the purchase
+	p, err := store.ProductOne(productId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ProductName = "Renamed"
+	p.Cost = 50
+	err = store.ProductSave(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := store.OrderOne(data.OrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *order.Lines[0], model.OrderLine{ProductId: productId, ProductName: "Product #1", SellerId: "1", Cost: 20, AmountOfProducts: 1, Total: 20})
+}
+
+func TestReceiptFailedNotOwner(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(20, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestBuyOk("1", 1, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sellerToken, _, err := c.DoLogin("User #1, Seller", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("GET", "/api/v1/purchases/"+string(data.OrderId)+"/receipt", "", sellerToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("GET", "/api/v1/purchases?buyerId=3", "", sellerToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doTestRequest("GET", "/api/v1/purchases/"+string(data.OrderId)+"/receipt", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/purchases/unknown/receipt", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+// ------- implementation details ---------------
+
+func doTestPurchaseHistory(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := doTestBuyOk("1", 2, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/checkout", `{"items":[{"productId":"1","amountOfProducts":1},{"productId":"3","amountOfProducts":2}]}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var second model.CheckoutResponse
+	err = json.Unmarshal(w.Body.Bytes(), &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = doTestRequest("GET", "/api/v1/purchases", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var orders []*model.Order
+	err = json.Unmarshal(w.Body.Bytes(), &orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(orders), 2)
+	assert.Equal(t, orders[0].ID, second.OrderId)
+	assert.Equal(t, orders[0].Total, 100)
+	assert.Equal(t, len(orders[0].Lines), 2)
+	assert.Equal(t, *orders[0].Lines[1], model.OrderLine{ProductId: "3", ProductName: "Product #3", SellerId: "2", Cost: 40, AmountOfProducts: 2, Total: 80})
+	assert.Equal(t, orders[1].ID, first.OrderId)
+	assert.Equal(t, orders[1].BuyerId, types.Id("3"))
+	assert.Equal(t, orders[1].Total, 40)
+	assert.Equal(t, orders[1].Change, []*model.Coin{{Value: 10}})
+
+	w = doTestRequest("GET", "/api/v1/purchases/"+string(first.OrderId)+"/receipt?format=json", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var receipt model.Receipt
+	err = json.Unmarshal(w.Body.Bytes(), &receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, receipt.OrderId, first.OrderId)
+	assert.Equal(t, receipt.Total, 40)
+	assert.Equal(t, receipt.Paid, 50)
+	assert.Equal(t, receipt.Currency, "EUR")
+
+	w = doTestRequest("GET", "/api/v1/purchases/"+string(first.OrderId)+"/receipt", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"), true)
+	text := w.Body.String()
+	assert.Equal(t, strings.Contains(text, "Receipt "+string(first.OrderId)), true)
+	assert.Equal(t, strings.Contains(text, "Product #1"), true)
+
+	w = doTestRequest("GET", "/api/v1/purchases/"+string(first.OrderId)+"/receipt?format=pdf", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	// the orders are kept by the store
+	order, err := store.OrderOne(second.OrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, order.Total, 100)
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefreshTokenMemory(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	doTestRefreshToken(t, router)
+}
+
+func TestRefreshTokenSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestRefreshToken(t, router)
+}
+
+func TestRefreshTokenReuseMemory(t *testing.T) {
+	router, _, store := setupTestRouter(t)
+	doTestRefreshTokenReuse(t, router)
+
+	audit, err := store.AuditQuery(&model.AuditQuery{ActorId: "3", EntityType: model.AuditEntitySession})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(audit), 1)
+	assert.Equal(t, audit[0].Action, model.AuditActionTokenReuse)
+}
+
+func TestRefreshTokenReuseSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestRefreshTokenReuse(t, router)
+}
+
+func TestRefreshTokenFailed(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	login := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+
+	w := doTestRefresh("not-a-token", router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("POST", "/api/v1/user/token/refresh", `{}`, "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	// the access token is not a refresh token
+	w = doTestRefresh(login.Token, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	// the refresh token ends with its session
+	w = doTestRequest("POST", "/api/v1/user/logout", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRefresh(login.RefreshToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+}
+
+// ------- implementation details ---------------
+
+func doTestRefreshToken(t *testing.T, router *gin.Engine) {
+	login := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+
+	refreshed := doTestRefreshOk(t, login.RefreshToken, router)
+	assert.Equal(t, refreshed.SessionId, login.SessionId)
+	assert.Equal(t, refreshed.RefreshTokenExpires, login.RefreshTokenExpires)
+	assert.NotEqual(t, refreshed.RefreshToken, login.RefreshToken)
+	if refreshed.TokenExpires < login.TokenExpires {
+		t.Fatal("wrong tokenExpires")
+	}
+
+	w := doTestRequest("GET", "/api/v1/user/3", "", refreshed.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// the new refresh token is exchanged in turn
+	again := doTestRefreshOk(t, refreshed.RefreshToken, router)
+	assert.Equal(t, again.SessionId, login.SessionId)
+}
+
+func doTestRefreshTokenReuse(t *testing.T, router *gin.Engine) {
+	login := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+	other := doTestLogin(t, "User #3, Buyer", "3", "machine", router)
+	refreshed := doTestRefreshOk(t, login.RefreshToken, router)
+
+	w := doTestRefresh(login.RefreshToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	// the whole session is revoked, its latest tokens included
+	w = doTestRefresh(refreshed.RefreshToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("GET", "/api/v1/user/3", "", refreshed.Token, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	// other sessions of the user are kept
+	w = doTestRequest("GET", "/api/v1/user/3", "", other.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	doTestRefreshOk(t, other.RefreshToken, router)
+}
+
+func doTestRefreshOk(t *testing.T, refreshToken string, router *gin.Engine) (res model.LoginResponse) {
+	w := doTestRefresh(refreshToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestRefresh(refreshToken string, router *gin.Engine) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(model.RefreshTokenRequest{RefreshToken: refreshToken})
+	return doTestRequest("POST", "/api/v1/user/token/refresh", string(body), "", router)
+}
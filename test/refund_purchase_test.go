@@ -0,0 +1,169 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefundPurchaseToDepositMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestRefundPurchaseToDeposit(t, router, c, store)
+}
+
+func TestRefundPurchaseToDepositSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestRefundPurchaseToDeposit(t, router, c, store)
+}
+
+func TestRefundPurchaseAsCoinsMemory(t *testing.T) {
+	router, c, store := setupTestRouter(t)
+	doTestRefundPurchaseAsCoins(t, router, c, store)
+}
+
+func TestRefundPurchaseAsCoinsSql(t *testing.T) {
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestRefundPurchaseAsCoins(t, router, c, store)
+}
+
+func TestRefundPurchaseFailed(t *testing.T) {
+	router, c, _ := setupTestRouter(t)
+	orderId, buyerToken := doTestBuyForRefund(t, router, c)
+
+	w := doTestRequest("POST", "/api/v1/purchases/"+string(orderId)+"/refund", `{"method":"deposit"}`, buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doTestRequest("POST", "/api/v1/purchases/"+string(orderId)+"/refund", `{"method":"cash"}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("POST", "/api/v1/purchases/unknown/refund", `{"method":"deposit"}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = doTestRequest("GET", "/api/v1/purchases/"+string(orderId)+"/refund", "", adminToken, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	w = doTestRequest("POST", "/api/v1/purchases/"+string(orderId)+"/refund", `{"method":"deposit"}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("POST", "/api/v1/purchases/"+string(orderId)+"/refund", `{"method":"deposit"}`, adminToken, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+}
+
+// ------- implementation details ---------------
+
+func doTestRefundPurchaseToDeposit(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	orderId, buyerToken := doTestBuyForRefund(t, router, c)
+
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := doTestRefundPurchase(t, orderId, `{"method":"deposit","reason":"not dispensed"}`, adminToken, router)
+	assert.Equal(t, refund.OrderId, orderId)
+	assert.Equal(t, refund.Amount, 40)
+	assert.Equal(t, refund.Reason, "not dispensed")
+	assert.Equal(t, refund.RefundedBy, types.Id("4"))
+
+	p, err := store.ProductOne("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 1000)
+	u, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 40)
+	balance, err := store.LedgerBalance(model.SellerAccount("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, balance, 0)
+
+	// the order and the seller entries link to the refund
+	order, err := store.OrderOne(orderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, order.RefundId, refund.ID)
+	entries, err := store.LedgerEntries(model.SellerAccount("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[1].Kind, model.LedgerKindRefund)
+	assert.Equal(t, entries[1].RefundId, refund.ID)
+	assert.Equal(t, entries[1].OrderId, orderId)
+
+	w := doTestRequest("GET", "/api/v1/purchases/"+string(orderId)+"/refund", "", buyerToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var shown model.OrderRefund
+	err = json.Unmarshal(w.Body.Bytes(), &shown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, shown.ID, refund.ID)
+	assert.Equal(t, shown.Method, model.RefundMethodDeposit)
+}
+
+func doTestRefundPurchaseAsCoins(t *testing.T, router *gin.Engine, c *controller.Controller, store model.Store) {
+	orderId, _ := doTestBuyForRefund(t, router, c)
+	tubesBefore, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, _, err := c.DoLogin("User #4, Admin", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := doTestRefundPurchase(t, orderId, `{"method":"coins"}`, adminToken, router)
+	assert.Equal(t, refund.Coins, []*model.Coin{{Value: 20}, {Value: 20}})
+
+	tubesAfter, err := store.CoinsAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.SumCoinCounts(model.CoinCountsFromTubes(tubesBefore))-model.SumCoinCounts(model.CoinCountsFromTubes(tubesAfter)), 40)
+	u, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 0)
+}
+
+// doTestBuyForRefund buys 2 x 20 of product #1 with exact coins and returns the order and the buyer token
+func doTestBuyForRefund(t *testing.T, router *gin.Engine, c *controller.Controller) (orderId types.Id, buyerToken string) {
+	buyerToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, coinValue := range []int{20, 20} {
+		_, err = doTestDeposit(coinValue, buyerToken, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := doTestBuyOk("1", 2, buyerToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data.OrderId, buyerToken
+}
+
+func doTestRefundPurchase(t *testing.T, orderId types.Id, body string, gwtToken string, router *gin.Engine) (res model.OrderRefund) {
+	w := doTestRequest("POST", "/api/v1/purchases/"+string(orderId)+"/refund", body, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
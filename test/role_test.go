@@ -0,0 +1,233 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/httputil"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRolesMemory(t *testing.T) {
+	router, _, store := setupTestRouter(t)
+	doTestRoles(t, router, store)
+}
+
+func TestRolesSql(t *testing.T) {
+	router, _, store := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), testConfig())
+	doTestRoles(t, router, store)
+}
+
+func TestRolesSeedSql(t *testing.T) {
+	// the roles created by the migration are the default ones
+	_, _, store := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), testConfig())
+	roles, err := store.RolesAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, roles, model.DefaultRoles())
+}
+
+func TestAddUserSeveralRoles(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	w := doTestRequest("POST", "/api/v1/user", `{"userName":"both","password":"Vending-42","role":"buyer","roles":["seller","buyer"]}`, "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var user model.User
+	err := json.Unmarshal(w.Body.Bytes(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Roles, []string{model.UserRoleBuyer, model.UserRoleSeller})
+
+	login := doTestLogin(t, "both", "Vending-42", "", router)
+	w = doTestRequest("POST", "/api/v1/deposit?coinValue=5", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("POST", "/api/v1/product", `{"productName":"Both","amountAvailable":1,"cost":5}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = doTestRequest("POST", "/api/v1/user", `{"userName":"chief","password":"Vending-42","roles":["admin"]}`, "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	violations := doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 1)
+	assert.Equal(t, *violations[0], model.Violation{Field: "roles", Rule: model.ValidationRuleAllowed, Message: model.ErrRoleNotRegistrable.Error()})
+
+	w = doTestRequest("POST", "/api/v1/user", `{"userName":"none","password":"Vending-42"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	violations = doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 1)
+	assert.Equal(t, violations[0].Rule, model.ValidationRuleRequired)
+}
+
+func TestProductOwnership(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	seller := doTestLogin(t, "User #2, Seller", "2", "", router)
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+
+	// product 1 is of seller 1
+	w := doTestRequest("PATCH", "/api/v1/product/1", `{"id":"1","productName":"Mine","amountAvailable":5,"cost":20}`, seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrWrongSeller.Error())
+	w = doTestRequest("DELETE", "/api/v1/product/1", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	w = doTestRequest("PATCH", "/api/v1/product/1", `{"id":"1","productName":"Fixed","amountAvailable":5,"cost":20}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("DELETE", "/api/v1/product/1", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+
+	// adding is for the own products only
+	w = doTestRequest("POST", "/api/v1/product", `{"productName":"Admin's","amountAvailable":1,"cost":5}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrInvalidSeller.Error())
+}
+
+// ------- implementation details ---------------
+
+func doTestRoles(t *testing.T, router *gin.Engine, store model.Store) {
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+	seller := doTestLogin(t, "User #1, Seller", "1", "", router)
+	buyer := doTestLogin(t, "User #3, Buyer", "3", "", router)
+
+	w := doTestRequest("GET", "/api/v1/roles", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrInvalidAdmin.Error())
+	w = doTestRequest("GET", "/api/v1/roles/permissions", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var permissions []string
+	err := json.Unmarshal(w.Body.Bytes(), &permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, permissions, model.Permissions)
+
+	// a role change applies to the next request
+	w = doTestRequest("PUT", "/api/v1/roles/seller", `{"permissions":["earnings:read:own"],"registrable":true}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("POST", "/api/v1/product", `{"productName":"New","amountAvailable":1,"cost":5}`, seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrInvalidSeller.Error())
+	w = doTestRequest("PUT", "/api/v1/roles/seller", `{"permissions":["product:write:own","earnings:read:own","product:write:own"],"registrable":true}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var role model.Role
+	err = json.Unmarshal(w.Body.Bytes(), &role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, role.Permissions, []string{model.PermissionEarningsReadOwn, model.PermissionProductWriteOwn})
+	w = doTestRequest("POST", "/api/v1/product", `{"productName":"New","amountAvailable":1,"cost":5}`, seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = doTestRequest("PUT", "/api/v1/roles/Bad%20Name", `{"permissions":["audit:read","audit:write"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	violations := doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 2)
+	assert.Equal(t, violations[0].Field, "name")
+	assert.Equal(t, *violations[1], model.Violation{Field: "permissions", Rule: model.ValidationRuleAllowed, Message: model.ErrInvalidPermission.Error()})
+
+	// a custom role held with another one
+	w = doTestRequest("PUT", "/api/v1/roles/auditor", `{"permissions":["audit:read"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/audit", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("PUT", "/api/v1/user/3/roles", `{"roles":["buyer","auditor","auditor"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	user, err := store.UserOne("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Roles, []string{model.UserRoleBuyer, "auditor"})
+	w = doTestRequest("GET", "/api/v1/audit", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("POST", "/api/v1/deposit?coinValue=5", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = doTestRequest("PUT", "/api/v1/user/3/roles", `{"roles":["chief"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("PUT", "/api/v1/user/3/roles", `{"roles":[]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("PUT", "/api/v1/user/999/roles", `{"roles":["buyer"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	// a role held by users cannot be deleted
+	w = doTestRequest("DELETE", "/api/v1/roles/auditor", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrRoleInUse.Error())
+	w = doTestRequest("PUT", "/api/v1/user/3/roles", `{"roles":["buyer"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("PUT", "/api/v1/totp/required-roles", `{"roles":["auditor"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("DELETE", "/api/v1/roles/auditor", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("DELETE", "/api/v1/roles/auditor", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	required, err := store.TotpRequiredRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, required, []string{})
+
+	// somebody must be left to manage the roles
+	w = doTestRequest("PUT", "/api/v1/user/4/roles", `{"roles":["buyer"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrNoRoleManager.Error())
+	w = doTestRequest("PUT", "/api/v1/roles/admin", `{"permissions":["audit:read"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+	w = doTestRequest("PUT", "/api/v1/user/1/roles", `{"roles":["seller","admin"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("PUT", "/api/v1/user/4/roles", `{"roles":["buyer"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/roles", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("GET", "/api/v1/roles", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var roles []*model.Role
+	err = json.Unmarshal(w.Body.Bytes(), &roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(roles), 3)
+	w = doTestRequest("PUT", "/api/v1/user/4/roles", `{"roles":["admin"]}`, seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	entries := doTestAudit(t, "entityType="+model.AuditEntityRole, admin.Token, router)
+	assert.Equal(t, len(entries), 4)
+	assert.Equal(t, entries[0].Action, model.AuditActionRoleSave)
+	assert.Equal(t, entries[3].Action, model.AuditActionRoleDelete)
+	entries = doTestAudit(t, "actorId=1", admin.Token, router)
+	assert.Equal(t, entries[len(entries)-1].Action, model.AuditActionUserRoles)
+	assert.Equal(t, entries[len(entries)-1].ActorRole, "seller,admin")
+
+	// nor can the last one allowed to manage the roles be deleted
+	w = doTestRequest("PUT", "/api/v1/user/1/roles", `{"roles":["seller"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("DELETE", "/api/v1/user/4", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+	assert.Equal(t, doTestErrorMessage(t, w), model.ErrNoRoleManager.Error())
+	_, err = store.UserOne("4")
+	assert.Equal(t, err, nil)
+	err = store.UserDelete("4")
+	assert.Equal(t, err, model.ErrNoRoleManager)
+	err = store.RoleSave(&model.Role{Name: model.UserRoleAdmin, Permissions: []string{model.PermissionAuditRead}})
+	assert.Equal(t, err, model.ErrNoRoleManager)
+	err = store.UserSetRoles("4", []string{model.UserRoleBuyer})
+	assert.Equal(t, err, model.ErrNoRoleManager)
+	user, err = store.UserOne("4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Roles, []string{model.UserRoleAdmin})
+}
+
+// doTestErrorMessage returns the message of an error response
+func doTestErrorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	var res httputil.HTTPError
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Message
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultipleSessionsMemory(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	doTestMultipleSessions(t, router)
+}
+
+func TestMultipleSessionsSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	doTestMultipleSessions(t, router)
+}
+
+func TestLogoutEndsCurrentSessionOnly(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	machine := doTestLogin(t, "User #3, Buyer", "3", "machine", router)
+	phone := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+
+	w := doTestRequest("POST", "/api/v1/user/logout", "", machine.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("GET", "/api/v1/user/3", "", machine.Token, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("GET", "/api/v1/user/3", "", phone.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestLogoutAllEndsAllSessions(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	machine := doTestLogin(t, "User #3, Buyer", "3", "machine", router)
+	phone := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+
+	w := doTestRequest("POST", "/api/v1/user/logout/all", `{"userName":"User #3, Buyer","password":"3"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	for _, token := range []string{machine.Token, phone.Token} {
+		w := doTestRequest("GET", "/api/v1/user/3", "", token, router)
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+	}
+
+	// a new login works
+	again := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+	w = doTestRequest("GET", "/api/v1/user/3", "", again.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestRevokeSessionFailed(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	buyer := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+	seller := doTestLogin(t, "User #1, Seller", "1", "machine", router)
+
+	// sessions of other users are not found
+	w := doTestRequest("DELETE", "/api/v1/user/sessions/"+string(buyer.SessionId), "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = doTestRequest("DELETE", "/api/v1/user/sessions/unknown", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = doTestRequest("GET", "/api/v1/user/3", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = doTestRequest("POST", "/api/v1/user/login", `{"userName":"User #3, Buyer","password":"3","device":"`+strings.Repeat("x", model.SessionDeviceMaxLength+1)+`"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+// ------- implementation details ---------------
+
+func doTestMultipleSessions(t *testing.T, router *gin.Engine) {
+	machine := doTestLogin(t, "User #3, Buyer", "3", "machine", router)
+	phone := doTestLogin(t, "User #3, Buyer", "3", "phone", router)
+	assert.NotEqual(t, machine.SessionId, phone.SessionId)
+
+	// both sessions share the deposit
+	_, err := doTestDeposit(50, machine.Token, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(20, phone.Token, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := doTestSessions(t, phone.Token, router)
+	assert.Equal(t, len(sessions), 2)
+	assert.Equal(t, sessions[0].ID, phone.SessionId)
+	assert.Equal(t, sessions[0].Device, "phone")
+	assert.Equal(t, sessions[0].Current, true)
+	assert.Equal(t, sessions[1].ID, machine.SessionId)
+	assert.Equal(t, sessions[1].Device, "machine")
+	assert.Equal(t, sessions[1].Current, false)
+	assert.Equal(t, sessions[1].ExpiresAt.UnixMilli(), machine.RefreshTokenExpires)
+	if sessions[1].LastSeenAt.Before(sessions[1].CreatedAt) {
+		t.Fatal("wrong lastSeenAt")
+	}
+
+	w := doTestRequest("DELETE", "/api/v1/user/sessions/"+string(machine.SessionId), "", phone.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("GET", "/api/v1/user/3", "", machine.Token, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	sessions = doTestSessions(t, phone.Token, router)
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].ID, phone.SessionId)
+}
+
+func doTestLogin(t *testing.T, userName string, password string, device string, router *gin.Engine) (res model.LoginResponse) {
+	body, err := json.Marshal(model.LoginRequest{UserName: userName, Password: password, Device: device})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doTestRequest("POST", "/api/v1/user/login", string(body), "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestSessions(t *testing.T, gwtToken string, router *gin.Engine) (res []*model.Session) {
+	w := doTestRequest("GET", "/api/v1/user/sessions", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
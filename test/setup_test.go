@@ -0,0 +1,55 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testConfig is the default configuration with the cheapest password hashing, so the tests stay fast
+func testConfig() *config.Config {
+	res := config.Default()
+	res.PasswordHashCost = bcrypt.MinCost
+	return res
+}
+
+// setupTestRouter creates a router backed by its own seeded in-memory store
+func setupTestRouter(t *testing.T) (*gin.Engine, *controller.Controller, *model.MemoryStore) {
+	return setupTestRouterWithConfig(t, testConfig())
+}
+
+// setupTestRouterWithConfig is setupTestRouter with a custom configuration
+func setupTestRouterWithConfig(t *testing.T, cfg *config.Config) (*gin.Engine, *controller.Controller, *model.MemoryStore) {
+	store := model.NewMemoryStore()
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.Seed(store, c.Currency(), c.PasswordHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return controller.SetupRouter(c), c, store
+}
+
+// doTestRequest sends a request with an optional JSON body and bearer token
+func doTestRequest(method string, url string, body string, gwtToken string, router *gin.Engine) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, r)
+	if gwtToken != "" {
+		req.Header.Add("Authorization", "Bearer "+gwtToken)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
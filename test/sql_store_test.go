@@ -0,0 +1,105 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/config"
+	"github.com/oltur/mvp-match/controller"
+	"github.com/oltur/mvp-match/model"
+	"github.com/oltur/mvp-match/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqlStoreBuyOk(t *testing.T) {
+	userId := types.Id("3")
+	productId := types.Id("1")
+	router, c, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(100, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := doTestBuyOk(productId, 2, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data.Total, 40)
+
+	p, err := store.ProductOne(productId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.AmountAvailable, 998)
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 0)
+}
+
+func TestSqlStorePersistsAcrossRestart(t *testing.T) {
+	userId := types.Id("3")
+	path := filepath.Join(t.TempDir(), "test.db")
+	router, c, store := setupSqlTestRouter(t, path)
+	gwtToken, _, err := c.DoLogin("User #3, Buyer", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doTestDeposit(50, gwtToken, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening applies no migrations twice and does not re-seed
+	_, _, store = setupSqlTestRouter(t, path)
+	u, err := store.UserOne(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Deposit, 50)
+	all, err := store.UsersAll("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(all), 4)
+}
+
+func TestSqlStoreDeleteNotFound(t *testing.T) {
+	_, _, store := setupSqlTestRouter(t, filepath.Join(t.TempDir(), "test.db"))
+	err := store.ProductDelete("999")
+	assert.Equal(t, err, model.ErrNotFound)
+	err = store.UserDelete("999")
+	assert.Equal(t, err, model.ErrNotFound)
+}
+
+// ------- implementation details ---------------
+
+func setupSqlTestRouter(t *testing.T, path string) (*gin.Engine, *controller.Controller, *model.SqlStore) {
+	return setupSqlTestRouterWithConfig(t, path, testConfig())
+}
+
+// setupSqlTestRouterWithConfig is setupSqlTestRouter with a custom configuration
+func setupSqlTestRouterWithConfig(t *testing.T, path string, cfg *config.Config) (*gin.Engine, *controller.Controller, *model.SqlStore) {
+	store, err := model.NewSqlStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	c, err := controller.NewController(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.SeedIfEmpty(store, c.Currency(), c.PasswordHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return controller.SetupRouter(c), c, store
+}
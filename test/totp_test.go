@@ -0,0 +1,256 @@
+package test
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/oltur/mvp-match/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotpMemory(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	doTestTotp(t, router)
+}
+
+func TestTotpSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), testConfig())
+	doTestTotp(t, router)
+}
+
+func TestTotpRequiredRolesMemory(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	doTestTotpRequiredRoles(t, router)
+}
+
+func TestTotpRequiredRolesSql(t *testing.T) {
+	router, _, _ := setupSqlTestRouterWithConfig(t, filepath.Join(t.TempDir(), "test.db"), testConfig())
+	doTestTotpRequiredRoles(t, router)
+}
+
+func TestTotpDisableAndReset(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+	login := doTestLogin(t, "User #1, Seller", "1", "", router)
+	_, recoveryCodes := doTestEnrollTotp(t, login.Token, router)
+
+	w := doTestRequest("POST", "/api/v1/user/totp/disable", `{"code":"000000","recoveryCode":"x"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("POST", "/api/v1/user/totp/disable", `{"recoveryCode":"wrong"}`, login.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("POST", "/api/v1/user/totp/disable", fmt.Sprintf(`{"recoveryCode":"%s"}`, recoveryCodes[0]), login.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("POST", "/api/v1/user/totp/disable", fmt.Sprintf(`{"recoveryCode":"%s"}`, recoveryCodes[1]), login.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	doTestLogin(t, "User #1, Seller", "1", "", router)
+
+	// an admin resets the second factor of a user who lost it
+	doTestEnrollTotp(t, login.Token, router)
+	w = doTestLoginFrom("User #1, Seller", "1", "198.51.100.1", router)
+	assert.Equal(t, w.Code, http.StatusAccepted)
+	w = doTestRequest("DELETE", "/api/v1/user/1/totp", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("DELETE", "/api/v1/user/1/totp", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("DELETE", "/api/v1/user/1/totp", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	doTestLogin(t, "User #1, Seller", "1", "", router)
+
+	audit := doTestAudit(t, "actorId=4&entityType=user&entityId=1", admin.Token, router)
+	assert.Equal(t, len(audit), 1)
+	assert.Equal(t, audit[0].Action, model.AuditActionTotpReset)
+}
+
+func TestTotpLogoutAll(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+	login := doTestLogin(t, "User #1, Seller", "1", "", router)
+	_, recoveryCodes := doTestEnrollTotp(t, login.Token, router)
+
+	// the password alone does not end the sessions
+	w := doTestRequest("POST", "/api/v1/user/logout/all", `{"userName":"User #1, Seller","password":"1"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("POST", "/api/v1/user/logout/all", `{"userName":"User #1, Seller","password":"1","recoveryCode":"wrong"}`, "", router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestRequest("GET", "/api/v1/user/1", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = doTestRequest("POST", "/api/v1/user/logout/all",
+		fmt.Sprintf(`{"userName":"User #1, Seller","password":"1","recoveryCode":"%s"}`, recoveryCodes[0]), "", router)
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = doTestRequest("GET", "/api/v1/user/1", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+}
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 test vector for SHA-1, cut to 6 digits
+	secret := []byte("12345678901234567890")
+	assert.Equal(t, model.TotpCode(secret, time.Unix(59, 0)), "287082")
+	assert.Equal(t, model.TotpCode(secret, time.Unix(1111111109, 0)), "081804")
+	assert.Equal(t, model.TotpCode(secret, time.Unix(2000000000, 0)), "279037")
+
+	totp := &model.Totp{Secret: secret}
+	now := time.Unix(1111111109, 0)
+	_, ok := totp.Verify(model.TotpCode(secret, now.Add(-model.TotpPeriod)), now)
+	assert.Equal(t, ok, true)
+	_, ok = totp.Verify(model.TotpCode(secret, now.Add(3*model.TotpPeriod)), now)
+	assert.Equal(t, ok, false)
+	_, ok = totp.Verify("12345", now)
+	assert.Equal(t, ok, false)
+
+	uri, err := url.Parse(totp.ProvisioningUri("User #1, Seller"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uri.Scheme, "otpauth")
+	assert.Equal(t, uri.Host, "totp")
+	assert.Equal(t, uri.Query().Get("secret"), totp.EncodedSecret())
+	assert.Equal(t, uri.Query().Get("issuer"), model.TotpIssuer)
+}
+
+// ------- implementation details ---------------
+
+func doTestTotp(t *testing.T, router *gin.Engine) {
+	login := doTestLogin(t, "User #1, Seller", "1", "", router)
+	secret, recoveryCodes := doTestEnrollTotp(t, login.Token, router)
+	assert.Equal(t, len(recoveryCodes), model.TotpRecoveryCodes)
+
+	w := doTestRequest("POST", "/api/v1/user/totp", "", login.Token, router)
+	assert.Equal(t, w.Code, http.StatusConflict)
+
+	// the password alone gives a challenge, not a session
+	challenge := doTestLoginChallenge(t, router)
+	assert.Equal(t, challenge.TotpRequired, true)
+	w = doTestRequest("GET", "/api/v1/user/sessions", "", challenge.ChallengeToken, router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	// the code of the confirmation is used up, the next one is accepted once
+	w = doTestLoginTotp(challenge.ChallengeToken, model.TotpCode(secret, time.Now().Add(-model.TotpPeriod)), "", router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	next := model.TotpCode(secret, time.Now().Add(model.TotpPeriod))
+	w = doTestLoginTotp(challenge.ChallengeToken, next, "", router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var tokens model.LoginResponse
+	err := json.Unmarshal(w.Body.Bytes(), &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doTestRequest("GET", "/api/v1/user/sessions", "", tokens.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestLoginTotp(challenge.ChallengeToken, next, "", router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	// a recovery code works once
+	challenge = doTestLoginChallenge(t, router)
+	w = doTestLoginTotp(challenge.ChallengeToken, "", recoveryCodes[0], router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestLoginTotp(challenge.ChallengeToken, "", recoveryCodes[0], router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestLoginTotp(challenge.ChallengeToken, "", strings.ToUpper(recoveryCodes[1]), router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// an access token is not a challenge
+	w = doTestLoginTotp(tokens.Token, "", recoveryCodes[2], router)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	w = doTestLoginTotp(challenge.ChallengeToken, "", "", router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func doTestTotpRequiredRoles(t *testing.T, router *gin.Engine) {
+	admin := doTestLogin(t, "User #4, Admin", "4", "", router)
+	seller := doTestLogin(t, "User #1, Seller", "1", "", router)
+
+	w := doTestRequest("PUT", "/api/v1/totp/required-roles", `{"roles":["seller"]}`, seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w = doTestRequest("PUT", "/api/v1/totp/required-roles", `{"roles":["seller","chief"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	violations := doTestViolations(t, w.Body.Bytes())
+	assert.Equal(t, len(violations), 1)
+	assert.Equal(t, violations[0].Field, "roles")
+
+	w = doTestRequest("PUT", "/api/v1/totp/required-roles", `{"roles":["seller","seller"]}`, admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = doTestRequest("GET", "/api/v1/totp/required-roles", "", admin.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var roles model.TotpRequiredRolesRequest
+	err := json.Unmarshal(w.Body.Bytes(), &roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, roles.Roles, []string{model.UserRoleSeller})
+
+	// a seller without a second factor can only enroll one
+	w = doTestRequest("GET", "/api/v1/user/sessions", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	login := doTestLogin(t, "User #1, Seller", "1", "", router)
+	assert.Equal(t, login.TotpEnrollmentRequired, true)
+	doTestEnrollTotp(t, login.Token, router)
+	w = doTestRequest("GET", "/api/v1/user/sessions", "", seller.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// other roles are not affected
+	buyer := doTestLogin(t, "User #3, Buyer", "3", "", router)
+	assert.Equal(t, buyer.TotpEnrollmentRequired, false)
+	w = doTestRequest("GET", "/api/v1/user/sessions", "", buyer.Token, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	audit := doTestAudit(t, "entityType="+model.AuditEntitySettings, admin.Token, router)
+	assert.Equal(t, len(audit), 1)
+	assert.Equal(t, audit[0].Action, model.AuditActionTotpRoles)
+}
+
+// doTestEnrollTotp enrolls and confirms a second factor, it returns the secret and the recovery codes
+func doTestEnrollTotp(t *testing.T, gwtToken string, router *gin.Engine) (secret []byte, recoveryCodes []string) {
+	w := doTestRequest("POST", "/api/v1/user/totp", "", gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var enrollment model.TotpEnrollmentResponse
+	err := json.Unmarshal(w.Body.Bytes(), &enrollment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(enrollment.ProvisioningUri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uri.Query().Get("secret"), enrollment.Secret)
+	secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = doTestRequest("POST", "/api/v1/user/totp/confirm", `{"code":"wrong"}`, gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = doTestRequest("POST", "/api/v1/user/totp/confirm", fmt.Sprintf(`{"code":"%s"}`, model.TotpCode(secret, time.Now())), gwtToken, router)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var res model.RecoveryCodesResponse
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes = res.RecoveryCodes
+	return
+}
+
+// doTestLoginChallenge sends the password of the enrolled seller and returns the challenge
+func doTestLoginChallenge(t *testing.T, router *gin.Engine) (res model.LoginChallengeResponse) {
+	w := doTestLoginFrom("User #1, Seller", "1", "198.51.100.1", router)
+	assert.Equal(t, w.Code, http.StatusAccepted)
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func doTestLoginTotp(challengeToken string, code string, recoveryCode string, router *gin.Engine) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(model.LoginTotpRequest{ChallengeToken: challengeToken,
+		TotpCodeRequest: model.TotpCodeRequest{Code: code, RecoveryCode: recoveryCode}})
+	return doTestRequest("POST", "/api/v1/user/login/totp", string(body), "", router)
+}
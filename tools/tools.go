@@ -15,6 +15,17 @@ func FindStringInSlice(slice []string, elem string) (res bool) {
 	return
 }
 
+// UniqueStrings returns the non-empty strings of the slice without duplicates, in their order
+func UniqueStrings(slice []string) (res []string) {
+	res = []string{}
+	for _, s := range slice {
+		if s != "" && !FindStringInSlice(res, s) {
+			res = append(res, s)
+		}
+	}
+	return
+}
+
 func Hash(s string) string {
 	data := []byte(s)
 	r := sha256.Sum256(data)